@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+
+	"learn_go/recordsclient"
+)
+
+func listCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("list", e)
+	format := fs.String("format", "table", "output format: table, json or csv")
+	artist := fs.String("artist", "", "only list albums by this artist (case-insensitive)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatTable, formatJSON, formatCSV); err != nil {
+		return err
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	albums, err := client.ListAlbums(ctx)
+	if err != nil {
+		return err
+	}
+	if *artist != "" {
+		filtered := albums[:0]
+		for _, a := range albums {
+			if strings.EqualFold(a.Artist, *artist) {
+				filtered = append(filtered, a)
+			}
+		}
+		albums = filtered
+	}
+	return writeAlbums(e.stdout, *format, albums)
+}
+
+func getCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("get", e)
+	format := fs.String("format", "table", "output format: table, json or csv")
+	if err := parse(fs, args, "ID"); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatTable, formatJSON, formatCSV); err != nil {
+		return err
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	album, err := client.GetAlbum(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return writeAlbums(e.stdout, *format, []recordsclient.Album{album})
+}
+
+func addCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("add", e)
+	format := fs.String("format", "table", "output format: table, json or csv")
+	var album recordsclient.Album
+	fs.StringVar(&album.ID, "id", "", "ID of the new album (generated by the server if empty)")
+	fs.StringVar(&album.Title, "title", "", "title (required)")
+	fs.StringVar(&album.Artist, "artist", "", "artist (required)")
+	fs.Float64Var(&album.Price, "price", 0, "price, greater than 0 (required)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatTable, formatJSON, formatCSV); err != nil {
+		return err
+	}
+	if problems := validateAlbum(album); len(problems) > 0 {
+		return usageError{strings.Join(problems, "; ")}
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	created, err := client.CreateAlbum(ctx, album)
+	if err != nil {
+		return err
+	}
+	return writeAlbums(e.stdout, *format, []recordsclient.Album{created})
+}
+
+func updateCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("update", e)
+	format := fs.String("format", "table", "output format: table, json or csv")
+	title := fs.String("title", "", "new title")
+	artist := fs.String("artist", "", "new artist")
+	price := fs.Float64("price", math.NaN(), "new price, greater than 0")
+	if err := parse(fs, args, "ID"); err != nil {
+		return err
+	}
+	if err := checkFormat(*format, formatTable, formatJSON, formatCSV); err != nil {
+		return err
+	}
+	if *title == "" && *artist == "" && math.IsNaN(*price) {
+		return usagef("nothing to update; give at least one of -title, -artist or -price")
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	// Only the given fields change, so start from the album as it is now.
+	album, err := client.GetAlbum(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *title != "" {
+		album.Title = *title
+	}
+	if *artist != "" {
+		album.Artist = *artist
+	}
+	if !math.IsNaN(*price) {
+		album.Price = *price
+	}
+	if problems := validateAlbum(album); len(problems) > 0 {
+		return usageError{strings.Join(problems, "; ")}
+	}
+
+	updated, err := client.UpdateAlbum(ctx, album)
+	if err != nil {
+		return err
+	}
+	return writeAlbums(e.stdout, *format, []recordsclient.Album{updated})
+}
+
+func deleteCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("delete", e)
+	if err := parse(fs, args, "ID"); err != nil {
+		return err
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	if err := client.DeleteAlbum(ctx, fs.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "deleted album %s\n", fs.Arg(0))
+	return nil
+}
+
+// `importCommand` adds every album of a file. All records are validated before anything is sent,
+// so a file with mistakes is rejected as a whole.
+func importCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("import", e)
+	format := fs.String("format", "", "file format: json or csv (default: from the file extension)")
+	update := fs.Bool("update", false, "update albums whose ID already exists instead of failing; albums without an ID are never updated")
+	if err := parse(fs, args, "FILE"); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = formatFromPath(path)
+	}
+	if err := checkFormat(*format, formatJSON, formatCSV); err != nil {
+		return err
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	in := e.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	records, err := readAlbums(in, *format)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, r := range records {
+		for _, p := range validateAlbum(r.album) {
+			problems = append(problems, fmt.Sprintf("%s: %s", r.location, p))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s), nothing imported:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+
+	var created, updated, failed int
+	for _, r := range records {
+		_, err := client.CreateAlbum(ctx, r.album)
+		if *update && r.album.ID != "" && idTaken(err) {
+			_, err = client.UpdateAlbum(ctx, r.album)
+			if err == nil {
+				updated++
+				continue
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(e.stderr, "%s: %s\n", r.location, describe(err))
+			continue
+		}
+		created++
+	}
+
+	fmt.Fprintf(e.stdout, "created %d, updated %d, failed %d\n", created, updated, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d album(s) could not be imported", failed)
+	}
+	return nil
+}
+
+// `idTaken` reports whether `err` is the conflict of an album ID that already exists. An album that is
+// refused for looking like an existing one conflicts too, but has no ID of its own to update.
+func idTaken(err error) bool {
+	var apiErr *recordsclient.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict && apiErr.Type != "/problems/duplicate"
+}
+
+func exportCommand(ctx context.Context, e env, args []string) error {
+	fs, conn := newFlagSet("export", e)
+	format := fs.String("format", "", "file format: json or csv (default: from the file extension, else json)")
+	path := fs.String("file", "-", "file to write, - for standard output")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatFromPath(*path)
+	}
+	if err := checkFormat(*format, formatJSON, formatCSV); err != nil {
+		return err
+	}
+	client, err := conn.client()
+	if err != nil {
+		return err
+	}
+
+	albums, err := client.ListAlbums(ctx)
+	if err != nil {
+		return err
+	}
+	if *path == "-" {
+		return writeAlbums(e.stdout, *format, albums)
+	}
+
+	f, err := os.Create(*path)
+	if err != nil {
+		return err
+	}
+	if err := writeAlbums(f, *format, albums); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "exported %d album(s) to %s\n", len(albums), *path)
+	return nil
+}
+
+// `validateAlbum` mirrors the server's rules, so mistakes are reported before anything is sent.
+func validateAlbum(a recordsclient.Album) []string {
+	var problems []string
+	if strings.TrimSpace(a.Title) == "" {
+		problems = append(problems, "title is required")
+	}
+	if strings.TrimSpace(a.Artist) == "" {
+		problems = append(problems, "artist is required")
+	}
+	if !(a.Price > 0) {
+		problems = append(problems, fmt.Sprintf("price must be greater than 0, got %v", a.Price))
+	}
+	return problems
+}
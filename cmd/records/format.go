@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"learn_go/recordsclient"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+var csvHeader = []string{"id", "title", "artist", "price"}
+
+func checkFormat(format string, allowed ...string) error {
+	if !slices.Contains(allowed, format) {
+		return usagef("unknown format %q; use one of %s", format, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// `formatFromPath` guesses the file format from its extension, defaulting to JSON.
+func formatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return formatCSV
+	}
+	return formatJSON
+}
+
+func writeAlbums(w io.Writer, format string, albums []recordsclient.Album) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(albums)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, a := range albums {
+			cw.Write([]string{a.ID, a.Title, a.Artist, strconv.FormatFloat(a.Price, 'f', 2, 64)})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tARTIST\tPRICE")
+		for _, a := range albums {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\n", a.ID, a.Title, a.Artist, a.Price)
+		}
+		return tw.Flush()
+	}
+}
+
+// `record` is an album read from a file, with its location for error messages.
+type record struct {
+	location string
+	album    recordsclient.Album
+}
+
+func readAlbums(r io.Reader, format string) ([]record, error) {
+	if format == formatJSON {
+		var albums []recordsclient.Album
+		if err := json.NewDecoder(r).Decode(&albums); err != nil {
+			return nil, fmt.Errorf("reading JSON: %w", err)
+		}
+		records := make([]record, len(albums))
+		for i, a := range albums {
+			records[i] = record{location: fmt.Sprintf("album %d", i+1), album: a}
+		}
+		return records, nil
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	if !slices.Equal(header, csvHeader) {
+		return nil, fmt.Errorf("CSV header must be %q, got %q", strings.Join(csvHeader, ","), strings.Join(header, ","))
+	}
+
+	var records []record
+	for line := 2; ; line++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		location := fmt.Sprintf("line %d", line)
+		price, err := strconv.ParseFloat(strings.TrimSpace(fields[3]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: price %q is not a number", location, fields[3])
+		}
+		records = append(records, record{
+			location: location,
+			album:    recordsclient.Album{ID: fields[0], Title: fields[1], Artist: fields[2], Price: price},
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Command records manages the album catalogue of the records API from the command line.
+// It only talks to the HTTP API (through `recordsclient`), never to the storage directly.
+//
+//	go run ./cmd/records list
+//	go run ./cmd/records add -title "Blue Train" -artist "John Coltrane" -price 56.99
+//	go run ./cmd/records export -format csv -file albums.csv
+//
+// The server and credentials come from the `-server`, `-token`, `-user` and `-password` flags of every
+// subcommand, or from the `RECORDS_SERVER`, `RECORDS_TOKEN`, `RECORDS_USER` and `RECORDS_PASSWORD` environment variables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"learn_go/recordsclient"
+)
+
+const usage = `Usage: records <command> [flags] [arguments]
+
+Commands:
+  list      list all albums:                 records list [-format table|json|csv]
+  get       show one album:                  records get ID
+  add       add an album:                    records add -title T -artist A -price P
+  update    change fields of an album:       records update [-title T] [-artist A] [-price P] ID
+  delete    delete an album:                 records delete ID
+  import    add albums from a JSON/CSV file: records import [-format json|csv] [-update] FILE
+  export    write all albums to a file:      records export [-format json|csv] [-file FILE]
+
+Run 'records <command> -h' for the flags of a command.
+`
+
+// `usageError` is a mistake on the command line. It makes `records` exit with status 2 instead of 1.
+type usageError struct{ message string }
+
+func (e usageError) Error() string { return e.message }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// `env` is what a command may use besides its arguments, so that tests can capture the output.
+type env struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	getenv         func(string) string
+}
+
+type command func(ctx context.Context, e env, args []string) error
+
+var commands = map[string]command{
+	"list":   listCommand,
+	"get":    getCommand,
+	"add":    addCommand,
+	"update": updateCommand,
+	"delete": deleteCommand,
+	"import": importCommand,
+	"export": exportCommand,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv}))
+}
+
+// `run` executes the command line `args` and returns the exit status.
+func run(ctx context.Context, args []string, e env) int {
+	if len(args) < 1 {
+		fmt.Fprint(e.stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] != "-h" && args[0] != "help" {
+			fmt.Fprintf(e.stderr, "records: unknown command %q\n\n", args[0])
+		}
+		fmt.Fprint(e.stderr, usage)
+		return 2
+	}
+
+	err := cmd(ctx, e, args[1:])
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(e.stderr, "records %s: %v\n", args[0], err)
+		return 2
+	default:
+		fmt.Fprintf(e.stderr, "records %s: %v\n", args[0], describe(err))
+		return 1
+	}
+}
+
+// `describe` turns API errors into something readable for people who don't know HTTP status codes.
+func describe(err error) string {
+	var apiErr *recordsclient.APIError
+	if !errors.As(err, &apiErr) {
+		return err.Error()
+	}
+	switch {
+	case errors.Is(err, recordsclient.ErrNotFound):
+		return "not found: " + apiErr.Message
+	case errors.Is(err, recordsclient.ErrUnauthorized):
+		return "access denied, check -token or -user/-password: " + apiErr.Message
+	case errors.Is(err, recordsclient.ErrConflict):
+		return "conflict: " + apiErr.Message
+	case errors.Is(err, recordsclient.ErrInvalid):
+		return "rejected by the server: " + apiErr.Message
+	}
+	return fmt.Sprintf("server error (%d): %s", apiErr.StatusCode, apiErr.Message)
+}
+
+// `connection` holds the flags every command has for reaching the server.
+type connection struct {
+	server, token, user, password string
+	timeout                       time.Duration
+}
+
+func newFlagSet(name string, e env) (*flag.FlagSet, *connection) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+
+	conn := &connection{}
+	server := e.getenv("RECORDS_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	fs.StringVar(&conn.server, "server", server, "base URL of the records API (env RECORDS_SERVER)")
+	fs.StringVar(&conn.token, "token", e.getenv("RECORDS_TOKEN"), "bearer token (env RECORDS_TOKEN)")
+	fs.StringVar(&conn.user, "user", e.getenv("RECORDS_USER"), "user for basic authentication (env RECORDS_USER)")
+	fs.StringVar(&conn.password, "password", e.getenv("RECORDS_PASSWORD"), "password for basic authentication (env RECORDS_PASSWORD)")
+	fs.DurationVar(&conn.timeout, "timeout", 30*time.Second, "timeout of a single request")
+	return fs, conn
+}
+
+func (c *connection) client() (*recordsclient.Client, error) {
+	opts := []recordsclient.Option{recordsclient.WithTimeout(c.timeout)}
+	switch {
+	case c.token != "" && c.user != "":
+		return nil, usagef("use either -token or -user/-password, not both")
+	case c.token != "":
+		opts = append(opts, recordsclient.WithBearerToken(c.token))
+	case c.user != "":
+		opts = append(opts, recordsclient.WithBasicAuth(c.user, c.password))
+	}
+	client, err := recordsclient.New(c.server, opts...)
+	if err != nil {
+		return nil, usageError{err.Error()}
+	}
+	return client, nil
+}
+
+// `parse` parses the flags and checks the number of positional arguments.
+func parse(fs *flag.FlagSet, args []string, positional ...string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	if fs.NArg() != len(positional) {
+		if len(positional) == 0 {
+			return usagef("unexpected arguments %q", fs.Args())
+		}
+		return usagef("expected %d argument(s): %v", len(positional), positional)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	recordsAPI "learn_go/records_api"
+	"learn_go/recordsclient"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// `testRecords` runs `records` against a fresh records API and returns its exit status and output.
+type testRecords struct {
+	t      *testing.T
+	server string
+}
+
+func newTestRecords(t *testing.T) *testRecords {
+	handler, err := recordsAPI.NewHandler([]string{"-cover-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &testRecords{t: t, server: server.URL}
+}
+
+func (r *testRecords) run(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	getenv := func(key string) string {
+		if key == "RECORDS_SERVER" {
+			return r.server
+		}
+		return ""
+	}
+	code := run(context.Background(), args, env{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr, getenv: getenv})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestListFormats(t *testing.T) {
+	r := newTestRecords(t)
+
+	code, out, _ := r.run("", "list")
+	if code != 0 || !strings.HasPrefix(out, "ID  TITLE") || !strings.Contains(out, "Blue Train") {
+		t.Errorf("list = %d\n%s", code, out)
+	}
+
+	code, out, _ = r.run("", "list", "-format", "csv", "-artist", "john coltrane")
+	if code != 0 || out != "id,title,artist,price\n1,Blue Train,John Coltrane,56.99\n" {
+		t.Errorf("list -format csv = %d\n%s", code, out)
+	}
+
+	code, out, _ = r.run("", "get", "-format", "json", "2")
+	var albums []recordsclient.Album
+	if code != 0 || json.Unmarshal([]byte(out), &albums) != nil || albums[0].Title != "Jeru" {
+		t.Errorf("get -format json = %d\n%s", code, out)
+	}
+}
+
+func TestAddUpdateDelete(t *testing.T) {
+	r := newTestRecords(t)
+
+	code, out, errOut := r.run("", "add", "-format", "csv", "-title", "Giant", "-artist", "Coltrane", "-price", "19.99")
+	if code != 0 {
+		t.Fatalf("add = %d: %s", code, errOut)
+	}
+	id := strings.Split(strings.Split(out, "\n")[1], ",")[0]
+
+	if code, out, _ := r.run("", "update", "-format", "csv", "-price", "9.5", id); code != 0 || !strings.Contains(out, "Giant,Coltrane,9.50") {
+		t.Errorf("update = %d\n%s", code, out)
+	}
+	if code, _, _ := r.run("", "delete", id); code != 0 {
+		t.Errorf("delete = %d", code)
+	}
+	if code, _, errOut := r.run("", "get", id); code != 1 || !strings.Contains(errOut, "not found") {
+		t.Errorf("get after delete = %d: %s", code, errOut)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	r := newTestRecords(t)
+
+	code, _, errOut := r.run("", "add", "-title", "Giant", "-price", "-3")
+	if code != 2 || !strings.Contains(errOut, "artist is required") || !strings.Contains(errOut, "price must be greater than 0") {
+		t.Errorf("add with missing fields = %d: %s", code, errOut)
+	}
+	if code, _, _ := r.run("", "list", "-format", "yaml"); code != 2 {
+		t.Errorf("unknown format = %d; want 2", code)
+	}
+	if code, _, _ := r.run("", "get"); code != 2 {
+		t.Errorf("get without ID = %d; want 2", code)
+	}
+	if code, _, _ := r.run("", "frobnicate"); code != 2 {
+		t.Errorf("unknown command = %d; want 2", code)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	r := newTestRecords(t)
+	file := filepath.Join(t.TempDir(), "albums.csv")
+
+	if code, _, errOut := r.run("", "export", "-file", file); code != 0 {
+		t.Fatalf("export = %d: %s", code, errOut)
+	}
+	exported, _ := os.ReadFile(file)
+	if !strings.HasPrefix(string(exported), "id,title,artist,price\n") {
+		t.Fatalf("exported CSV:\n%s", exported)
+	}
+
+	code, _, errOut := r.run("id,title,artist,price\n,Giant,Coltrane,0\n,,Mingus,3\n", "import", "-format", "csv", "-")
+	if code != 1 || !strings.Contains(errOut, "line 2: price must be greater than 0") || !strings.Contains(errOut, "line 3: title is required") {
+		t.Errorf("import of invalid CSV = %d: %s", code, errOut)
+	}
+
+	code, out, errOut := r.run(`[{"id":"1","title":"Blue","artist":"Coltrane","price":1},{"title":"Giant","artist":"Coltrane","price":2}]`,
+		"import", "-update", "-")
+	if code != 0 || out != "created 1, updated 1, failed 0\n" {
+		t.Errorf("import -update = %d: %s%s", code, out, errOut)
+	}
+
+	// An album that only looks like an existing one has no ID to update, so it is reported.
+	code, out, errOut = r.run(`[{"title":"Jeru","artist":"Gerry Mulligan","price":1}]`, "import", "-update", "-")
+	if code != 1 || out != "created 0, updated 0, failed 1\n" || !strings.Contains(errOut, "conflict: ") {
+		t.Errorf("import -update of a likely duplicate = %d: %s%s", code, out, errOut)
+	}
+}
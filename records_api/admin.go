@@ -0,0 +1,390 @@
+package records_api
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// The admin console is rendered on the server from templates and static files embedded in the binary.
+//
+//go:embed admin/templates admin/static
+var adminFiles embed.FS
+
+// `adminPages` holds one template set per page, each combining the page with the shared layout.
+var adminPages = func() map[string]*template.Template {
+	funcs := template.FuncMap{"field": newFormField}
+	pages := map[string]*template.Template{}
+	for _, page := range []string{"list", "form"} {
+		pages[page] = template.Must(template.New(page).Funcs(funcs).ParseFS(adminFiles,
+			"admin/templates/layout.html", "admin/templates/"+page+".html"))
+	}
+	return pages
+}()
+
+// `adminPage` is the data every admin template is executed with.
+type adminPage struct {
+	Title     string
+	Query     string
+	Notice    string
+	CSRFToken string
+
+	Albums []album
+
+	// The album form: where it posts to, the submitted values and the validation message per field.
+	Action string
+	Values map[string]string
+	Errors map[string]string
+	// Albums the new one looks like; the form then offers to create it anyway. See `findDuplicates`.
+	Duplicates []duplicateCandidate
+}
+
+// `formField` is what the `field` template needs to render one input with its inline error.
+type formField struct {
+	Name, Label, Type, Value, Error string
+}
+
+func newFormField(page adminPage, name, label, inputType string) formField {
+	return formField{Name: name, Label: label, Type: inputType, Value: page.Values[name], Error: page.Errors[name]}
+}
+
+// `registerAdminRoutes` mounts the admin console under `/admin`. All routes but the backup and the restore
+// go on `catalogue`, the group that shares the catalogue; see `shareCatalogue`. Those two hold it only
+// while they copy or swap the data, so a slow download or upload doesn't hold up other requests.
+func (s *server) registerAdminRoutes(router *gin.Engine, catalogue *gin.RouterGroup) {
+	static, _ := fs.Sub(adminFiles, "admin/static")
+	admin := catalogue.Group("/admin", requireAdmin(s.cfg), adminContentSecurity, csrfProtect)
+	admin.StaticFS("/static", http.FS(static))
+	admin.GET("", func(c *gin.Context) { c.Redirect(http.StatusFound, "/admin/albums") })
+	admin.GET("/albums", s.adminListAlbums)
+	admin.GET("/albums/new", s.adminNewAlbum)
+	admin.POST("/albums", s.adminCreateAlbum)
+	admin.GET("/albums/:id/edit", s.adminEditAlbum)
+	admin.POST("/albums/:id", s.adminUpdateAlbum)
+	admin.POST("/albums/:id/delete", s.adminDeleteAlbum)
+
+	// Backups are made by scripts rather than the console, so they skip its CSRF check; see `postRestore`.
+	router.POST("/admin/backup", requireAdmin(s.cfg), s.postBackup)
+	router.POST("/admin/restore", requireAdmin(s.cfg), limitBody(s.cfg.BackupMaxBytes), s.postRestore)
+
+	// Promotions are managed by scripts too; see `bindPromotion`.
+	promotions := catalogue.Group("/admin/promotions", requireAdmin(s.cfg))
+	promotions.GET("", s.getPromotions)
+	promotions.POST("", s.postPromotion)
+	promotions.GET("/:id", s.getPromotion)
+	promotions.PUT("/:id", s.putPromotion)
+	promotions.DELETE("/:id", s.deletePromotion)
+}
+
+// `requireAdmin` lets through requests with the admin credentials from `cfg`: HTTP basic authentication
+// with `-admin-user` and `-admin-password`, or `Authorization: Bearer` with `-admin-token`.
+// Without any configured credentials, the admin endpoints don't exist.
+func requireAdmin(cfg config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.AdminPassword == "" && cfg.AdminToken == "" {
+			writeProblem(c, problemNotFound, "admin access is not configured")
+			return
+		}
+		if isAdmin(cfg, c) {
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Basic realm="records admin", charset="UTF-8"`)
+		writeProblem(c, problemUnauthorized, "admin credentials required")
+	}
+}
+
+// `isAdmin` reports whether the request carries the admin credentials from `cfg`; see `requireAdmin`.
+func isAdmin(cfg config, c *gin.Context) bool {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && cfg.AdminToken != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1 {
+			return true
+		}
+	}
+	if user, password, ok := c.Request.BasicAuth(); ok && cfg.AdminPassword != "" {
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.AdminUser)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(cfg.AdminPassword)) == 1
+		return userOK && passwordOK
+	}
+	return false
+}
+
+// `adminContentSecurity` relaxes the API's content security policy just enough for the console's own
+// stylesheet, cover thumbnails and forms.
+func adminContentSecurity(c *gin.Context) {
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'self'; img-src 'self'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'")
+	c.Next()
+}
+
+const (
+	csrfCookie = "records_admin_csrf"
+	csrfField  = "csrf_token"
+	csrfKey    = "csrfToken"
+)
+
+// `csrfProtect` guards the console's forms with a double-submit token: a random value in a cookie
+// that every form posts back. Another site can make the browser send the cookie, but can't read it
+// to put it in the form. Cross-origin posts are also refused by their `Origin` header.
+func csrfProtect(c *gin.Context) {
+	token, err := c.Cookie(csrfCookie)
+	if err != nil || len(token) != 43 {
+		b := make([]byte, 32)
+		rand.Read(b)
+		token = base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name: csrfCookie, Value: token, Path: "/admin",
+			HttpOnly: true, Secure: c.Request.TLS != nil, SameSite: http.SameSiteStrictMode,
+		})
+	}
+	c.Set(csrfKey, token)
+
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+	if origin := c.GetHeader("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != c.Request.Host {
+			writeProblem(c, problemForbidden, "cross-origin form submission refused")
+			return
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(c.PostForm(csrfField)), []byte(token)) != 1 {
+		writeProblem(c, problemForbidden, "invalid or missing CSRF token; reload the page and try again")
+		return
+	}
+	c.Next()
+}
+
+func (s *server) renderAdmin(c *gin.Context, status int, name string, page adminPage) {
+	page.CSRFToken = c.GetString(csrfKey)
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	if err := adminPages[name].ExecuteTemplate(c.Writer, "layout", page); err != nil {
+		logError(c.Request.Context(), err)
+	}
+}
+
+// `adminListAlbums` lists the albums, optionally only those whose title or artist contains `?q=`.
+func (s *server) adminListAlbums(c *gin.Context) {
+	albums, err := s.albums.List(c.Request.Context())
+	if err != nil {
+		c.String(http.StatusInternalServerError, "could not list albums")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query != "" {
+		q := strings.ToLower(query)
+		albums = slices.DeleteFunc(slices.Clone(albums), func(a album) bool {
+			return !strings.Contains(strings.ToLower(a.Title), q) && !strings.Contains(strings.ToLower(a.Artist), q)
+		})
+	}
+
+	var notice string
+	if id := c.Query("saved"); id != "" {
+		notice = fmt.Sprintf("Album %s was saved.", id)
+	} else if id := c.Query("deleted"); id != "" {
+		notice = fmt.Sprintf("Album %s was deleted.", id)
+	}
+	s.renderAdmin(c, http.StatusOK, "list", adminPage{Title: "Albums", Query: query, Notice: notice, Albums: albums})
+}
+
+func (s *server) adminNewAlbum(c *gin.Context) {
+	s.renderAdmin(c, http.StatusOK, "form", adminPage{Title: "New album", Action: "/admin/albums"})
+}
+
+func (s *server) adminEditAlbum(c *gin.Context) {
+	a, err := s.albums.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		s.adminLookupFailed(c, err)
+		return
+	}
+	s.renderAdmin(c, http.StatusOK, "form", adminPage{
+		Title:  "Edit " + a.Title,
+		Action: "/admin/albums/" + url.PathEscape(a.ID),
+		Values: albumFormValues(a),
+	})
+}
+
+// `adminCreateAlbum` adds the album from the form. Like `postAlbums`, it refuses one that looks like an
+// album already in the catalogue, listing the likely matches, unless "Create anyway" is ticked.
+func (s *server) adminCreateAlbum(c *gin.Context) {
+	a, values, problems := parseAlbumForm(c)
+	if len(problems) > 0 {
+		s.renderAdmin(c, http.StatusUnprocessableEntity, "form", adminPage{Title: "New album", Action: "/admin/albums", Values: values, Errors: problems})
+		return
+	}
+	if c.PostForm("force") != "true" && s.cfg.DuplicateThreshold > 0 {
+		catalogue, err := s.albums.List(c.Request.Context())
+		if err != nil {
+			c.String(http.StatusInternalServerError, "could not list albums")
+			logError(c.Request.Context(), err)
+			return
+		}
+		if matches := findDuplicates(a, catalogue, s.cfg.DuplicateThreshold); len(matches) > 0 {
+			page := adminPage{Title: "New album", Action: "/admin/albums", Values: values}
+			for _, m := range matches {
+				page.Duplicates = append(page.Duplicates, duplicateCandidate{Album: m.album, Score: m.score})
+			}
+			s.renderAdmin(c, http.StatusConflict, "form", page)
+			return
+		}
+	}
+	created, err := s.albums.Create(c.Request.Context(), a)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "could not create album")
+		logError(c.Request.Context(), err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/albums?saved="+url.QueryEscape(created.ID))
+}
+
+func (s *server) adminUpdateAlbum(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	a, values, problems := parseAlbumForm(c)
+	if len(problems) > 0 {
+		existing, err := s.albums.Get(ctx, id)
+		if err != nil {
+			s.adminLookupFailed(c, err)
+			return
+		}
+		s.renderAdmin(c, http.StatusUnprocessableEntity, "form", adminPage{
+			Title:  "Edit " + existing.Title,
+			Action: "/admin/albums/" + url.PathEscape(existing.ID),
+			Values: values,
+			Errors: problems,
+		})
+		return
+	}
+	// The form only covers the basic fields; everything else is kept from the album as it is in the
+	// transaction, so a cover or track list stored at the same time isn't lost.
+	err := s.albums.Transaction(ctx, func(tx albumStore) error {
+		existing, err := tx.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		existing.Title, existing.Artist, existing.Price, existing.Year, existing.Genres = a.Title, a.Artist, a.Price, a.Year, a.Genres
+		_, err = tx.Update(ctx, existing)
+		return err
+	})
+	if err != nil {
+		s.adminLookupFailed(c, err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/admin/albums?saved="+url.QueryEscape(id))
+}
+
+func (s *server) adminDeleteAlbum(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.albums.Delete(c.Request.Context(), id); err != nil {
+		s.adminLookupFailed(c, err)
+		return
+	}
+	s.reviews.DeleteAlbum(id)
+	c.Redirect(http.StatusSeeOther, "/admin/albums?deleted="+url.QueryEscape(id))
+}
+
+func (s *server) adminLookupFailed(c *gin.Context, err error) {
+	if errors.Is(err, errAlbumNotFound) {
+		c.String(http.StatusNotFound, "album not found")
+		return
+	}
+	c.String(http.StatusInternalServerError, "could not get album")
+	logError(c.Request.Context(), err)
+}
+
+func albumFormValues(a album) map[string]string {
+	values := map[string]string{
+		"title":  a.Title,
+		"artist": a.Artist,
+		"price":  strconv.FormatFloat(a.Price, 'f', 2, 64),
+		"genres": strings.Join(a.Genres, ", "),
+	}
+	if a.Year != 0 {
+		values["year"] = strconv.Itoa(a.Year)
+	}
+	return values
+}
+
+// `parseAlbumForm` reads the album form and validates it with the same rules as the JSON API.
+// It returns the submitted values, to show them again, and a message for every invalid field.
+func parseAlbumForm(c *gin.Context) (album, map[string]string, map[string]string) {
+	values := map[string]string{}
+	for _, name := range []string{"title", "artist", "price", "year", "genres"} {
+		values[name] = strings.TrimSpace(c.PostForm(name))
+	}
+	problems := map[string]string{}
+
+	a := album{Title: values["title"], Artist: values["artist"]}
+	if values["price"] != "" {
+		price, err := strconv.ParseFloat(values["price"], 64)
+		if err != nil {
+			problems["price"] = "Enter a number, such as 19.99."
+		}
+		a.Price = price
+	}
+	if values["year"] != "" {
+		year, err := strconv.Atoi(values["year"])
+		if err != nil {
+			problems["year"] = "Enter a year, such as 1959."
+		}
+		a.Year = year
+	}
+	for _, genre := range strings.Split(values["genres"], ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			a.Genres = append(a.Genres, genre)
+		}
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if errors.As(binding.Validator.ValidateStruct(&a), &fieldErrors) {
+		for _, fe := range fieldErrors {
+			// Errors of single genres, e.g. `Genres[2]`, belong to the genres field.
+			name, _, _ := strings.Cut(strings.ToLower(fe.StructField()), "[")
+			// Parse errors are more helpful than the validation errors they cause.
+			problems[name] = cmp.Or(problems[name], formMessage(fe))
+		}
+	}
+	return a, values, problems
+}
+
+// `formMessage` phrases a validation error for the console's users.
+func formMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "This field is required."
+	case "max":
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("Use at most %s characters.", fe.Param())
+		case reflect.Slice:
+			return fmt.Sprintf("Use at most %s values.", fe.Param())
+		}
+		return fmt.Sprintf("Must be at most %s.", fe.Param())
+	case "min":
+		return fmt.Sprintf("Must be at least %s.", fe.Param())
+	case "gt":
+		return fmt.Sprintf("Must be greater than %s.", fe.Param())
+	default:
+		return "This value is not valid."
+	}
+}
@@ -0,0 +1,197 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// `adminSession` drives the admin console like a browser: it keeps the CSRF cookie and
+// picks the token out of the last page.
+type adminSession struct {
+	t      *testing.T
+	router http.Handler
+	cookie *http.Cookie
+	token  string
+}
+
+func newAdminSession(t *testing.T) *adminSession {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminPassword = "secret"
+	return &adminSession{t: t, router: newRouter(newServer(cfg, newMemoryStore(albums)))}
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func (s *adminSession) do(method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.SetBasicAuth("admin", "secret")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if s.cookie != nil {
+		req.AddCookie(s.cookie)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == csrfCookie {
+			s.cookie = c
+		}
+	}
+	if m := csrfInput.FindStringSubmatch(w.Body.String()); m != nil {
+		s.token = m[1]
+	}
+	return w
+}
+
+func TestAdminRequiresCredentials(t *testing.T) {
+	if w := serve(newVersionTestRouter(t, "v1"), http.MethodGet, "/admin/albums", ""); w.Code != http.StatusNotFound {
+		t.Errorf("admin without configured credentials = %d; want 404", w.Code)
+	}
+
+	s := newAdminSession(t)
+	w := serve(s.router, http.MethodGet, "/admin/albums", "")
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Errorf("admin without credentials = %d %v; want 401 with a basic challenge", w.Code, w.Header())
+	}
+}
+
+func TestAdminListAndSearch(t *testing.T) {
+	s := newAdminSession(t)
+
+	w := s.do(http.MethodGet, "/admin/albums", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Blue Train") || !strings.Contains(w.Body.String(), "Jeru") {
+		t.Fatalf("list = %d\n%s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Header().Get("Content-Security-Policy"), "style-src 'self'") {
+		t.Errorf("CSP = %q; want the stylesheet allowed", w.Header().Get("Content-Security-Policy"))
+	}
+
+	w = s.do(http.MethodGet, "/admin/albums?q=mulligan", nil)
+	if !strings.Contains(w.Body.String(), "Jeru") || strings.Contains(w.Body.String(), "Blue Train") {
+		t.Errorf("search for mulligan:\n%s", w.Body)
+	}
+
+	// User input is escaped.
+	w = s.do(http.MethodGet, "/admin/albums?q="+url.QueryEscape("<script>"), nil)
+	if strings.Contains(w.Body.String(), "<script>") {
+		t.Error("search query is not escaped")
+	}
+
+	if w := s.do(http.MethodGet, "/admin/static/admin.css", nil); w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("stylesheet = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestAdminCreateAndEdit(t *testing.T) {
+	s := newAdminSession(t)
+	s.do(http.MethodGet, "/admin/albums/new", nil)
+
+	w := s.do(http.MethodPost, "/admin/albums", url.Values{"csrf_token": {s.token}, "title": {""}, "artist": {"Coltrane"}, "price": {"cheap"}})
+	body := w.Body.String()
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(body, "This field is required.") || !strings.Contains(body, "Enter a number") {
+		t.Fatalf("invalid form = %d\n%s", w.Code, body)
+	}
+	// The submitted values are shown again.
+	if !strings.Contains(body, `value="Coltrane"`) {
+		t.Error("submitted artist is missing from the form")
+	}
+
+	w = s.do(http.MethodPost, "/admin/albums", url.Values{"csrf_token": {s.token}, "title": {"Giant"}, "artist": {"Coltrane"}, "price": {"9.99"}, "genres": {"jazz, hard bop"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/albums?saved=4" {
+		t.Fatalf("create = %d %v", w.Code, w.Header())
+	}
+
+	w = s.do(http.MethodGet, "/admin/albums/4/edit", nil)
+	if !strings.Contains(w.Body.String(), `value="jazz, hard bop"`) {
+		t.Errorf("edit form:\n%s", w.Body)
+	}
+	w = s.do(http.MethodPost, "/admin/albums/4", url.Values{"csrf_token": {s.token}, "title": {"Giant"}, "artist": {"Coltrane"}, "price": {"12"}, "year": {"1960"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("update = %d\n%s", w.Code, w.Body)
+	}
+	if w := serve(s.router, http.MethodGet, "/albums/4", ""); !strings.Contains(w.Body.String(), `"year": 1960`) {
+		t.Errorf("album after edit = %s", w.Body)
+	}
+
+	if w := s.do(http.MethodPost, "/admin/albums/4/delete", url.Values{"csrf_token": {s.token}}); w.Code != http.StatusSeeOther {
+		t.Errorf("delete = %d", w.Code)
+	}
+}
+
+func TestAdminCSRF(t *testing.T) {
+	s := newAdminSession(t)
+	s.do(http.MethodGet, "/admin/albums", nil)
+
+	form := url.Values{"title": {"Giant"}, "artist": {"Coltrane"}, "price": {"9.99"}}
+	if w := s.do(http.MethodPost, "/admin/albums", form); w.Code != http.StatusForbidden {
+		t.Errorf("post without a token = %d; want 403", w.Code)
+	}
+	form.Set("csrf_token", "forged")
+	if w := s.do(http.MethodPost, "/admin/albums", form); w.Code != http.StatusForbidden {
+		t.Errorf("post with a wrong token = %d; want 403", w.Code)
+	}
+
+	form.Set("csrf_token", s.token)
+	req := httptest.NewRequest(http.MethodPost, "/admin/albums/1/delete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	req.SetBasicAuth("admin", "secret")
+	req.AddCookie(s.cookie)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin post = %d; want 403", w.Code)
+	}
+}
+
+func TestAdminCreateWarnsAboutDuplicates(t *testing.T) {
+	s := newAdminSession(t)
+	s.do(http.MethodGet, "/admin/albums/new", nil)
+
+	form := url.Values{"csrf_token": {s.token}, "title": {"Blue Train"}, "artist": {"J. Coltrane"}, "price": {"9.99"}}
+	w := s.do(http.MethodPost, "/admin/albums", form)
+	body := w.Body.String()
+	if w.Code != http.StatusConflict || !strings.Contains(body, `<a href="/admin/albums/1/edit">Blue Train</a>`) || !strings.Contains(body, `name="force"`) {
+		t.Fatalf("likely duplicate = %d\n%s", w.Code, body)
+	}
+	if !strings.Contains(body, `value="J. Coltrane"`) {
+		t.Error("submitted artist is missing from the form")
+	}
+
+	form.Set("csrf_token", s.token)
+	form.Set("force", "true")
+	if w := s.do(http.MethodPost, "/admin/albums", form); w.Code != http.StatusSeeOther {
+		t.Errorf("create anyway = %d\n%s", w.Code, w.Body)
+	}
+}
+
+func TestAdminUpdateKeepsConcurrentChanges(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminPassword = "secret"
+	cfg.CacheSize = 0
+	store := &racingStore{memoryStore: newMemoryStore(albums), race: func(a *album) {
+		a.Tracks = []track{{Side: "A", Position: 1, Title: "Blue Train", Duration: "PT10M43S"}}
+	}}
+	s := &adminSession{t: t, router: newRouter(newServer(cfg, store))}
+	// The list reads no single album, so the first `Get` is the update's own.
+	s.do(http.MethodGet, "/admin/albums", nil)
+
+	w := s.do(http.MethodPost, "/admin/albums/1", url.Values{"csrf_token": {s.token}, "title": {"Blue Train"}, "artist": {"John Coltrane"}, "price": {"12"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("update = %d\n%s", w.Code, w.Body)
+	}
+	var a album
+	json.Unmarshal(serve(s.router, http.MethodGet, "/albums/1", "").Body.Bytes(), &a)
+	if a.Price != 12 || len(a.Tracks) != 1 {
+		t.Errorf("album = %+v; want the new price and the tracks", a)
+	}
+}
@@ -2,14 +2,20 @@ package records_api
 
 // Represents data about a record album
 type album struct {
-	ID		string 		`json:"id"`
-	Title	string 		`json:"title" binding:"required,max=10"`
-	Artist	string 		`json:"artist" binding:"required,max=10"`
-	Price	float64 	`json:"price" binding:"required,gt=0"`
+	ID     string  `json:"id"`
+	Title  string  `json:"title" binding:"required,max=200"`
+	Artist string  `json:"artist" binding:"required,max=100"`
+	Price  float64 `json:"price" binding:"required,gt=0"`
+	// Genre tags such as "jazz" or "hard bop", compared case-insensitively.
+	Genres []string `json:"genres,omitempty" binding:"max=10,dive,required,max=30"`
+	// Release year, if known.
+	Year  int         `json:"year,omitempty" binding:"omitempty,min=1900,max=2100"`
+	Cover *albumCover `json:"cover,omitempty"`
+	// Set through `PUT /albums/:id/tracks`, which also computes the running time.
+	Tracks      []track      `json:"tracks,omitempty"`
+	RunningTime *runningTime `json:"runningTime,omitempty"`
+	// Filled in from the reviews on every response; ignored in requests.
+	Reviews reviewSummary `json:"reviews"`
+	// Filled in from the running promotions on every response; ignored in requests.
+	Pricing *albumPricing `json:"pricing,omitempty"`
 }
-
-var albums = []album {
-	{ID: "1", Title: "Blue Train", Artist: "John Coltrane", Price: 56.99},
-	{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 17.99},
-	{ID: "3", Title: "Sarah Vaughan and Clifford Brown", Artist: "Sarah Vaughan", Price: 39.99},
-}
\ No newline at end of file
@@ -0,0 +1,463 @@
+package records_api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// A backup is a gzip-compressed tar archive. Its first file is `manifest.json`, which names every other
+// file with its size and SHA-256; restoring refuses archives whose files don't match it.
+const (
+	backupFormat         = "records-backup"
+	backupVersion        = 2
+	backupManifestName   = "manifest.json"
+	backupAlbumsName     = "albums.json"
+	backupReviewsName    = "reviews.json"
+	backupPromotionsName = "promotions.json"
+	backupOrdersName     = "orders.json"
+	backupWishlistsName  = "wishlists.json"
+	// Cover images are stored as `covers/<sha256>`, as they were uploaded.
+	backupCoversDir = "covers/"
+)
+
+var errBackupTooLarge = errors.New("backup is too large once unpacked")
+
+type backupManifest struct {
+	Format    string       `json:"format"`
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Files     []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// `backupData` is everything a backup holds. Albums include their cover metadata and tracks, and the
+// cover images themselves are read from and written to the `coverStore`.
+type backupData struct {
+	CreatedAt  time.Time
+	Albums     []album
+	Reviews    []review
+	Promotions []promotion
+	Orders     []order
+	// The wishlists by customer, oldest item first.
+	Wishlists map[string][]wishlistItem
+}
+
+// `files` pairs the name of every JSON file of a backup with the data it holds.
+func (data *backupData) files() []struct {
+	name  string
+	value any
+} {
+	return []struct {
+		name  string
+		value any
+	}{
+		{backupAlbumsName, &data.Albums},
+		{backupReviewsName, (*backupReviews)(&data.Reviews)},
+		{backupPromotionsName, &data.Promotions},
+		{backupOrdersName, &data.Orders},
+		{backupWishlistsName, &data.Wishlists},
+	}
+}
+
+// `backupReviews` keeps the hash of each review's delete token in backups, which responses leave out.
+type backupReviews []review
+
+type backupReview struct {
+	review
+	DeleteTokenHash string `json:"deleteTokenHash,omitempty"`
+}
+
+func (reviews backupReviews) MarshalJSON() ([]byte, error) {
+	out := make([]backupReview, len(reviews))
+	for i, r := range reviews {
+		out[i] = backupReview{r, r.deleteTokenHash}
+	}
+	return json.Marshal(out)
+}
+
+func (reviews *backupReviews) UnmarshalJSON(data []byte) error {
+	var in []backupReview
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*reviews = make(backupReviews, len(in))
+	for i, r := range in {
+		r.review.deleteTokenHash = r.DeleteTokenHash
+		(*reviews)[i] = r.review
+	}
+	return nil
+}
+
+// `covers` returns the covers of the albums, each image once.
+func (data backupData) covers() []albumCover {
+	var covers []albumCover
+	seen := map[string]bool{}
+	for _, a := range data.Albums {
+		if a.Cover != nil && !seen[a.Cover.SHA256] {
+			seen[a.Cover.SHA256] = true
+			covers = append(covers, *a.Cover)
+		}
+	}
+	return covers
+}
+
+// `snapshot` takes a consistent copy of the catalogue. It shares the catalogue while it copies (see
+// `shareCatalogue`), so no restore happens meanwhile, and no album is written while it is taken, so the
+// reviews match the albums. Only the copying happens in the transaction, so reads are barely held up.
+func (s *server) snapshot(ctx context.Context) (backupData, error) {
+	s.catalogue.RLock()
+	defer s.catalogue.RUnlock()
+
+	data := backupData{CreatedAt: time.Now().UTC()}
+	err := s.albums.Transaction(ctx, func(tx albumStore) (err error) {
+		if data.Albums, err = tx.List(ctx); err != nil {
+			return err
+		}
+		data.Reviews = s.reviews.All()
+		data.Promotions = s.promotions.List()
+		data.Orders = s.orders.Snapshot()
+		data.Wishlists = s.wishlists.Lists()
+		return nil
+	})
+	for i := range data.Albums {
+		// Computed from the reviews and promotions on every response.
+		data.Albums[i].Reviews, data.Albums[i].Pricing = reviewSummary{}, nil
+	}
+	return data, err
+}
+
+// `writeBackup` writes `data` to `w` as a backup archive, with the cover images from `covers`.
+func writeBackup(w io.Writer, data backupData, covers *coverStore) error {
+	manifest := backupManifest{Format: backupFormat, Version: backupVersion, CreatedAt: data.CreatedAt}
+	var contents [][]byte
+	for _, f := range data.files() {
+		body, err := json.MarshalIndent(f.value, "", "\t")
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		manifest.Files = append(manifest.Files, backupFile{Name: f.name, Size: int64(len(body)), SHA256: hex.EncodeToString(sum[:])})
+		contents = append(contents, body)
+	}
+	// Covers are addressed by their SHA-256, so they can be listed without reading them.
+	for _, cover := range data.covers() {
+		manifest.Files = append(manifest.Files, backupFile{Name: backupCoversDir + cover.SHA256, Size: cover.Size, SHA256: cover.SHA256})
+	}
+	manifestBody, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, size int64, body io.Reader) error {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: data.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, body)
+		return err
+	}
+	if err := add(backupManifestName, int64(len(manifestBody)), bytes.NewReader(manifestBody)); err != nil {
+		return err
+	}
+	for i, f := range manifest.Files {
+		if i < len(contents) {
+			err = add(f.Name, f.Size, bytes.NewReader(contents[i]))
+		} else {
+			err = addCover(add, f, covers)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addCover(add func(name string, size int64, body io.Reader) error, f backupFile, covers *coverStore) error {
+	image, err := covers.Open(f.SHA256, 0)
+	if err != nil {
+		return fmt.Errorf("cover %s: %w", f.SHA256, err)
+	}
+	defer image.Close()
+	return add(f.Name, f.Size, image)
+}
+
+// `readBackup` reads a backup archive and checks every file against the manifest. Its files may add
+// up to at most `maxUnpacked` bytes. Cover images are saved to `covers` as they are read; since they
+// are addressed by content, that changes nothing for the albums until the backup is restored.
+func readBackup(r io.Reader, maxUnpacked int64, covers *coverStore) (backupData, error) {
+	var data backupData
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return data, fmt.Errorf("not a gzip file: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != backupManifestName {
+		return data, fmt.Errorf("archive must start with %s", backupManifestName)
+	}
+	// The tar reader stops at the end of each file, so checking the sizes in the headers bounds what is decompressed.
+	unpacked := header.Size
+	if unpacked > maxUnpacked {
+		return data, errBackupTooLarge
+	}
+	var manifest backupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return data, fmt.Errorf("%s: %w", backupManifestName, err)
+	}
+	if manifest.Format != backupFormat || manifest.Version != backupVersion {
+		return data, fmt.Errorf("unsupported backup format %s version %d; this server reads %s version %d",
+			manifest.Format, manifest.Version, backupFormat, backupVersion)
+	}
+
+	expected := map[string]backupFile{}
+	for _, f := range manifest.Files {
+		expected[f.Name] = f
+	}
+	contents := map[string][]byte{}
+	read := map[string]bool{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return data, fmt.Errorf("read archive: %w", err)
+		}
+		f, ok := expected[header.Name]
+		if !ok || read[header.Name] {
+			return data, fmt.Errorf("%s is not listed in the manifest, or listed once but included twice", header.Name)
+		}
+		read[f.Name] = true
+		if header.Size != f.Size {
+			return data, fmt.Errorf("%s is %d bytes; the manifest says %d", f.Name, header.Size, f.Size)
+		}
+		if unpacked += header.Size; unpacked > maxUnpacked {
+			return data, errBackupTooLarge
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return data, fmt.Errorf("read %s: %w", f.Name, err)
+		}
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != f.SHA256 {
+			return data, fmt.Errorf("%s does not match its SHA-256 in the manifest", f.Name)
+		}
+		if sha, ok := strings.CutPrefix(f.Name, backupCoversDir); ok {
+			if sha != f.SHA256 {
+				return data, fmt.Errorf("%s is not named after its SHA-256", f.Name)
+			}
+			if _, err := covers.Save(body); err != nil {
+				return data, fmt.Errorf("%s: %w", f.Name, err)
+			}
+			continue
+		}
+		contents[f.Name] = body
+	}
+
+	for _, f := range manifest.Files {
+		if !read[f.Name] {
+			return data, fmt.Errorf("archive is missing %s", f.Name)
+		}
+	}
+	for _, f := range data.files() {
+		body, ok := contents[f.name]
+		if !ok {
+			return data, fmt.Errorf("archive is missing %s", f.name)
+		}
+		if err := json.Unmarshal(body, f.value); err != nil {
+			return data, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	for _, cover := range data.covers() {
+		if !read[backupCoversDir+cover.SHA256] {
+			return data, fmt.Errorf("archive is missing the cover image %s", cover.SHA256)
+		}
+	}
+	data.CreatedAt = manifest.CreatedAt
+	return data, data.validate()
+}
+
+// `validate` holds restored albums and promotions to the rules of the API, and checks that the data fits together.
+func (data backupData) validate() error {
+	ids := map[string]bool{}
+	for i, a := range data.Albums {
+		if a.ID == "" || ids[a.ID] {
+			return fmt.Errorf("album %d: id is missing or used twice", i)
+		}
+		ids[a.ID] = true
+		if err := binding.Validator.ValidateStruct(&a); err != nil {
+			return fmt.Errorf("album %s: %s", a.ID, describeValidation(err))
+		}
+		if err := trackValidator.Struct(trackList{Tracks: a.Tracks}); err != nil {
+			return fmt.Errorf("album %s: %s", a.ID, describeTrackValidation(err))
+		}
+	}
+	reviewIDs := map[string]bool{}
+	for _, r := range data.Reviews {
+		if !ids[r.AlbumID] {
+			return fmt.Errorf("review %s belongs to album %s, which is not in the backup", r.ID, r.AlbumID)
+		}
+		if r.ID == "" || reviewIDs[r.ID] {
+			return fmt.Errorf("review of album %s: id is missing or used twice", r.AlbumID)
+		}
+		reviewIDs[r.ID] = true
+	}
+	promotionIDs := map[string]bool{}
+	for i, p := range data.Promotions {
+		if p.ID == "" || promotionIDs[p.ID] {
+			return fmt.Errorf("promotion %d: id is missing or used twice", i)
+		}
+		promotionIDs[p.ID] = true
+		if err := binding.Validator.ValidateStruct(&p); err != nil {
+			return fmt.Errorf("promotion %s: %s", p.ID, describeValidation(err))
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("promotion %s: %w", p.ID, err)
+		}
+	}
+	// Orders and wishlists may name albums deleted since, so only their own IDs are checked.
+	orderIDs := map[string]bool{}
+	for i, o := range data.Orders {
+		if o.ID == "" || orderIDs[o.ID] {
+			return fmt.Errorf("order %d: id is missing or used twice", i)
+		}
+		orderIDs[o.ID] = true
+	}
+	for customer, items := range data.Wishlists {
+		if !customerIDPattern.MatchString(customer) {
+			return fmt.Errorf("wishlist of %q: not a valid customer ID", customer)
+		}
+		if len(items) > maxWishlistItems {
+			return fmt.Errorf("wishlist of %s: %w", customer, errWishlistFull)
+		}
+	}
+	return nil
+}
+
+// `shareCatalogue` holds the catalogue for the rest of the request, along with every other request
+// but a restore. A restore waits for them and holds it alone, so no request sees it half done.
+func (s *server) shareCatalogue(c *gin.Context) {
+	s.catalogue.RLock()
+	defer s.catalogue.RUnlock()
+	c.Next()
+}
+
+// `restore` replaces the whole catalogue with `data`: the albums, their reviews, the promotions,
+// orders and wishlists are swapped together while no other request works with the catalogue. The
+// albums go first, in one transaction; only they can fail, and then nothing is replaced. `data` is
+// read and checked beforehand, so the catalogue is only held for the swap.
+func (s *server) restore(ctx context.Context, data backupData) error {
+	s.catalogue.Lock()
+	defer s.catalogue.Unlock()
+
+	err := s.albums.Transaction(ctx, func(tx albumStore) error {
+		current, err := tx.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, a := range current {
+			if err := tx.Delete(ctx, a.ID); err != nil {
+				return err
+			}
+		}
+		for _, a := range data.Albums {
+			if _, err := tx.Create(ctx, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.reviews.ReplaceAll(data.Reviews)
+	s.promotions.ReplaceAll(data.Promotions)
+	s.orders.ReplaceAll(data.Orders)
+	s.wishlists.ReplaceAll(data.Wishlists)
+	return nil
+}
+
+// `postBackup` streams a backup of the catalogue. The cover images are read after the catalogue is
+// released; they are addressed by content and never removed, so they are still those of the snapshot.
+func (s *server) postBackup(c *gin.Context) {
+	data, err := s.snapshot(c.Request.Context())
+	if err != nil {
+		writeProblem(c, problemInternal, "could not take a snapshot")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	name := fmt.Sprintf("records-backup-%s.tar.gz", data.CreatedAt.Format("20060102T150405Z"))
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	if err := writeBackup(c.Writer, data, s.covers); err != nil {
+		// The status is already sent; the client sees a truncated archive, which fails to restore.
+		logger.Printf("backup: %v", err)
+	}
+}
+
+// `postRestore` replaces the catalogue with the backup in the request body. It only accepts
+// `Content-Type: application/gzip`, which a cross-site form cannot send, so it needs no CSRF token.
+func (s *server) postRestore(c *gin.Context) {
+	if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType != "application/gzip" {
+		writeProblem(c, problemUnsupportedMediaType, "send the backup archive as application/gzip")
+		return
+	}
+	data, err := readBackup(c.Request.Body, s.cfg.BackupMaxUnpackedBytes, s.covers)
+	if isBodyTooLarge(err) {
+		writeProblem(c, problemBodyTooLarge, fmt.Sprintf("backup must be at most %d bytes", s.cfg.BackupMaxBytes))
+		return
+	}
+	if errors.Is(err, errBackupTooLarge) {
+		writeProblem(c, problemBodyTooLarge, fmt.Sprintf("backup must unpack to at most %d bytes", s.cfg.BackupMaxUnpackedBytes))
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemBadRequest, "invalid backup: "+err.Error())
+		return
+	}
+
+	if err := s.restore(c.Request.Context(), data); err != nil {
+		writeProblem(c, problemInternal, "could not restore backup")
+		logError(c.Request.Context(), err)
+		return
+	}
+	logger.Printf("restored backup from %s: %d albums, %d reviews, %d promotions, %d orders, %d wishlists",
+		data.CreatedAt.Format(time.RFC3339), len(data.Albums), len(data.Reviews), len(data.Promotions), len(data.Orders), len(data.Wishlists))
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"createdAt":  data.CreatedAt,
+		"albums":     len(data.Albums),
+		"reviews":    len(data.Reviews),
+		"covers":     len(data.covers()),
+		"promotions": len(data.Promotions),
+		"orders":     len(data.Orders),
+		"wishlists":  len(data.Wishlists),
+	})
+}
@@ -0,0 +1,292 @@
+package records_api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func adminRequest(router http.Handler, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func newBackupTestRouter(t *testing.T) http.Handler {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminToken = "test-token"
+	return newRouter(newServer(cfg, newMemoryStore(albums)))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	router := newBackupTestRouter(t)
+	var posted postedReview
+	json.Unmarshal(serve(router, http.MethodPost, "/albums/1/reviews", `{"author": "Ann", "rating": 5, "text": "A landmark of hard bop."}`).Body.Bytes(), &posted)
+
+	w := adminRequest(router, http.MethodPost, "/admin/backup", "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" ||
+		!strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment; filename=records-backup-") {
+		t.Fatalf("backup = %d %v", w.Code, w.Header())
+	}
+	backup := w.Body.Bytes()
+
+	// Change everything, then restore.
+	serve(router, http.MethodDelete, "/albums/1", "")
+	serve(router, http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`)
+
+	w = adminRequest(router, http.MethodPost, "/admin/restore", "application/gzip", backup)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"albums": 3`) || !strings.Contains(w.Body.String(), `"reviews": 1`) {
+		t.Fatalf("restore = %d %s", w.Code, w.Body)
+	}
+
+	var restored []album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums", "").Body.Bytes(), &restored)
+	if len(restored) != 3 || restored[0].ID != "1" || restored[0].Reviews.Count != 1 {
+		t.Errorf("albums after restore = %+v", restored)
+	}
+	// New albums do not reuse the IDs of those the restore removed.
+	w = serve(router, http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`)
+	if !strings.Contains(w.Body.String(), `"id": "5"`) {
+		t.Errorf("album created after restore = %s", w.Body)
+	}
+	// The author can still delete the restored review.
+	if w := deleteReviewWith(router, "/albums/1/reviews/"+posted.ID, posted.DeleteToken); w.Code != http.StatusNoContent {
+		t.Errorf("delete restored review = %d %s", w.Code, w.Body)
+	}
+}
+
+// `rewriteBackup` unpacks a backup, lets `edit` change its files, and packs it again.
+func rewriteBackup(t *testing.T, backup []byte, edit func(name string, body []byte) []byte) []byte {
+	gz, err := gzip.NewReader(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		body, _ := io.ReadAll(tr)
+		if body = edit(header.Name, body); body == nil {
+			continue
+		}
+		header.Size = int64(len(body))
+		tw.WriteHeader(header)
+		tw.Write(body)
+	}
+	tw.Close()
+	gw.Close()
+	return out.Bytes()
+}
+
+func TestRestoreVerifiesManifest(t *testing.T) {
+	router := newBackupTestRouter(t)
+	backup := adminRequest(router, http.MethodPost, "/admin/backup", "", nil).Body.Bytes()
+
+	tests := map[string]struct {
+		archive []byte
+		want    string
+	}{
+		"tampered": {rewriteBackup(t, backup, func(name string, body []byte) []byte {
+			if name == backupAlbumsName {
+				return bytes.Replace(body, []byte("56.99"), []byte("5.699"), 1)
+			}
+			return body
+		}), "albums.json does not match its SHA-256"},
+		"missing file": {rewriteBackup(t, backup, func(name string, body []byte) []byte {
+			if name == backupReviewsName {
+				return nil
+			}
+			return body
+		}), "archive is missing reviews.json"},
+		"newer version": {rewriteBackup(t, backup, func(name string, body []byte) []byte {
+			if name == backupManifestName {
+				return bytes.Replace(body, []byte(`"version": 2`), []byte(`"version": 3`), 1)
+			}
+			return body
+		}), "unsupported backup format"},
+		"not gzip": {[]byte("albums"), "not a gzip file"},
+	}
+	for name, tt := range tests {
+		w := adminRequest(router, http.MethodPost, "/admin/restore", "application/gzip", tt.archive)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: restore = %d %s; want 400 %q", name, w.Code, w.Body, tt.want)
+		}
+	}
+	// Nothing was changed by the rejected archives.
+	if w := serve(router, http.MethodGet, "/albums/1", ""); !strings.Contains(w.Body.String(), "56.99") {
+		t.Errorf("album after rejected restores = %s", w.Body)
+	}
+
+	if w := adminRequest(router, http.MethodPost, "/admin/restore", "application/x-www-form-urlencoded", backup); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("restore from a form = %d; want 415", w.Code)
+	}
+	if w := serve(router, http.MethodPost, "/admin/backup", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("backup without credentials = %d; want 401", w.Code)
+	}
+
+	// The archive is small, but it could still unpack to more than the server takes.
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminToken = "test-token"
+	cfg.BackupMaxUnpackedBytes = 1000
+	small := newRouter(newServer(cfg, newMemoryStore(albums)))
+	decodeProblem(t, adminRequest(small, http.MethodPost, "/admin/restore", "application/gzip", backup), problemBodyTooLarge)
+}
+
+func TestRestoreOnAnotherServer(t *testing.T) {
+	router := newBackupTestRouter(t)
+	putCover(router, "image/png", testPNG(t, 300, 200))
+	now := time.Now()
+	body, _ := json.Marshal(promotion{
+		Name: "weekend", Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour),
+		Discount: promotionDiscount{Type: discountPercent, Percent: 10},
+	})
+	adminRequest(router, http.MethodPost, "/admin/promotions", "application/json", body)
+	adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "1", "quantity": 1}]}`))
+	adminRequest(router, http.MethodPut, "/customers/ann/wishlist/2", "", nil)
+	backup := adminRequest(router, http.MethodPost, "/admin/backup", "", nil).Body.Bytes()
+
+	// The other server has its own, empty cover directory.
+	other := newBackupTestRouter(t)
+	w := adminRequest(other, http.MethodPost, "/admin/restore", "application/gzip", backup)
+	for _, want := range []string{`"covers": 1`, `"promotions": 1`, `"orders": 1`, `"wishlists": 1`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("restore = %d %s; want %s", w.Code, w.Body, want)
+		}
+	}
+	if w := serve(other, http.MethodGet, "/albums/1/cover?size=256", ""); w.Code != http.StatusOK {
+		t.Errorf("restored cover = %d", w.Code)
+	}
+	if w := adminRequest(other, http.MethodGet, "/orders/1", "", nil); w.Code != http.StatusOK {
+		t.Errorf("restored order = %d", w.Code)
+	}
+	var wishlist []wishlistEntry
+	json.Unmarshal(adminRequest(other, http.MethodGet, "/customers/ann/wishlist", "", nil).Body.Bytes(), &wishlist)
+	if len(wishlist) != 1 || wishlist[0].AlbumID != "2" || *wishlist[0].CurrentPrice != 16.19 {
+		t.Errorf("restored wishlist = %+v; want album 2 with the restored promotion", wishlist)
+	}
+
+	// Albums whose cover image is missing from the archive are refused.
+	withoutCover := rewriteBackup(t, backup, func(name string, body []byte) []byte {
+		if name == backupManifestName {
+			var manifest backupManifest
+			json.Unmarshal(body, &manifest)
+			manifest.Files = slices.DeleteFunc(manifest.Files, func(f backupFile) bool { return strings.HasPrefix(f.Name, backupCoversDir) })
+			body, _ = json.Marshal(manifest)
+		}
+		if strings.HasPrefix(name, backupCoversDir) {
+			return nil
+		}
+		return body
+	})
+	w = adminRequest(newBackupTestRouter(t), http.MethodPost, "/admin/restore", "application/gzip", withoutCover)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing the cover image") {
+		t.Errorf("restore without the cover = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRestoreWaitsForRequests(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminToken = "test-token"
+	s := newServer(cfg, newMemoryStore(albums))
+	router := newRouter(s)
+	serve(router, http.MethodPost, "/albums/1/reviews", `{"author": "Ann", "rating": 5, "text": "A landmark of hard bop."}`)
+	backup := adminRequest(router, http.MethodPost, "/admin/backup", "", nil).Body.Bytes()
+	serve(router, http.MethodDelete, "/albums/1", "")
+
+	// Stand in for a request that is still running.
+	s.catalogue.RLock()
+	done := make(chan struct{})
+	go func() {
+		adminRequest(router, http.MethodPost, "/admin/restore", "application/gzip", backup)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("restore went ahead while a request was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err := s.albums.Get(context.Background(), "1"); err == nil {
+		t.Error("album 1 was restored while a request was running")
+	}
+	s.catalogue.RUnlock()
+	<-done
+
+	var a album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums/1", "").Body.Bytes(), &a)
+	if a.Reviews.Count != 1 {
+		t.Errorf("album 1 after restore = %+v; want it with its review", a)
+	}
+}
+
+// `stalledWriter` is a client that stops reading: its first write blocks until `resume` is closed.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	stalled, resume chan struct{}
+	once            sync.Once
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.stalled)
+		<-w.resume
+	})
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestSlowBackupDoesNotHoldUpRestore(t *testing.T) {
+	router := newBackupTestRouter(t)
+	backup := adminRequest(router, http.MethodPost, "/admin/backup", "", nil).Body.Bytes()
+
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), stalled: make(chan struct{}), resume: make(chan struct{})}
+	req := httptest.NewRequest(http.MethodPost, "/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(done)
+	}()
+	<-w.stalled
+
+	restored := make(chan int)
+	go func() { restored <- adminRequest(router, http.MethodPost, "/admin/restore", "application/gzip", backup).Code }()
+	select {
+	case code := <-restored:
+		if code != http.StatusOK {
+			t.Errorf("restore during a slow backup = %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		close(w.resume)
+		t.Fatal("restore waited for a slow backup download")
+	}
+	if w := serve(router, http.MethodGet, "/albums/1", ""); w.Code != http.StatusOK {
+		t.Errorf("read during a slow backup = %d", w.Code)
+	}
+	close(w.resume)
+	<-done
+	if _, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes())); err != nil {
+		t.Errorf("slow backup: %v", err)
+	}
+}
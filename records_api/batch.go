@@ -0,0 +1,272 @@
+package records_api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const (
+	batchAllOrNothing = "all-or-nothing"
+	batchBestEffort   = "best-effort"
+)
+
+// `batchRequest` is the body of `POST /albums:batch`.
+type batchRequest struct {
+	// `all-or-nothing` (the default) applies every operation or none; `best-effort` applies what it can.
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// `batchOperation` creates, updates or deletes one album. A create may name the ID it produces with `ref`,
+// and later operations use that ID by giving `"id": "$<ref>"`.
+type batchOperation struct {
+	Op    string          `json:"op"`
+	Ref   string          `json:"ref,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Album json.RawMessage `json:"album,omitempty"`
+}
+
+// `batchResult` reports the outcome of one operation with the status code it would have had on its own.
+type batchResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Status  int    `json:"status"`
+	Album   any    `json:"album,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+var batchRef = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// `errBatchAborted` rolls back an all-or-nothing batch after an operation failed.
+var errBatchAborted = errors.New("batch aborted")
+
+// `validate` checks the shape of the whole batch before anything is applied.
+func (b *batchRequest) validate(maxOperations int) error {
+	if b.Mode == "" {
+		b.Mode = batchAllOrNothing
+	}
+	if b.Mode != batchAllOrNothing && b.Mode != batchBestEffort {
+		return fmt.Errorf("mode must be %s or %s", batchAllOrNothing, batchBestEffort)
+	}
+	if len(b.Operations) == 0 || len(b.Operations) > maxOperations {
+		return fmt.Errorf("a batch must have between 1 and %d operations", maxOperations)
+	}
+
+	refs := map[string]bool{}
+	for i, op := range b.Operations {
+		switch op.Op {
+		case "create":
+			if len(op.Album) == 0 {
+				return fmt.Errorf("operation %d: create needs an album", i)
+			}
+		case "update":
+			if op.ID == "" || len(op.Album) == 0 {
+				return fmt.Errorf("operation %d: update needs an id and an album", i)
+			}
+		case "delete":
+			if op.ID == "" {
+				return fmt.Errorf("operation %d: delete needs an id", i)
+			}
+		default:
+			return fmt.Errorf("operation %d: op must be create, update or delete, got %q", i, op.Op)
+		}
+
+		if ref, ok := strings.CutPrefix(op.ID, "$"); ok && !refs[ref] {
+			return fmt.Errorf("operation %d: %s does not refer to an earlier create", i, op.ID)
+		}
+		if op.Ref != "" {
+			if op.Op != "create" || !batchRef.MatchString(op.Ref) || refs[op.Ref] {
+				return fmt.Errorf("operation %d: ref must be a unique name of letters, digits, - and _ on a create", i)
+			}
+			refs[op.Ref] = true
+		}
+	}
+	return nil
+}
+
+// `postAlbumsAction` serves `POST /albums:<action>`. Gin cannot route a literal colon after a path
+// segment, so the action arrives as a parameter; `batch` is the only one.
+func (s *server) postAlbumsAction(c *gin.Context) {
+	if c.Param("action") != ":batch" {
+		writeProblem(c, problemNotFound, "unknown action")
+		return
+	}
+	s.postAlbumsBatch(c)
+}
+
+// `postAlbumsBatch` applies a list of album operations in order.
+func (s *server) postAlbumsBatch(c *gin.Context) {
+	var req batchRequest
+	err := tracedBind(c.Request.Context(), c.ShouldBindJSON)(&req)
+	if err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return
+	}
+	if err := req.validate(s.cfg.BatchMaxOperations); err != nil {
+		writeProblem(c, problemValidation, err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	run := &batchRun{server: s, version: versionOf(c), refs: map[string]string{}}
+	if req.Mode == batchBestEffort {
+		run.apply(ctx, s.albums, req.Operations, false)
+		run.committed()
+		c.IndentedJSON(http.StatusOK, gin.H{"mode": req.Mode, "results": run.results})
+		return
+	}
+
+	err = s.albums.Transaction(ctx, func(tx albumStore) error {
+		if failed := run.apply(ctx, tx, req.Operations, true); failed >= 0 {
+			return errBatchAborted
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchAborted) {
+		status := run.rolledBack()
+		c.IndentedJSON(status, gin.H{"mode": req.Mode, "committed": false, "results": run.results})
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not apply batch")
+		logError(c.Request.Context(), err)
+		return
+	}
+	run.committed()
+	c.IndentedJSON(http.StatusOK, gin.H{"mode": req.Mode, "committed": true, "results": run.results})
+}
+
+// `batchRun` carries the state of one batch from operation to operation.
+type batchRun struct {
+	server  *server
+	version *apiVersion
+	// `refs` maps the refs of successful creates to the IDs they produced.
+	refs    map[string]string
+	results []batchResult
+	deleted []string
+	failed  int
+}
+
+// `apply` runs the operations against `store` and returns the index of the first failed one, or -1.
+// With `stopOnError`, it stops there.
+func (r *batchRun) apply(ctx context.Context, store albumStore, ops []batchOperation, stopOnError bool) int {
+	r.failed = -1
+	for i, op := range ops {
+		result := r.applyOne(ctx, store, i, op)
+		r.results = append(r.results, result)
+		if result.Status >= 400 && r.failed < 0 {
+			r.failed = i
+			if stopOnError {
+				for j := i + 1; j < len(ops); j++ {
+					r.results = append(r.results, batchResult{Index: j, Op: ops[j].Op, Status: http.StatusFailedDependency,
+						Message: fmt.Sprintf("not applied because operation %d failed", i)})
+				}
+				break
+			}
+		}
+	}
+	return r.failed
+}
+
+func (r *batchRun) applyOne(ctx context.Context, store albumStore, i int, op batchOperation) batchResult {
+	result := batchResult{Index: i, Op: op.Op, ID: op.ID}
+	fail := func(status int, format string, args ...any) batchResult {
+		result.Status, result.Message = status, fmt.Sprintf(format, args...)
+		return result
+	}
+
+	if ref, ok := strings.CutPrefix(op.ID, "$"); ok {
+		id, created := r.refs[ref]
+		if !created {
+			return fail(http.StatusFailedDependency, "%s refers to a create that failed", op.ID)
+		}
+		result.ID = id
+	}
+
+	var a album
+	if op.Op != "delete" {
+		var err error
+		a, err = r.version.decodeAlbum(tracedBind(ctx, func(obj any) error { return binding.JSON.BindBody(op.Album, obj) }))
+		if err != nil {
+			return fail(http.StatusBadRequest, "invalid album: %v", err)
+		}
+	}
+
+	var err error
+	switch op.Op {
+	case "create":
+		// The cover, tracks and reviews can only be set through their own endpoints.
+		a.Cover, a.Tracks, a.RunningTime, a.Reviews, a.Pricing = nil, nil, nil, reviewSummary{}, nil
+		a, err = store.Create(ctx, a)
+		result.Status = http.StatusCreated
+		if err == nil && op.Ref != "" {
+			r.refs[op.Ref] = a.ID
+		}
+	case "update":
+		if a.ID != "" && a.ID != result.ID {
+			return fail(http.StatusBadRequest, "album ID in the body does not match the operation")
+		}
+		a.ID = result.ID
+		var existing album
+		if existing, err = store.Get(ctx, a.ID); err == nil {
+			a.Cover, a.Tracks, a.RunningTime, a.Reviews, a.Pricing = existing.Cover, existing.Tracks, existing.RunningTime, reviewSummary{}, nil
+			a, err = store.Update(ctx, a)
+		}
+		result.Status = http.StatusOK
+	case "delete":
+		err = store.Delete(ctx, result.ID)
+		result.Status = http.StatusNoContent
+		if err == nil {
+			r.deleted = append(r.deleted, result.ID)
+		}
+	}
+
+	switch {
+	case errors.Is(err, errAlbumNotFound):
+		return fail(http.StatusNotFound, "album not found")
+	case errors.Is(err, errAlbumExists):
+		return fail(http.StatusConflict, "album already exists")
+	case err != nil:
+		logError(ctx, err)
+		return fail(http.StatusInternalServerError, "could not %s album", op.Op)
+	}
+	if op.Op != "delete" {
+		result.ID = a.ID
+		result.Album = r.version.encodeAlbum(r.server.withComputed(a)[0])
+	}
+	return result
+}
+
+// `committed` finishes the work that lives outside the album store once the changes are in.
+func (r *batchRun) committed() {
+	for _, id := range r.deleted {
+		r.server.reviews.DeleteAlbum(id)
+	}
+}
+
+// `rolledBack` marks the operations that succeeded before the failure as not applied, and returns
+// the status of the response: 422 if the failed operation was rejected, or its 5xx status.
+func (r *batchRun) rolledBack() int {
+	for i := range r.results[:r.failed] {
+		res := &r.results[i]
+		res.Status, res.Album = http.StatusFailedDependency, nil
+		if res.Op == "create" {
+			// The ID was never committed.
+			res.ID = ""
+		}
+		res.Message = fmt.Sprintf("rolled back because operation %d failed", r.failed)
+	}
+	if status := r.results[r.failed].Status; status >= 500 {
+		return status
+	}
+	return http.StatusUnprocessableEntity
+}
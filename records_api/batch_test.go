@@ -0,0 +1,119 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type batchResponse struct {
+	Committed *bool         `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+func postBatch(t *testing.T, router http.Handler, path, body string) (int, batchResponse) {
+	w := serve(router, http.MethodPost, path, body)
+	var res batchResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res
+}
+
+func TestBatchAllOrNothing(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	code, res := postBatch(t, router, "/albums:batch", `{"operations": [
+		{"op": "create", "ref": "giant", "album": {"title": "Giant", "artist": "Coltrane", "price": 9.99}},
+		{"op": "update", "id": "$giant", "album": {"title": "Giant", "artist": "Coltrane", "price": 19.99}},
+		{"op": "delete", "id": "2"}
+	]}`)
+	if code != http.StatusOK || !*res.Committed || len(res.Results) != 3 {
+		t.Fatalf("batch = %d %+v", code, res)
+	}
+	id := res.Results[0].ID
+	if res.Results[1].ID != id || res.Results[1].Status != http.StatusOK || res.Results[2].Status != http.StatusNoContent {
+		t.Errorf("results = %+v; want the update to use the created ID %s", res.Results, id)
+	}
+	var a album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums/"+id, "").Body.Bytes(), &a)
+	if a.Price != 19.99 {
+		t.Errorf("created album = %+v", a)
+	}
+}
+
+func TestBatchRollback(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+	// Warm the cache, which must not keep serving the rolled back state either.
+	serve(router, http.MethodGet, "/albums", "")
+
+	code, res := postBatch(t, router, "/albums:batch", `{"operations": [
+		{"op": "create", "album": {"id": "10", "title": "Giant", "artist": "Coltrane", "price": 9.99}},
+		{"op": "delete", "id": "1"},
+		{"op": "update", "id": "nope", "album": {"title": "Jeru", "artist": "Mulligan", "price": 1}},
+		{"op": "delete", "id": "2"}
+	]}`)
+	if code != http.StatusUnprocessableEntity || *res.Committed {
+		t.Fatalf("failed batch = %d %+v; want 422, not committed", code, res)
+	}
+	wantStatus := []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusNotFound, http.StatusFailedDependency}
+	for i, r := range res.Results {
+		if r.Status != wantStatus[i] {
+			t.Errorf("result %d = %d %q; want %d", i, r.Status, r.Message, wantStatus[i])
+		}
+	}
+
+	var albums []album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums", "").Body.Bytes(), &albums)
+	if len(albums) != 3 || albums[0].ID != "1" {
+		t.Errorf("albums after rollback = %+v; want the original 3", albums)
+	}
+	if w := serve(router, http.MethodGet, "/albums/10", ""); w.Code != http.StatusNotFound {
+		t.Errorf("album created in a rolled back batch = %d; want 404", w.Code)
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	router := newVersionTestRouter(t, "v2")
+
+	code, res := postBatch(t, router, "/albums:batch", `{"mode": "best-effort", "operations": [
+		{"op": "create", "ref": "bad", "album": {"title": "Giant", "artist": {"name": "Coltrane"}, "price": {"amount": "-1", "currency": "USD"}}},
+		{"op": "delete", "id": "$bad"},
+		{"op": "create", "album": {"title": "Giant", "artist": {"name": "Coltrane"}, "price": {"amount": "9.99", "currency": "USD"}}},
+		{"op": "delete", "id": "3"}
+	]}`)
+	if code != http.StatusOK || res.Committed != nil {
+		t.Fatalf("best-effort batch = %d %+v", code, res)
+	}
+	wantStatus := []int{http.StatusBadRequest, http.StatusFailedDependency, http.StatusCreated, http.StatusNoContent}
+	for i, r := range res.Results {
+		if r.Status != wantStatus[i] {
+			t.Errorf("result %d = %d %q; want %d", i, r.Status, r.Message, wantStatus[i])
+		}
+	}
+	// Albums in results use the request's API version.
+	if !strings.Contains(serve(router, http.MethodGet, "/albums/"+res.Results[2].ID, "").Body.String(), `"amount": "9.99"`) {
+		t.Error("album created by the batch is missing")
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.BatchMaxOperations = 2
+	router := newRouter(newServer(cfg, newMemoryStore(albums)))
+
+	for _, body := range []string{
+		`{"operations": []}`,
+		`{"operations": [{"op": "delete", "id": "1"}, {"op": "delete", "id": "2"}, {"op": "delete", "id": "3"}]}`,
+		`{"mode": "sometimes", "operations": [{"op": "delete", "id": "1"}]}`,
+		`{"operations": [{"op": "upsert", "id": "1"}]}`,
+		`{"operations": [{"op": "delete", "id": "$later"}, {"op": "create", "ref": "later", "album": {}}]}`,
+	} {
+		if code, _ := postBatch(t, router, "/albums:batch", body); code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d; want 400", body, code)
+		}
+	}
+	if code, _ := postBatch(t, router, "/v1/albums:merge", `{}`); code != http.StatusNotFound {
+		t.Errorf("unknown action = %d; want 404", code)
+	}
+}
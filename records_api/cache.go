@@ -0,0 +1,186 @@
+package records_api
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errCacheLoadPanicked = errors.New("cache loader panicked")
+
+// `cacheStats` are the counters of an `lruCache`, as exposed on the stats endpoint.
+type cacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Size        int    `json:"size"`
+}
+
+// `lruCache` is an in-memory cache bounded both in size and in age.
+// When full, the least recently used entry is evicted; entries older than the TTL are never returned.
+// Concurrent misses for the same key share a single call of the loader.
+type lruCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // of *cacheEntry[K, V], most recently used at the front
+	entries  map[K]*list.Element
+	inflight map[K]*cacheCall[V]
+	now      func() time.Time
+
+	hits, misses, evictions, expirations atomic.Uint64
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// `cacheCall` is a load in progress. Callers missing the same key wait on `done` and share the result.
+type cacheCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+	// Set when the key is invalidated during the load, so the (possibly outdated) result isn't cached.
+	stale bool
+}
+
+func newLRUCache[K comparable, V any](capacity int, ttl time.Duration) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+		inflight: make(map[K]*cacheCall[V]),
+		now:      time.Now,
+	}
+}
+
+// `Get` returns the cached value for `key`, if there is a fresh one.
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key)
+}
+
+// `GetOrLoad` returns the cached value for `key`, calling `load` on a miss and caching its result.
+// Errors are returned to every waiting caller but are not cached.
+func (c *lruCache[K, V]) GetOrLoad(key K, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if value, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &cacheCall[V]{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		if c.inflight[key] == call {
+			delete(c.inflight, key)
+		}
+		if call.err == nil && !call.stale {
+			c.set(key, call.value)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	// If `load` panics, the deferred cleanup still wakes the waiters, who then see this error.
+	call.err = errCacheLoadPanicked
+	call.value, call.err = load()
+	return call.value, call.err
+}
+
+// `Set` stores `value` under `key`, evicting the least recently used entry if the cache is full.
+func (c *lruCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value)
+}
+
+// `Invalidate` removes `key`, including the result of any load that is currently in progress for it.
+func (c *lruCache[K, V]) Invalidate(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if call, ok := c.inflight[key]; ok {
+		call.stale = true
+		delete(c.inflight, key)
+	}
+}
+
+// `Stats` returns a snapshot of the counters.
+func (c *lruCache[K, V]) Stats() cacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	return cacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+		Size:        size,
+	}
+}
+
+// Must be called with `mu` held.
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	var zero V
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return zero, false
+	}
+	entry := elem.Value.(*cacheEntry[K, V])
+	if c.now().After(entry.expires) {
+		c.remove(elem)
+		c.expirations.Add(1)
+		c.misses.Add(1)
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	c.hits.Add(1)
+	return entry.value, true
+}
+
+// Must be called with `mu` held.
+func (c *lruCache[K, V]) set(key K, value V) {
+	if c.capacity <= 0 || c.ttl <= 0 {
+		return
+	}
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry[K, V])
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	for c.order.Len() >= c.capacity {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry[K, V]{key: key, value: value, expires: expires})
+}
+
+// Must be called with `mu` held.
+func (c *lruCache[K, V]) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry[K, V]).key)
+}
@@ -0,0 +1,160 @@
+package records_api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache[string, int](2, time.Hour)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a") // "b" is now the least recently used
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v; want 1, true", v, ok)
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Size != 2 {
+		t.Errorf("stats = %+v; want 1 eviction and size 2", stats)
+	}
+}
+
+func TestLRUCacheExpiresEntries(t *testing.T) {
+	c := newLRUCache[string, int](10, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("a should have expired")
+	}
+	if stats := c.Stats(); stats.Expirations != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v; want 1 expiration and 1 miss", stats)
+	}
+}
+
+func TestLRUCacheSingleLoadForConcurrentMisses(t *testing.T) {
+	c := newLRUCache[string, int](10, time.Hour)
+	var loads atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad("a", func() (int, error) {
+				loads.Add(1)
+				<-release
+				return 42, nil
+			})
+			if v != 42 || err != nil {
+				t.Errorf("GetOrLoad = %d, %v; want 42, nil", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads.Load() != 1 {
+		t.Errorf("loaded %d times; want 1", loads.Load())
+	}
+}
+
+func TestLRUCacheInvalidateDuringLoad(t *testing.T) {
+	c := newLRUCache[string, int](10, time.Hour)
+	started, release := make(chan struct{}), make(chan struct{})
+
+	go func() {
+		<-started
+		c.Invalidate("a")
+		close(release)
+	}()
+	c.GetOrLoad("a", func() (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("a value loaded before the invalidation must not be cached")
+	}
+}
+
+func TestCachedStoreInvalidatesOnWrite(t *testing.T) {
+	ctx := context.Background()
+	store := newCachedStore(newMemoryStore([]album{{ID: "1", Title: "Jeru", Artist: "Gerry", Price: 1}}), 10, time.Hour)
+
+	store.List(ctx)
+	store.Get(ctx, "1")
+	store.Update(ctx, album{ID: "1", Title: "Jeru", Artist: "Gerry", Price: 2})
+
+	if a, _ := store.Get(ctx, "1"); a.Price != 2 {
+		t.Errorf("Get after Update: price = %v; want 2", a.Price)
+	}
+	store.Create(ctx, album{Title: "Blue", Artist: "John", Price: 3})
+	if list, _ := store.List(ctx); len(list) != 2 {
+		t.Errorf("List after Create: %d albums; want 2", len(list))
+	}
+}
+
+// `cancelAwareStore` fails reads whose context is done, like a database driver would.
+type cancelAwareStore struct{ albumStore }
+
+func (s cancelAwareStore) Get(ctx context.Context, id string) (album, error) {
+	if err := ctx.Err(); err != nil {
+		return album{}, err
+	}
+	return s.albumStore.Get(ctx, id)
+}
+
+func TestCachedStoreLoadOutlivesCaller(t *testing.T) {
+	store := newCachedStore(cancelAwareStore{newMemoryStore(albums)}, 10, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The load is shared with other callers, so one caller giving up must not fail or poison it.
+	if a, err := store.Get(ctx, "1"); err != nil || a.ID != "1" {
+		t.Errorf("Get with a canceled context = %+v, %v; want album 1", a, err)
+	}
+}
+
+func TestCacheStatsNeedAdmin(t *testing.T) {
+	router := newBackupTestRouter(t)
+	decodeProblem(t, serve(router, http.MethodGet, "/stats/cache", ""), problemUnauthorized)
+	if w := adminRequest(router, http.MethodGet, "/stats/cache", "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hits") {
+		t.Errorf("stats as admin = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCacheControlMatchesPolicy(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CacheTTL = 45 * time.Second
+	router := newRouter(newServer(cfg, newMemoryStore(albums)))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/albums/1", nil))
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=45" {
+		t.Errorf("Cache-Control = %q; want public, max-age=45", got)
+	}
+
+	cfg.CacheSize = 0
+	router = newRouter(newServer(cfg, newMemoryStore(albums)))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/albums", nil))
+	if got := w.Header().Get("Cache-Control"); !strings.Contains(got, "no-cache") {
+		t.Errorf("Cache-Control with caching disabled = %q; want no-cache", got)
+	}
+}
@@ -0,0 +1,140 @@
+package records_api
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// The whole album list is cached under a single key.
+const albumListCacheKey = ""
+
+// `cachedStore` puts an `lruCache` in front of another `albumStore`.
+// Reads go through the cache; writes go to the backing store first and then invalidate
+// the affected entries immediately, so a read after a write never sees the old data.
+type cachedStore struct {
+	backend albumStore
+	ttl     time.Duration
+	lists   *lruCache[string, []album]
+	items   *lruCache[string, album]
+	// `generation` counts the writes, so results derived from the whole catalogue know when to recompute.
+	generation atomic.Uint64
+}
+
+// A `size` or `ttl` of zero disables caching; every read then goes to `backend`.
+func newCachedStore(backend albumStore, size int, ttl time.Duration) *cachedStore {
+	return &cachedStore{
+		backend: backend,
+		ttl:     ttl,
+		lists:   newLRUCache[string, []album](min(size, 1), ttl),
+		items:   newLRUCache[string, album](size, ttl),
+	}
+}
+
+// `enabled` reports whether responses may be cached at all.
+func (s *cachedStore) enabled() bool {
+	return s.items.capacity > 0 && s.ttl > 0
+}
+
+// The returned slice is shared with the cache and must not be modified.
+// Concurrent misses share one load, so it runs without the caller's cancellation: a request that gives up
+// must not fail the others waiting on the same load.
+func (s *cachedStore) List(ctx context.Context) ([]album, error) {
+	return s.lists.GetOrLoad(albumListCacheKey, func() ([]album, error) {
+		return s.backend.List(context.WithoutCancel(ctx))
+	})
+}
+
+func (s *cachedStore) Get(ctx context.Context, id string) (album, error) {
+	return s.items.GetOrLoad(id, func() (album, error) {
+		return s.backend.Get(context.WithoutCancel(ctx), id)
+	})
+}
+
+func (s *cachedStore) Create(ctx context.Context, a album) (album, error) {
+	created, err := s.backend.Create(ctx, a)
+	if err == nil {
+		s.invalidate(created.ID)
+	}
+	return created, err
+}
+
+func (s *cachedStore) Update(ctx context.Context, a album) (album, error) {
+	updated, err := s.backend.Update(ctx, a)
+	if err == nil {
+		s.invalidate(updated.ID)
+	}
+	return updated, err
+}
+
+func (s *cachedStore) Delete(ctx context.Context, id string) error {
+	err := s.backend.Delete(ctx, id)
+	if err == nil {
+		s.invalidate(id)
+	}
+	return err
+}
+
+// Only the albums written in a committed transaction are invalidated.
+func (s *cachedStore) Transaction(ctx context.Context, fn func(tx albumStore) error) error {
+	var written []string
+	err := s.backend.Transaction(ctx, func(tx albumStore) error {
+		return fn(&writeRecorder{albumStore: tx, written: &written})
+	})
+	if err == nil {
+		for _, id := range written {
+			s.invalidate(id)
+		}
+	}
+	return err
+}
+
+// `writeRecorder` notes the IDs of the albums written through it.
+type writeRecorder struct {
+	albumStore
+	written *[]string
+}
+
+func (r *writeRecorder) Create(ctx context.Context, a album) (album, error) {
+	created, err := r.albumStore.Create(ctx, a)
+	if err == nil {
+		*r.written = append(*r.written, created.ID)
+	}
+	return created, err
+}
+
+func (r *writeRecorder) Update(ctx context.Context, a album) (album, error) {
+	updated, err := r.albumStore.Update(ctx, a)
+	if err == nil {
+		*r.written = append(*r.written, updated.ID)
+	}
+	return updated, err
+}
+
+func (r *writeRecorder) Delete(ctx context.Context, id string) error {
+	err := r.albumStore.Delete(ctx, id)
+	if err == nil {
+		*r.written = append(*r.written, id)
+	}
+	return err
+}
+
+func (s *cachedStore) invalidate(id string) {
+	s.items.Invalidate(id)
+	s.lists.Invalidate(albumListCacheKey)
+	// Bumped last, so whoever sees the new generation also sees the new data.
+	s.generation.Add(1)
+}
+
+// `Generation` changes whenever an album is created, updated or deleted.
+func (s *cachedStore) Generation() uint64 {
+	return s.generation.Load()
+}
+
+// `Stats` returns the counters of the list and item caches.
+func (s *cachedStore) Stats() map[string]cacheStats {
+	return map[string]cacheStats{
+		"list":  s.lists.Stats(),
+		"items": s.items.Stats(),
+	}
+}
@@ -0,0 +1,373 @@
+package records_api
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// `config` holds the tunables of the records API.
+type config struct {
+	Addr string
+	// Version of the API served on unversioned paths like `/albums`.
+	DefaultAPIVersion string
+	// When `v1` is deprecated and when it will be removed, announced in its responses; zero for no announcement.
+	V1Deprecation date
+	V1Sunset      date
+
+	// Limits of the HTTP server; see `newHTTPServer`.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64
+
+	// HTTPS; see `tlsConfig`.
+	Dev                bool
+	TLSCert            string
+	TLSKey             string
+	DevCAOut           string
+	ClientCA           string
+	ClientCertOptional bool
+
+	// One catalogue per tenant instead of a single one; see `tenantRouter`.
+	MultiTenant     bool
+	TenantDomain    string
+	TenantRateLimit float64
+	TenantRateBurst int
+
+	// Credentials for `/admin` and other admin endpoints; see `requireAdmin`.
+	AdminUser     string
+	AdminPassword string
+	AdminToken    string
+
+	// Whether the `/debug` profiling endpoints are on at startup; see `diagnostics`.
+	Debug bool
+
+	// Cross-origin requests; see `cors`.
+	CORSAllowedOrigins   stringList
+	CORSAllowedMethods   stringList
+	CORSAllowedHeaders   stringList
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+
+	IdempotencyTTL time.Duration
+	// Maximum number of responses kept for `Idempotency-Key` retries.
+	IdempotencyMaxKeys int
+	// Maximum size of an archive sent to `POST /admin/restore`.
+	BackupMaxBytes int64
+	// Maximum total size of the files in such an archive once unpacked, cover images included.
+	BackupMaxUnpackedBytes int64
+	// Maximum number of operations in `POST /albums:batch`.
+	BatchMaxOperations int
+	CacheSize          int
+	CacheTTL           time.Duration
+	CoverDir           string
+	CoverMaxBytes      int64
+	// SQLite database whose schema is kept up to date; see `migrator`. Albums are not stored in it yet.
+	DB            string
+	AutoMigrate   bool
+	ProfanityFile string
+	// Where albums are kept across restarts; in memory only if empty. See `logStore`.
+	StoreDir             string
+	StoreFsync           string
+	StoreFsyncInterval   time.Duration
+	StoreCompactInterval time.Duration
+	// Initial albums; see `loadSeed`.
+	SeedFile   string
+	SeedStrict bool
+	// How alike a new album's title and artist may be to an existing album's before `POST /albums`
+	// refuses it as a likely duplicate, from 0 to 1; 0 turns the check off. See `findDuplicates`.
+	DuplicateThreshold float64
+	// Ranking of `GET /albums/:id/similar`; see `similarity`.
+	SimilarWeights  similarityWeights
+	SimilarEraYears int
+	// File that finished spans are appended to as JSON lines; see `traceRequests`.
+	TraceFile string
+	// How often wishlists are checked for price drops, 0 to never check; see `wishlistWatcher`.
+	WishlistCheckInterval time.Duration
+	// File that price-drop notifications are appended to as JSON lines; see `outboxNotifier`.
+	NotifyOutbox string
+	// Words reviews may not contain, read from `ProfanityFile` or the built-in list.
+	profanity []string
+	// Where finished spans go, opened from `TraceFile`; spans are dropped if nil.
+	spans spanSink
+	// Where price-drop notifications go, opened from `NotifyOutbox`; they are logged if nil.
+	notifier notifier
+}
+
+// `defaultConfig` returns the configuration used when nothing is overridden.
+func defaultConfig() config {
+	return config{
+		Addr:              "localhost:8080",
+		DefaultAPIVersion: "v1",
+		V1Deprecation:     date(time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)),
+		V1Sunset:          date(time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)),
+
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   10 * time.Second,
+		MaxHeaderBytes:    64 << 10,
+		MaxBodyBytes:      1 << 20,
+
+		DevCAOut: filepath.Join("data", "dev-ca.pem"),
+
+		TenantRateBurst: 20,
+
+		AdminUser: "admin",
+
+		CORSAllowedMethods: stringList{"GET", "POST", "PUT", "DELETE"},
+		CORSAllowedHeaders: stringList{"Content-Type", "Authorization", "Idempotency-Key", tenantHeader},
+		CORSMaxAge:         10 * time.Minute,
+
+		IdempotencyTTL:         24 * time.Hour,
+		IdempotencyMaxKeys:     100000,
+		BackupMaxBytes:         256 << 20,
+		BackupMaxUnpackedBytes: 1 << 30,
+		BatchMaxOperations:     100,
+		CacheSize:              1000,
+		CacheTTL:               30 * time.Second,
+		CoverDir:               filepath.Join("data", "covers"),
+		CoverMaxBytes:          5 << 20,
+		profanity:              parseWordList(defaultProfanity),
+
+		StoreFsync:           fsyncAlways,
+		StoreFsyncInterval:   time.Second,
+		StoreCompactInterval: 10 * time.Minute,
+
+		DuplicateThreshold: 0.85,
+
+		SimilarWeights:  similarityWeights{Artist: 3, Genre: 4, Era: 2, Price: 1},
+		SimilarEraYears: 20,
+
+		WishlistCheckInterval: 5 * time.Minute,
+	}
+}
+
+// `loadConfig` builds the configuration from the command-line arguments.
+// Every flag can also be given as a `RECORDS_*` environment variable, e.g. `-idempotency-ttl`
+// is read from `RECORDS_IDEMPOTENCY_TTL`. Flags take precedence over the environment.
+func loadConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("records_api", flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address the HTTP server listens on")
+	fs.StringVar(&cfg.DefaultAPIVersion, "default-api-version", cfg.DefaultAPIVersion, "API version served on unversioned paths: v1 or v2")
+	fs.Var(&cfg.V1Deprecation, "v1-deprecation", "date (YYYY-MM-DD) from which v1 is announced as deprecated, or empty for none")
+	fs.Var(&cfg.V1Sunset, "v1-sunset", "date (YYYY-MM-DD) announced for the removal of v1, or empty for none")
+
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", cfg.ReadHeaderTimeout, "how long a client may take to send the request headers")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "how long a client may take to send the whole request")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "how long writing a response may take")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "how long an idle keep-alive connection is kept open")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long to wait for requests in flight when shutting down")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", cfg.MaxHeaderBytes, "maximum size of the request headers")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", cfg.MaxBodyBytes, "maximum size of a request body (cover uploads use -cover-max-bytes)")
+
+	fs.BoolVar(&cfg.Dev, "dev", cfg.Dev, "development mode: serve HTTPS with an ephemeral self-signed certificate unless -tls-cert is given")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "PEM certificate chain to serve HTTPS with")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "PEM private key of -tls-cert")
+	fs.StringVar(&cfg.DevCAOut, "dev-ca-out", cfg.DevCAOut, "where -dev writes the ephemeral CA certificate for clients to trust")
+	fs.StringVar(&cfg.ClientCA, "client-ca", cfg.ClientCA, "PEM bundle of CAs; clients must present a certificate signed by one of them")
+	fs.BoolVar(&cfg.ClientCertOptional, "client-cert-optional", cfg.ClientCertOptional, "with -client-ca, verify client certificates only when presented")
+
+	fs.BoolVar(&cfg.MultiTenant, "multi-tenant", cfg.MultiTenant, "serve a separate catalogue per tenant, chosen by the X-Tenant-ID header or -tenant-domain subdomain")
+	fs.StringVar(&cfg.TenantDomain, "tenant-domain", cfg.TenantDomain, "with -multi-tenant, the domain whose subdomains name tenants, e.g. records.example.com")
+	fs.Float64Var(&cfg.TenantRateLimit, "tenant-rate-limit", cfg.TenantRateLimit, "with -multi-tenant, requests per second allowed per tenant on average (0 means unlimited)")
+	fs.IntVar(&cfg.TenantRateBurst, "tenant-rate-burst", cfg.TenantRateBurst, "with -tenant-rate-limit, how many requests a tenant may make at once")
+
+	fs.StringVar(&cfg.AdminUser, "admin-user", cfg.AdminUser, "user name for HTTP basic authentication on the admin endpoints")
+	fs.StringVar(&cfg.AdminPassword, "admin-password", cfg.AdminPassword, "password for the admin endpoints; prefer RECORDS_ADMIN_PASSWORD (admin is disabled without a password or token)")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token for the admin endpoints; prefer RECORDS_ADMIN_TOKEN")
+
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "serve the admin-only /debug profiling endpoints from startup (switch them with PUT /admin/debug)")
+
+	fs.Var(&cfg.CORSAllowedOrigins, "cors-allowed-origins", "comma-separated origins allowed to make cross-origin requests, or * for any")
+	fs.Var(&cfg.CORSAllowedMethods, "cors-allowed-methods", "comma-separated methods allowed in cross-origin requests")
+	fs.Var(&cfg.CORSAllowedHeaders, "cors-allowed-headers", "comma-separated request headers allowed in cross-origin requests")
+	fs.BoolVar(&cfg.CORSAllowCredentials, "cors-allow-credentials", cfg.CORSAllowCredentials, "allow cross-origin requests with cookies or HTTP authentication")
+	fs.DurationVar(&cfg.CORSMaxAge, "cors-max-age", cfg.CORSMaxAge, "how long browsers may cache a preflight response")
+
+	fs.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", cfg.IdempotencyTTL, "how long responses to requests with an Idempotency-Key are kept")
+	fs.IntVar(&cfg.IdempotencyMaxKeys, "idempotency-max-keys", cfg.IdempotencyMaxKeys, "maximum number of responses kept for Idempotency-Key retries; the oldest are dropped first")
+	fs.Int64Var(&cfg.BackupMaxBytes, "backup-max-bytes", cfg.BackupMaxBytes, "maximum size of a backup archive sent to POST /admin/restore")
+	fs.Int64Var(&cfg.BackupMaxUnpackedBytes, "backup-max-unpacked-bytes", cfg.BackupMaxUnpackedBytes, "maximum total size of the files in a backup archive once decompressed")
+	fs.IntVar(&cfg.BatchMaxOperations, "batch-max-operations", cfg.BatchMaxOperations, "maximum number of operations in one batch request")
+	fs.IntVar(&cfg.CacheSize, "cache-size", cfg.CacheSize, "maximum number of albums kept in the read cache (0 disables caching)")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", cfg.CacheTTL, "how long cached albums may be served, also used as Cache-Control max-age (0 disables caching)")
+	fs.StringVar(&cfg.CoverDir, "cover-dir", cfg.CoverDir, "directory where album cover images and thumbnails are stored")
+	fs.Int64Var(&cfg.CoverMaxBytes, "cover-max-bytes", cfg.CoverMaxBytes, "maximum size of an uploaded cover image")
+	fs.StringVar(&cfg.DB, "db", cfg.DB, "path of the SQLite database whose schema is checked for pending migrations at startup; albums are not read from or written to it yet")
+	fs.StringVar(&cfg.ProfanityFile, "profanity-file", cfg.ProfanityFile, "file with words reviews may not contain, one per line (default: built-in list)")
+	fs.StringVar(&cfg.StoreDir, "store-dir", cfg.StoreDir, "directory of the album log and snapshot; albums are kept in memory only if empty")
+	fs.StringVar(&cfg.StoreFsync, "store-fsync", cfg.StoreFsync, "when the album log is flushed to disk: always (every write), interval or never")
+	fs.DurationVar(&cfg.StoreFsyncInterval, "store-fsync-interval", cfg.StoreFsyncInterval, "how often the album log is flushed with -store-fsync=interval")
+	fs.DurationVar(&cfg.StoreCompactInterval, "store-compact-interval", cfg.StoreCompactInterval, "how often the album log is compacted into a snapshot (0 disables compaction)")
+	fs.StringVar(&cfg.SeedFile, "seed", cfg.SeedFile, "JSON or YAML file with the albums to start with (default: built-in catalogue)")
+	fs.BoolVar(&cfg.SeedStrict, "seed-strict", cfg.SeedStrict, "refuse to start if any seed album is invalid instead of skipping it")
+	fs.Float64Var(&cfg.DuplicateThreshold, "duplicate-threshold", cfg.DuplicateThreshold, "similarity of title and artist, from 0 to 1, at which POST /albums refuses a new album as a likely duplicate (0 disables the check)")
+	fs.Var(&cfg.SimilarWeights, "similar-weights", "relative weights of the similar-album factors, e.g. artist=3,genre=4,era=2,price=1")
+	fs.IntVar(&cfg.SimilarEraYears, "similar-era-years", cfg.SimilarEraYears, "release years apart at which albums no longer count as the same era")
+	fs.StringVar(&cfg.TraceFile, "trace-file", cfg.TraceFile, "file to append finished trace spans to as JSON lines (default: spans are not exported)")
+	fs.DurationVar(&cfg.WishlistCheckInterval, "wishlist-check-interval", cfg.WishlistCheckInterval, "how often wishlists are checked for price drops (0 disables the checks)")
+	fs.StringVar(&cfg.NotifyOutbox, "notify-outbox", cfg.NotifyOutbox, "file to append price-drop notifications to as JSON lines (default: notifications are logged)")
+	fs.BoolVar(&cfg.AutoMigrate, "auto-migrate", cfg.AutoMigrate, "apply pending migrations at startup instead of refusing to start")
+
+	if err := applyEnv(fs); err != nil {
+		return cfg, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	var err error
+	cfg.profanity, err = loadProfanity(cfg.ProfanityFile)
+	return cfg, err
+}
+
+func (cfg config) validate() error {
+	if _, ok := apiVersions[cfg.DefaultAPIVersion]; !ok {
+		return fmt.Errorf("default-api-version must be v1 or v2, got %q", cfg.DefaultAPIVersion)
+	}
+	if !cfg.V1Sunset.IsZero() && (cfg.V1Deprecation.IsZero() || !cfg.V1Sunset.After(cfg.V1Deprecation)) {
+		return fmt.Errorf("v1-sunset must come after v1-deprecation, got %s and %s", cfg.V1Sunset, cfg.V1Deprecation)
+	}
+	if cfg.ReadHeaderTimeout <= 0 || cfg.ReadTimeout <= 0 || cfg.WriteTimeout <= 0 || cfg.IdleTimeout <= 0 {
+		return errors.New("server timeouts must be positive")
+	}
+	if cfg.MaxHeaderBytes <= 0 || cfg.MaxBodyBytes <= 0 {
+		return errors.New("max-header-bytes and max-body-bytes must be positive")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be given together")
+	}
+	if cfg.TenantRateLimit < 0 || cfg.TenantRateBurst < 1 {
+		return errors.New("tenant-rate-limit must not be negative and tenant-rate-burst must be at least 1")
+	}
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSAllowedOrigins, "*") {
+		return errors.New("cors-allow-credentials cannot be combined with the * origin; list the allowed origins instead")
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		return fmt.Errorf("idempotency-ttl must be positive, got %s", cfg.IdempotencyTTL)
+	}
+	if cfg.IdempotencyMaxKeys <= 0 {
+		return fmt.Errorf("idempotency-max-keys must be positive, got %d", cfg.IdempotencyMaxKeys)
+	}
+	if cfg.BatchMaxOperations <= 0 {
+		return fmt.Errorf("batch-max-operations must be positive, got %d", cfg.BatchMaxOperations)
+	}
+	if cfg.CacheSize < 0 || cfg.CacheTTL < 0 {
+		return errors.New("cache-size and cache-ttl must not be negative")
+	}
+	if !slices.Contains([]string{fsyncAlways, fsyncInterval, fsyncNever}, cfg.StoreFsync) {
+		return fmt.Errorf("store-fsync must be %s, %s or %s, got %q", fsyncAlways, fsyncInterval, fsyncNever, cfg.StoreFsync)
+	}
+	if cfg.StoreFsyncInterval <= 0 || cfg.StoreCompactInterval < 0 {
+		return errors.New("store-fsync-interval must be positive and store-compact-interval must not be negative")
+	}
+	if cfg.SimilarWeights.total() <= 0 {
+		return errors.New("similar-weights must have at least one positive weight")
+	}
+	if cfg.DuplicateThreshold < 0 || cfg.DuplicateThreshold > 1 {
+		return fmt.Errorf("duplicate-threshold must be between 0 and 1, got %g", cfg.DuplicateThreshold)
+	}
+	if cfg.SimilarEraYears <= 0 {
+		return fmt.Errorf("similar-era-years must be positive, got %d", cfg.SimilarEraYears)
+	}
+	if cfg.BackupMaxBytes <= 0 {
+		return fmt.Errorf("backup-max-bytes must be positive, got %d", cfg.BackupMaxBytes)
+	}
+	if cfg.BackupMaxUnpackedBytes <= 0 {
+		return fmt.Errorf("backup-max-unpacked-bytes must be positive, got %d", cfg.BackupMaxUnpackedBytes)
+	}
+	if cfg.CoverMaxBytes <= 0 {
+		return fmt.Errorf("cover-max-bytes must be positive, got %d", cfg.CoverMaxBytes)
+	}
+	if cfg.WishlistCheckInterval < 0 {
+		return fmt.Errorf("wishlist-check-interval must not be negative, got %s", cfg.WishlistCheckInterval)
+	}
+	return nil
+}
+
+func (cfg config) logStoreOptions() logStoreOptions {
+	return logStoreOptions{Fsync: cfg.StoreFsync, FsyncInterval: cfg.StoreFsyncInterval, CompactInterval: cfg.StoreCompactInterval}
+}
+
+// `applyEnv` sets every flag of `fs` that has a matching `RECORDS_*` environment variable.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		key := "RECORDS_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(key); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", key, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// `stringList` is a flag holding a comma-separated list. Setting it replaces the default.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// `date` is a flag holding a day in UTC, written `YYYY-MM-DD`. An empty value is the zero time.
+type date time.Time
+
+func (d date) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return time.Time(d).Format(time.DateOnly)
+}
+
+func (d *date) Set(value string) error {
+	if value == "" {
+		*d = date{}
+		return nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return errors.New("must be a date like 2026-10-19")
+	}
+	*d = date(t)
+	return nil
+}
+
+func (d date) IsZero() bool {
+	return time.Time(d).IsZero()
+}
+
+func (d date) After(other date) bool {
+	return time.Time(d).After(time.Time(other))
+}
@@ -0,0 +1,326 @@
+package records_api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+)
+
+// Longest side, in pixels, of the JPEG thumbnails generated for every cover.
+var thumbnailSizes = []int{64, 256, 512}
+
+// Covers with more pixels than this are rejected before decoding, so a small file can't expand into gigabytes.
+const maxCoverPixels = 50_000_000
+
+var allowedCoverTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+var (
+	errCoverTooLarge  = errors.New("cover image is too large")
+	errCoverType      = errors.New("cover must be a JPEG, PNG or GIF image")
+	errCoverUndecoded = errors.New("cover image could not be decoded")
+	errCoverNotFound  = errors.New("cover not found")
+)
+
+// `albumCover` describes the cover art of an album. The image itself lives in the `coverStore`,
+// addressed by the SHA-256 of its content.
+type albumCover struct {
+	SHA256      string    `json:"sha256"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Thumbnails  []int     `json:"thumbnails"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+}
+
+// `coverStore` keeps cover images and their thumbnails on local disk.
+// Files are named after the SHA-256 of the original upload, so identical uploads are stored once:
+//
+//	<dir>/ab/abcdef...           the original image
+//	<dir>/ab/abcdef..._256.jpg   its 256px thumbnail
+type coverStore struct {
+	dir string
+}
+
+func newCoverStore(dir string) *coverStore {
+	return &coverStore{dir: dir}
+}
+
+// `Save` validates `data` as a cover image and stores it along with its thumbnails.
+func (s *coverStore) Save(data []byte) (albumCover, error) {
+	mime := mimetype.Detect(data)
+	if !mimetype.EqualsAny(mime.String(), allowedCoverTypes...) {
+		return albumCover{}, errCoverType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return albumCover{}, errCoverUndecoded
+	}
+	if config.Width*config.Height > maxCoverPixels {
+		return albumCover{}, errCoverTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return albumCover{}, errCoverUndecoded
+	}
+
+	sum := sha256.Sum256(data)
+	cover := albumCover{
+		SHA256:      hex.EncodeToString(sum[:]),
+		ContentType: mime.String(),
+		Size:        int64(len(data)),
+		Width:       config.Width,
+		Height:      config.Height,
+		Thumbnails:  thumbnailSizes,
+		UploadedAt:  time.Now().UTC(),
+	}
+
+	if err := s.writeFile(s.path(cover.SHA256, 0), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return albumCover{}, err
+	}
+	// Flattened once for all sizes: a copy of a large cover takes up to 200 MB.
+	flat := flatten(img)
+	for _, size := range thumbnailSizes {
+		thumb := thumbnail(flat, size)
+		if err := s.writeFile(s.path(cover.SHA256, size), func(w io.Writer) error {
+			return jpeg.Encode(w, thumb, &jpeg.Options{Quality: 85})
+		}); err != nil {
+			return albumCover{}, err
+		}
+	}
+	return cover, nil
+}
+
+// `Open` opens the original image (`size` 0) or one of its thumbnails.
+func (s *coverStore) Open(sha string, size int) (*os.File, error) {
+	f, err := os.Open(s.path(sha, size))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errCoverNotFound
+	}
+	return f, err
+}
+
+func (s *coverStore) path(sha string, size int) string {
+	name := sha
+	if size > 0 {
+		name = fmt.Sprintf("%s_%d.jpg", sha, size)
+	}
+	return filepath.Join(s.dir, sha[:2], name)
+}
+
+// `writeFile` writes a file atomically through a temporary file. Since content is addressed by hash,
+// an existing file already has the right content and is left alone.
+func (s *coverStore) writeFile(path string, write func(io.Writer) error) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// `flatten` copies `src` into RGBA pixels starting at (0, 0), with transparent areas flattened onto
+// white, since JPEG has no alpha channel. Thumbnails work on these pixels directly; going through
+// `At` for every source pixel is very slow.
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Over)
+	return rgba
+}
+
+// `thumbnail` scales `rgba`, as `flatten` returns it, down so that its longest side is `size` pixels,
+// averaging the source pixels covered by each destination pixel. Images that are already small enough
+// keep their size.
+func thumbnail(rgba *image.RGBA, size int) image.Image {
+	w, h := rgba.Rect.Dx(), rgba.Rect.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		tw, th = size, h*size/w
+		if h > w {
+			tw, th = w*size/h, size
+		}
+		tw, th = max(tw, 1), max(th, 1)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+int(p[0]), g+int(p[1]), b+int(p[2]), a+int(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// `putAlbumCover` stores the cover image of an album. The image is either the raw request body
+// or, for `multipart/form-data` requests, the file in the `cover` field.
+func (s *server) putAlbumCover(c *gin.Context) {
+	ctx := c.Request.Context()
+	a, err := s.albums.Get(ctx, c.Param("id"))
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not get album")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	// The upload limit is applied by `limitBody` in `registerAlbumRoutes`.
+	data, err := readCoverUpload(c)
+	if isBodyTooLarge(err) {
+		writeProblem(c, problemBodyTooLarge, fmt.Sprintf("cover must be at most %d bytes", s.cfg.CoverMaxBytes))
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemBadRequest, err.Error())
+		return
+	}
+
+	cover, err := s.covers.Save(data)
+	switch {
+	case errors.Is(err, errCoverType):
+		writeProblem(c, problemUnsupportedMediaType, err.Error())
+		return
+	case errors.Is(err, errCoverTooLarge), errors.Is(err, errCoverUndecoded):
+		writeProblem(c, problemUnprocessable, err.Error())
+		return
+	case err != nil:
+		writeProblem(c, problemInternal, "could not store cover")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	// The album is read again in the transaction, so writes made while the image was stored are kept.
+	err = s.albums.Transaction(ctx, func(tx albumStore) error {
+		a, err := tx.Get(ctx, a.ID)
+		if err != nil {
+			return err
+		}
+		a.Cover = &cover
+		_, err = tx.Update(ctx, a)
+		return err
+	})
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not update album")
+		logError(c.Request.Context(), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, cover)
+}
+
+func readCoverUpload(c *gin.Context) ([]byte, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		return io.ReadAll(c.Request.Body)
+	}
+
+	header, err := c.FormFile("cover")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			return nil, err
+		}
+		return nil, errors.New("multipart upload must have a file in the `cover` field")
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// `getAlbumCover` serves the cover of an album, or with `?size=N` one of its thumbnails.
+// The URL stays the same when the cover is replaced, so clients must revalidate; the ETag
+// is derived from the content hash, which makes revalidation a cheap `304 Not Modified`.
+func (s *server) getAlbumCover(c *gin.Context) {
+	a, err := s.albums.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errAlbumNotFound) || (err == nil && a.Cover == nil) {
+		writeProblem(c, problemNotFound, "cover not found")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not get album")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	size := 0
+	contentType := a.Cover.ContentType
+	if query := c.Query("size"); query != "" {
+		size, err = strconv.Atoi(query)
+		if err != nil || !slices.Contains(a.Cover.Thumbnails, size) {
+			writeProblem(c, problemBadRequest, fmt.Sprintf("size must be one of %v", a.Cover.Thumbnails))
+			return
+		}
+		contentType = "image/jpeg"
+	}
+
+	f, err := s.covers.Open(a.Cover.SHA256, size)
+	if err != nil {
+		writeProblem(c, problemNotFound, "cover not found")
+		logError(c.Request.Context(), err)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", fmt.Sprintf(`"%s-%d"`, a.Cover.SHA256, size))
+	c.Header("Cache-Control", "public, no-cache")
+	http.ServeContent(c.Writer, c.Request, "", a.Cover.UploadedAt, f)
+}
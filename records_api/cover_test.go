@@ -0,0 +1,109 @@
+package records_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCoverTestRouter(t *testing.T) http.Handler {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.CoverMaxBytes = 64 << 10
+	return newRouter(newServer(cfg, newMemoryStore([]album{{ID: "1", Title: "Blue Train", Artist: "Coltrane", Price: 56.99}})))
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 200, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func putCover(router http.Handler, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/albums/1/cover", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPutAlbumCoverRaw(t *testing.T) {
+	router := newCoverTestRouter(t)
+
+	w := putCover(router, "application/octet-stream", testPNG(t, 600, 300))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; body %s", w.Code, w.Body)
+	}
+	var cover albumCover
+	json.Unmarshal(w.Body.Bytes(), &cover)
+	if cover.ContentType != "image/png" || cover.Width != 600 || cover.Height != 300 {
+		t.Errorf("cover = %+v", cover)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/albums/1/cover?size=256", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("thumbnail: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	thumb, err := jpeg.DecodeConfig(w.Body)
+	if err != nil || thumb.Width != 256 || thumb.Height != 128 {
+		t.Errorf("thumbnail = %dx%d, %v; want 256x128", thumb.Width, thumb.Height, err)
+	}
+
+	etag := w.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/albums/1/cover?size=256", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d; want 304", w.Code)
+	}
+}
+
+func TestPutAlbumCoverMultipart(t *testing.T) {
+	router := newCoverTestRouter(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("cover", "sleeve.png")
+	part.Write(testPNG(t, 32, 32))
+	mw.Close()
+
+	if w := putCover(router, mw.FormDataContentType(), body.Bytes()); w.Code != http.StatusOK {
+		t.Fatalf("status = %d; body %s", w.Code, w.Body)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/albums/1", nil))
+	var a album
+	json.Unmarshal(w.Body.Bytes(), &a)
+	if a.Cover == nil || a.Cover.ContentType != "image/png" {
+		t.Errorf("album cover = %+v", a.Cover)
+	}
+}
+
+func TestPutAlbumCoverRejectsBadUploads(t *testing.T) {
+	router := newCoverTestRouter(t)
+
+	// The declared content type is ignored; the content is sniffed.
+	if w := putCover(router, "image/png", []byte("<html>not an image</html>")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("non-image: status = %d; want 415", w.Code)
+	}
+	if w := putCover(router, "image/png", bytes.Repeat([]byte{0}, 65<<10)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized: status = %d; want 413", w.Code)
+	}
+}
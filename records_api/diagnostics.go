@@ -0,0 +1,179 @@
+package records_api
+
+import (
+	"mime"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `processStart` is when the process started, for the uptime in `/debug/runtime`.
+var processStart = time.Now()
+
+// `diagnostics` switches the `/debug` endpoints on and off. They are off unless `-debug` is given,
+// and can be switched with `PUT /admin/debug` while the server runs, e.g. just while taking a profile.
+type diagnostics struct {
+	enabled atomic.Bool
+}
+
+func newDiagnostics(enabled bool) *diagnostics {
+	d := &diagnostics{}
+	d.enabled.Store(enabled)
+	return d
+}
+
+// `require` hides the routes behind it while the diagnostics are switched off.
+func (d *diagnostics) require(c *gin.Context) {
+	if !d.enabled.Load() {
+		writeProblem(c, problemNotFound, "diagnostics are disabled")
+		return
+	}
+	c.Next()
+}
+
+// `registerDebugRoutes` mounts the `net/http/pprof` handlers under `/debug/pprof` and the runtime report
+// at `/debug/runtime`, both for admins only, and the switch for them at `/admin/debug`.
+// The profiles cover the whole process, so in multi-tenant mode they are served once, beside the tenants.
+func registerDebugRoutes(router *gin.Engine, cfg config, d *diagnostics) {
+	// Credentials are checked first, so only admins can tell whether the diagnostics are on.
+	group := router.Group("/debug", requireAdmin(cfg), d.require)
+	// `pprof.Index` lists the profiles, and serves the named ones like `/debug/pprof/heap` by the path after `/debug/pprof/`.
+	group.GET("/pprof/", gin.WrapF(pprof.Index))
+	group.GET("/pprof/:profile", gin.WrapF(pprof.Index))
+	group.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	group.GET("/pprof/profile", allowSlowWrite(cfg, 30), gin.WrapF(pprof.Profile))
+	group.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	group.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	group.GET("/pprof/trace", allowSlowWrite(cfg, 1), gin.WrapF(pprof.Trace))
+	group.GET("/runtime", getRuntime)
+
+	// Like backups, the switch is used by scripts rather than the console; see `putDiagnostics`.
+	admin := router.Group("/admin", requireAdmin(cfg))
+	admin.GET("/debug", d.getDiagnostics)
+	admin.PUT("/debug", d.putDiagnostics)
+}
+
+// `allowSlowWrite` gives profiles that sample for `?seconds=` (`defaultSeconds` if not given) that long
+// to write on top of the usual `-write-timeout`. `pprof` still refuses samples longer than the timeout.
+func allowSlowWrite(cfg config, defaultSeconds int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		seconds, err := strconv.Atoi(c.Query("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = defaultSeconds
+		}
+		if cfg.WriteTimeout > 0 {
+			deadline := time.Now().Add(time.Duration(seconds)*time.Second + cfg.WriteTimeout)
+			// Fails only for writers that can't have a deadline, such as in tests.
+			http.NewResponseController(c.Writer).SetWriteDeadline(deadline)
+		}
+		c.Next()
+	}
+}
+
+type diagnosticsState struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+func (d *diagnostics) getDiagnostics(c *gin.Context) {
+	enabled := d.enabled.Load()
+	c.IndentedJSON(http.StatusOK, diagnosticsState{Enabled: &enabled})
+}
+
+// `putDiagnostics` switches the `/debug` endpoints: `{"enabled": true}`. It only accepts
+// `Content-Type: application/json`, which a cross-site form cannot send, so it needs no CSRF token.
+func (d *diagnostics) putDiagnostics(c *gin.Context) {
+	if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType != "application/json" {
+		writeProblem(c, problemUnsupportedMediaType, "send the state as application/json")
+		return
+	}
+	var state diagnosticsState
+	if err := tracedBind(c.Request.Context(), c.ShouldBindJSON)(&state); err != nil {
+		writeProblem(c, problemBadRequest, `send {"enabled": true} or {"enabled": false}`)
+		return
+	}
+	if d.enabled.Swap(*state.Enabled) != *state.Enabled {
+		logger.Printf("diagnostics enabled: %v", *state.Enabled)
+	}
+	c.IndentedJSON(http.StatusOK, state)
+}
+
+// `runtimeReport` is the response of `GET /debug/runtime`.
+type runtimeReport struct {
+	StartedAt  time.Time    `json:"startedAt"`
+	Uptime     string       `json:"uptime"`
+	Goroutines int          `json:"goroutines"`
+	GOMAXPROCS int          `json:"gomaxprocs"`
+	NumCPU     int          `json:"numCPU"`
+	Memory     memoryReport `json:"memory"`
+	GC         gcReport     `json:"gc"`
+	Build      *buildReport `json:"build,omitempty"`
+}
+
+type memoryReport struct {
+	HeapAllocBytes  uint64 `json:"heapAllocBytes"`
+	HeapInuseBytes  uint64 `json:"heapInuseBytes"`
+	HeapObjects     uint64 `json:"heapObjects"`
+	TotalAllocBytes uint64 `json:"totalAllocBytes"`
+	SysBytes        uint64 `json:"sysBytes"`
+}
+
+type gcReport struct {
+	NumGC       uint32     `json:"numGC"`
+	LastGC      *time.Time `json:"lastGC,omitempty"`
+	LastPause   string     `json:"lastPause"`
+	PauseTotal  string     `json:"pauseTotal"`
+	NextGCBytes uint64     `json:"nextGCBytes"`
+	CPUFraction float64    `json:"cpuFraction"`
+}
+
+type buildReport struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+}
+
+// `getRuntime` reports the goroutines, memory, garbage collector, build and uptime of the process.
+func getRuntime(c *gin.Context) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	report := runtimeReport{
+		StartedAt:  processStart.UTC(),
+		Uptime:     time.Since(processStart).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		Memory: memoryReport{
+			HeapAllocBytes:  m.HeapAlloc,
+			HeapInuseBytes:  m.HeapInuse,
+			HeapObjects:     m.HeapObjects,
+			TotalAllocBytes: m.TotalAlloc,
+			SysBytes:        m.Sys,
+		},
+		GC: gcReport{
+			NumGC:       m.NumGC,
+			PauseTotal:  time.Duration(m.PauseTotalNs).String(),
+			LastPause:   time.Duration(m.PauseNs[(m.NumGC+255)%256]).String(),
+			NextGCBytes: m.NextGC,
+			CPUFraction: m.GCCPUFraction,
+		},
+	}
+	if m.NumGC > 0 {
+		last := time.Unix(0, int64(m.LastGC)).UTC()
+		report.GC.LastGC = &last
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.Build = &buildReport{GoVersion: info.GoVersion, Path: info.Path, Version: info.Main.Version, Settings: map[string]string{}}
+		for _, setting := range info.Settings {
+			report.Build.Settings[setting.Key] = setting.Value
+		}
+	}
+	c.IndentedJSON(http.StatusOK, report)
+}
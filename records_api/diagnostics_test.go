@@ -0,0 +1,82 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newDiagnosticsTestRouter(t *testing.T, debug bool) http.Handler {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminToken = "test-token"
+	cfg.Debug = debug
+	return newRouter(newServer(cfg, newMemoryStore(albums)))
+}
+
+func TestDiagnosticsRequireAdmin(t *testing.T) {
+	router := newDiagnosticsTestRouter(t, true)
+
+	for _, path := range []string{"/debug/runtime", "/debug/pprof/", "/debug/pprof/heap", "/admin/debug"} {
+		if w := serve(router, http.MethodGet, path, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without credentials = %d; want 401", path, w.Code)
+		}
+	}
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline"} {
+		if w := adminRequest(router, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	w := adminRequest(router, http.MethodGet, "/debug/runtime", "", nil)
+	var report runtimeReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /debug/runtime = %d %s", w.Code, w.Body)
+	}
+	if report.Goroutines < 1 || report.Memory.HeapAllocBytes == 0 || report.Uptime == "" || report.StartedAt.IsZero() {
+		t.Errorf("runtime report = %+v", report)
+	}
+}
+
+func TestDiagnosticsToggle(t *testing.T) {
+	router := newDiagnosticsTestRouter(t, false)
+
+	if w := adminRequest(router, http.MethodGet, "/debug/runtime", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("disabled /debug/runtime = %d; want 404", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/debug/runtime", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("disabled /debug/runtime without credentials = %d; want 401, as when enabled", w.Code)
+	}
+	if w := adminRequest(router, http.MethodPut, "/admin/debug", "text/plain", []byte(`{"enabled": true}`)); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("switch as text/plain = %d; want 415", w.Code)
+	}
+	if w := adminRequest(router, http.MethodPut, "/admin/debug", "application/json", []byte(`{}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("switch without state = %d; want 400", w.Code)
+	}
+
+	if w := adminRequest(router, http.MethodPut, "/admin/debug", "application/json", []byte(`{"enabled": true}`)); w.Code != http.StatusOK {
+		t.Fatalf("enable = %d %s", w.Code, w.Body)
+	}
+	if w := adminRequest(router, http.MethodGet, "/debug/runtime", "", nil); w.Code != http.StatusOK {
+		t.Errorf("enabled /debug/runtime = %d; want 200", w.Code)
+	}
+	adminRequest(router, http.MethodPut, "/admin/debug", "application/json", []byte(`{"enabled": false}`))
+	if w := adminRequest(router, http.MethodGet, "/admin/debug", "", nil); !strings.Contains(w.Body.String(), `"enabled": false`) {
+		t.Errorf("GET /admin/debug = %s; want disabled", w.Body)
+	}
+	if w := adminRequest(router, http.MethodGet, "/debug/pprof/heap", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("disabled /debug/pprof/heap = %d; want 404", w.Code)
+	}
+}
+
+func TestDiagnosticsMultiTenant(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Debug = true
+	router := newTenantTestRouter(t, cfg, "shop-a")
+
+	// The diagnostics cover the process, so they need no tenant.
+	if w := adminRequest(router, http.MethodGet, "/debug/runtime", "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /debug/runtime = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,102 @@
+package records_api
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// `maxDuplicateCandidates` is how many near-duplicates a refused `POST /albums` lists at most.
+const maxDuplicateCandidates = 5
+
+// `duplicateMatch` is an existing album that looks like the one being created.
+type duplicateMatch struct {
+	album album
+	score float64
+}
+
+// `duplicateCandidate` is a `duplicateMatch` as the 409 response lists it, with the album in the request's version.
+type duplicateCandidate struct {
+	Album any     `json:"album"`
+	Score float64 `json:"score"`
+}
+
+// `findDuplicates` returns the albums of `catalogue` whose title and artist are at least `threshold`
+// similar to those of `a`, most similar first. See `duplicateScore`.
+func findDuplicates(a album, catalogue []album, threshold float64) []duplicateMatch {
+	title, artist := normalizeForMatch(a.Title), normalizeForMatch(a.Artist)
+	var matches []duplicateMatch
+	for _, existing := range catalogue {
+		score := duplicateScore(title, artist, existing)
+		if score >= threshold {
+			matches = append(matches, duplicateMatch{album: existing, score: score})
+		}
+	}
+	slices.SortStableFunc(matches, func(x, y duplicateMatch) int { return cmp.Compare(y.score, x.score) })
+	return matches[:min(len(matches), maxDuplicateCandidates)]
+}
+
+// `duplicateScore` is the average similarity of the normalized `title` and `artist` to those of `existing`,
+// from 0 to 1, rounded to two decimals. "Blue Train" by "J. Coltrane" scores 0.88 against "Blue Train"
+// by "John Coltrane": the titles match, and the artists are three edits apart in thirteen letters.
+func duplicateScore(title, artist string, existing album) float64 {
+	score := (textSimilarity(title, normalizeForMatch(existing.Title)) + textSimilarity(artist, normalizeForMatch(existing.Artist))) / 2
+	return math.Round(score*100) / 100
+}
+
+// `normalizeForMatch` lowercases `s`, strips diacritics and turns every run of punctuation and spaces
+// into a single space, so "Sonny Rollins" and "sonny  rollins!" compare equal, as do "Beyoncé" and "Beyonce".
+func normalizeForMatch(s string) string {
+	var b strings.Builder
+	gap := false
+	// In the canonical decomposition, accented letters are a base letter followed by combining marks.
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if gap && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			gap = false
+			b.WriteRune(r)
+		default:
+			gap = true
+		}
+	}
+	return b.String()
+}
+
+// `textSimilarity` is 1 minus the edit distance between `a` and `b` relative to the longer of the two.
+func textSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// `levenshtein` counts the insertions, deletions and substitutions that turn `a` into `b`.
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,90 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeForMatch(t *testing.T) {
+	tests := map[string]string{
+		"Blue Train":             "blue train",
+		"  J. Coltrane ":         "j coltrane",
+		"Beyoncé":                "beyonce",
+		"Sarah Vaughan & Brown!": "sarah vaughan brown",
+		"Mötley--Crüe":           "motley crue",
+		"...":                    "",
+	}
+	for in, want := range tests {
+		if got := normalizeForMatch(in); got != want {
+			t.Errorf("normalizeForMatch(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"j coltrane", "john coltrane", 3},
+		{"ünïcode", "unicode", 2},
+	}
+	for _, tt := range tests {
+		if got := levenshtein([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d; want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	catalogue := []album{
+		{ID: "1", Title: "Blue Train", Artist: "John Coltrane"},
+		{ID: "2", Title: "Blue Trains", Artist: "John Coltrane"},
+		{ID: "3", Title: "Giant Steps", Artist: "John Coltrane"},
+	}
+	matches := findDuplicates(album{Title: "blue train!", Artist: "J. Coltrane"}, catalogue, 0.8)
+	if len(matches) != 2 || matches[0].album.ID != "1" || matches[0].score != 0.88 || matches[1].album.ID != "2" {
+		t.Errorf("matches = %+v; want albums 1 (0.88) and 2", matches)
+	}
+	if matches := findDuplicates(album{Title: "Giant Steps", Artist: "Coltrane"}, catalogue, 0.85); len(matches) != 0 {
+		t.Errorf("matches = %+v; want none below the threshold", matches)
+	}
+}
+
+func TestPostAlbumsRefusesDuplicates(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+	body := `{"title": "Blue Train", "artist": "J. Coltrane", "price": 19.99}`
+
+	w := serve(router, http.MethodPost, "/albums", body)
+	p := decodeProblem(t, w, problemDuplicate)
+	if len(p.Candidates) != 1 || p.Candidates[0].Score != 0.88 {
+		t.Fatalf("candidates = %+v; want Blue Train by John Coltrane", p.Candidates)
+	}
+	var candidate album
+	encoded, _ := json.Marshal(p.Candidates[0].Album)
+	if json.Unmarshal(encoded, &candidate); candidate.ID != "1" {
+		t.Errorf("candidate = %s; want album 1", encoded)
+	}
+
+	if w := serve(router, http.MethodPost, "/albums?force=maybe", body); w.Code != http.StatusBadRequest {
+		t.Errorf("force=maybe = %d; want 400", w.Code)
+	}
+	if w := serve(router, http.MethodPost, "/albums?force=true", body); w.Code != http.StatusCreated {
+		t.Errorf("force=true = %d %s; want 201", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`); w.Code != http.StatusCreated {
+		t.Errorf("different title = %d %s; want 201", w.Code, w.Body)
+	}
+
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.DuplicateThreshold = 0
+	router = newRouter(newServer(cfg, newMemoryStore(albums)))
+	if w := serve(router, http.MethodPost, "/albums", body); w.Code != http.StatusCreated {
+		t.Errorf("with the check off = %d %s; want 201", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,134 @@
+package records_api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `newHTTPServer` returns an `http.Server` for `handler` with the limits from `cfg`.
+// Go's zero-value server has no timeouts at all, so one slow client could hold a connection forever.
+func newHTTPServer(cfg config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// `newEngine` returns a gin engine with the middleware every part of the API shares: tracing, the
+// access log, recovery from panics, and the `securityHeaders`, `cors` and `limitBody` policies.
+// Tracing comes first so that the request's span also covers the others, and sees panics as 500s.
+func newEngine(cfg config) *gin.Engine {
+	engine := gin.New()
+	engine.Use(traceRequests(cfg.spans), gin.LoggerWithFormatter(accessLog), recoverProblem())
+	engine.Use(securityHeaders(), cors(cfg), limitBody(cfg.MaxBodyBytes), identifyClient)
+	engine.NoRoute(routeNotFound)
+	return engine
+}
+
+const rawBodyKey = "rawBody"
+
+// `limitBody` cuts request bodies off after `limit` bytes: reading further fails with an error that
+// `isBodyTooLarge` recognizes, which handlers answer with 413. When applied twice, e.g. globally
+// and on a single route, the innermost limit wins, so a route can allow larger bodies than the rest.
+func limitBody(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := c.Get(rawBodyKey)
+		if !ok {
+			raw = c.Request.Body
+			c.Set(rawBodyKey, raw)
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, raw.(io.ReadCloser), limit)
+		c.Next()
+	}
+}
+
+// `isBodyTooLarge` reports whether `err` came from reading past the limit set by `limitBody`.
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// `cors` implements cross-origin resource sharing for the origins in `cfg`.
+// Requests from other origins get no CORS headers, so browsers won't let pages read the responses,
+// and their preflight requests are refused.
+func cors(cfg config) gin.HandlerFunc {
+	anyOrigin := slices.Contains(cfg.CORSAllowedOrigins, "*")
+	methods := strings.Join(cfg.CORSAllowedMethods, ", ")
+	headers := strings.Join(cfg.CORSAllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.CORSMaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		allowed := anyOrigin || slices.Contains(cfg.CORSAllowedOrigins, origin)
+		if !allowed {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if anyOrigin && !cfg.CORSAllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.CORSAllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", "API-Version, Deprecation, Sunset, Link, ETag, Idempotent-Replayed, traceresponse")
+			c.Next()
+			return
+		}
+		if !containsFold(cfg.CORSAllowedMethods, c.GetHeader("Access-Control-Request-Method")) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Header("Access-Control-Allow-Methods", methods)
+		c.Header("Access-Control-Allow-Headers", headers)
+		c.Header("Access-Control-Max-Age", maxAge)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func containsFold(values []string, v string) bool {
+	return slices.ContainsFunc(values, func(value string) bool { return strings.EqualFold(value, v) })
+}
+
+// `securityHeaders` sets the standard hardening headers on every response. The API only serves
+// JSON and images, so the content security policy forbids everything else.
+func securityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if c.Request.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		c.Next()
+	}
+}
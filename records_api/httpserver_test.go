@@ -0,0 +1,137 @@
+package records_api
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowHeadersAreCutOff(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ReadHeaderTimeout = 100 * time.Millisecond
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = newHTTPServer(cfg, http.NotFoundHandler())
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Send the request line and never finish the headers.
+	if _, err := io.WriteString(conn, "GET /albums HTTP/1.1\r\nHost: example\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.Copy(io.Discard, conn)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("connection was kept open for %s; want it closed after the read header timeout", elapsed)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.MaxBodyBytes = 64
+	router := newRouter(newServer(cfg, newMemoryStore(albums)))
+
+	body := `{"title":"Giant","artist":"Coltrane","price":19.99,"padding":"` + strings.Repeat("x", 100) + `"}`
+	if w := serve(router, http.MethodPost, "/albums", body); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized album = %d; want 413", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", "too-large")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized album with Idempotency-Key = %d; want 413", w.Code)
+	}
+	if w := serve(router, http.MethodPost, "/albums", `{"title":"Giant","artist":"Coltrane","price":1}`); w.Code != http.StatusCreated {
+		t.Errorf("small album = %d; want 201", w.Code)
+	}
+
+	// Covers have their own, larger limit.
+	cover := bytes.Repeat([]byte{0}, 1000)
+	req = httptest.NewRequest(http.MethodPut, "/albums/1/cover", bytes.NewReader(cover))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("cover larger than the body limit = %d; want 415 for the unsupported type, not 413", w.Code)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.CORSAllowedOrigins = stringList{"https://shop.example"}
+	cfg.CORSAllowCredentials = true
+	router := newRouter(newServer(cfg, newMemoryStore(albums)))
+
+	preflight := func(origin, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/albums", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("https://shop.example", "POST")
+	if w.Code != http.StatusNoContent ||
+		w.Header().Get("Access-Control-Allow-Origin") != "https://shop.example" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "Idempotency-Key") {
+		t.Errorf("allowed preflight = %d %v", w.Code, w.Header())
+	}
+	if w := preflight("https://evil.example", "POST"); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight from another origin = %d %v; want 403 without CORS headers", w.Code, w.Header())
+	}
+	if w := preflight("https://shop.example", "PATCH"); w.Code != http.StatusForbidden {
+		t.Errorf("preflight for a disallowed method = %d; want 403", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("simple request from another origin = %v; want no CORS headers", w.Header())
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	w := serve(router, http.MethodGet, "/albums", "")
+	for _, header := range []string{"X-Content-Type-Options", "X-Frame-Options", "Referrer-Policy", "Content-Security-Policy"} {
+		if w.Header().Get(header) == "" {
+			t.Errorf("%s is missing", header)
+		}
+	}
+	if w.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS sent over plain HTTP")
+	}
+
+	// Unknown routes get the headers too.
+	if w := serve(router, http.MethodGet, "/nope", ""); w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("404 headers = %v", w.Header())
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	if _, err := loadConfig([]string{"-cors-allowed-origins", "*", "-cors-allow-credentials"}); err == nil {
+		t.Error("credentials with the * origin were accepted")
+	}
+	cfg, err := loadConfig([]string{"-cors-allowed-origins", "https://a.example, https://b.example"})
+	if err != nil || len(cfg.CORSAllowedOrigins) != 2 || cfg.CORSAllowedOrigins[1] != "https://b.example" {
+		t.Errorf("origins = %q, %v", cfg.CORSAllowedOrigins, err)
+	}
+}
@@ -0,0 +1,278 @@
+package records_api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// `idempotencyEntry` is what we remember about one `Idempotency-Key`.
+// While the first request is still being handled, `done` is open and concurrent duplicates wait on it.
+type idempotencyEntry struct {
+	fingerprint string
+	done        chan struct{}
+
+	// Only valid once `done` is closed.
+	recorded bool
+	expires  time.Time
+	status   int
+	header   http.Header
+	body     []byte
+}
+
+// Expired entries are swept at most this often, or once per TTL if that is shorter.
+const idempotencySweepInterval = time.Minute
+
+// `idempotencyStore` keeps the responses of requests that carried an `Idempotency-Key` header,
+// so that a retried request can be answered with the stored response instead of being executed again.
+// It holds at most `maxKeys` stored responses; past that, those closest to expiring are dropped first.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	maxKeys   int
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+	// Called when a duplicate starts waiting for the request holding its key; tests use it to line requests up.
+	waiting func()
+}
+
+func newIdempotencyStore(ttl time.Duration, maxKeys int) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// `reserve` returns the entry for `key`, creating an in-flight one if there is none (or the old one expired).
+// `created` reports whether the caller now owns the entry and must `complete` or `release` it.
+func (s *idempotencyStore) reserve(key, fingerprint string) (entry *idempotencyEntry, created bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && !entry.expired(now) {
+		return entry, false
+	}
+	if len(s.entries) >= s.maxKeys {
+		s.evict(len(s.entries) - s.maxKeys + 1)
+	}
+	entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = entry
+	return entry, true
+}
+
+// `complete` stores the response of the request owning `entry` and wakes up any waiting duplicates.
+func (s *idempotencyStore) complete(entry *idempotencyEntry, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.recorded = true
+	entry.expires = s.now().Add(s.ttl)
+	entry.status = status
+	entry.header = header
+	entry.body = body
+	close(entry.done)
+}
+
+// `release` forgets `entry` without storing a response, so the next retry is executed again.
+func (s *idempotencyStore) release(key string, entry *idempotencyEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries[key] == entry {
+		delete(s.entries, key)
+	}
+	close(entry.done)
+}
+
+// `sweep` drops expired entries, at most once per `idempotencySweepInterval`. Must be called with `mu` held.
+func (s *idempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < min(s.ttl, idempotencySweepInterval) {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// `evict` drops the `n` stored responses that would expire first. In-flight entries are kept, since their
+// requests are still running; there can't be more of them than requests being served. Must be called with `mu` held.
+func (s *idempotencyStore) evict(n int) {
+	var stored []string
+	for key, entry := range s.entries {
+		if entry.isDone() {
+			stored = append(stored, key)
+		}
+	}
+	slices.SortFunc(stored, func(a, b string) int { return s.entries[a].expires.Compare(s.entries[b].expires) })
+	for _, key := range stored[:min(n, len(stored))] {
+		delete(s.entries, key)
+	}
+}
+
+func (e *idempotencyEntry) isDone() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// In-flight entries never expire; they are removed by `complete` or `release`.
+func (e *idempotencyEntry) expired(now time.Time) bool {
+	return e.isDone() && e.recorded && now.After(e.expires)
+}
+
+// `idempotencyScope` names whoever sent the request: keys are picked by clients, so one client's key must not
+// replay another's response. Requests with an `Authorization` header are scoped to their credentials, those with
+// a verified client certificate to its subject, and the others to the client's address. Each tenant has its own
+// store, so keys are never shared across tenants either.
+func idempotencyScope(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(sum[:])
+	}
+	if subject, ok := clientSubject(c); ok {
+		return "cert:" + subject.String()
+	}
+	return "addr:" + c.ClientIP()
+}
+
+// `requestFingerprint` identifies a request by its method, target (the path and query) and body.
+// Reusing a key for a request with a different fingerprint is a client error, so a request
+// refused as a duplicate can't be retried with `?force=true` under the same key.
+func requestFingerprint(method, target string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, method)
+	h.Write([]byte{0})
+	io.WriteString(h, target)
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// `idempotencyRecorder` tees everything the handler writes so it can be stored afterwards.
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *idempotencyRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// `idempotent` is a middleware for non-idempotent endpoints honouring the `Idempotency-Key` header.
+// The first request with a given key is executed and its response stored for the store's TTL.
+// Keys are scoped to the client sending them; see `idempotencyScope`.
+// Retries with the same key and body get the stored response replayed, and retries with a different
+// body are rejected with 422. Duplicates arriving while the first request is still running wait for it.
+// Server errors (5xx) are not stored, so the client may retry them.
+func idempotent(store *idempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(c, problemBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if isBodyTooLarge(err) {
+			writeProblem(c, problemBodyTooLarge, "request body is too large")
+			return
+		}
+		if err != nil {
+			writeProblem(c, problemBadRequest, "could not read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.RequestURI(), body)
+		key = idempotencyScope(c) + " " + key
+
+		var entry *idempotencyEntry
+		for {
+			var created bool
+			entry, created = store.reserve(key, fingerprint)
+			if created {
+				break
+			}
+			if entry.fingerprint != fingerprint {
+				writeProblem(c, problemUnprocessable, "Idempotency-Key was already used with a different request")
+				return
+			}
+
+			if store.waiting != nil {
+				store.waiting()
+			}
+			select {
+			case <-entry.done:
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+			if entry.recorded {
+				replayIdempotent(c, entry)
+				return
+			}
+			// The first request failed and gave the key back, so try to take it ourselves.
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				store.release(key, entry)
+			}
+		}()
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if status := recorder.Status(); status < http.StatusInternalServerError {
+			store.complete(entry, status, recorder.Header().Clone(), recorder.body.Bytes())
+			completed = true
+		}
+	}
+}
+
+// `replayIdempotent` writes a stored response back to the client.
+func replayIdempotent(c *gin.Context, entry *idempotencyEntry) {
+	for name, values := range entry.header {
+		c.Writer.Header()[name] = values
+	}
+	c.Header(idempotentReplayedHeader, "true")
+	c.Writer.WriteHeader(entry.status)
+	c.Writer.Write(entry.body)
+	c.Abort()
+}
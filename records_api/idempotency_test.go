@@ -0,0 +1,253 @@
+package records_api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// `newIdempotentTestRouter` returns a router whose POST handler counts how often it really ran.
+func newIdempotentTestRouter(store *idempotencyStore, handler gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.POST("/things", identifyClient, idempotent(store), handler)
+	return router
+}
+
+func postWithKey(router http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotentReplaysStoredResponse(t *testing.T) {
+	var calls atomic.Int32
+	router := newIdempotentTestRouter(newIdempotencyStore(time.Hour, 100), func(c *gin.Context) {
+		n := calls.Add(1)
+		c.Header("X-Call", strconv.Itoa(int(n)))
+		c.String(http.StatusCreated, "created %d", n)
+	})
+
+	first := postWithKey(router, "abc", `{"a":1}`)
+	second := postWithKey(router, "abc", `{"a":1}`)
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times; want 1", calls.Load())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q; want %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get("X-Call") != "1" || second.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("replay headers = %v", second.Header())
+	}
+}
+
+func TestIdempotentRejectsDifferentBody(t *testing.T) {
+	router := newIdempotentTestRouter(newIdempotencyStore(time.Hour, 100), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	postWithKey(router, "abc", `{"a":1}`)
+	if w := postWithKey(router, "abc", `{"a":2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d; want 422", w.Code)
+	}
+}
+
+func TestIdempotentWithoutKeyAlwaysRuns(t *testing.T) {
+	var calls atomic.Int32
+	router := newIdempotentTestRouter(newIdempotencyStore(time.Hour, 100), func(c *gin.Context) {
+		calls.Add(1)
+		c.Status(http.StatusCreated)
+	})
+
+	postWithKey(router, "", `{}`)
+	postWithKey(router, "", `{}`)
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times; want 2", calls.Load())
+	}
+}
+
+func TestIdempotentDoesNotStoreServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	router := newIdempotentTestRouter(newIdempotencyStore(time.Hour, 100), func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	postWithKey(router, "abc", `{}`)
+	if w := postWithKey(router, "abc", `{}`); w.Code != http.StatusCreated {
+		t.Errorf("retry status = %d; want 201", w.Code)
+	}
+}
+
+func TestIdempotentEntriesExpire(t *testing.T) {
+	var calls atomic.Int32
+	store := newIdempotencyStore(time.Minute, 100)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	router := newIdempotentTestRouter(store, func(c *gin.Context) {
+		calls.Add(1)
+		c.Status(http.StatusCreated)
+	})
+
+	postWithKey(router, "abc", `{}`)
+	now = now.Add(2 * time.Minute)
+	postWithKey(router, "abc", `{}`)
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times; want 2", calls.Load())
+	}
+}
+
+func TestIdempotentConcurrentDuplicates(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	store := newIdempotencyStore(time.Hour, 100)
+	router := newIdempotentTestRouter(store, func(c *gin.Context) {
+		calls.Add(1)
+		<-release
+		c.String(http.StatusCreated, "done")
+	})
+
+	const n = 10
+	// All but the first request wait for it; release it once they do.
+	var waiting sync.WaitGroup
+	waiting.Add(n - 1)
+	store.waiting = waiting.Done
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = postWithKey(router, "abc", `{"a":1}`)
+		}(i)
+	}
+
+	waiting.Wait()
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times; want 1", calls.Load())
+	}
+	for i, w := range results {
+		if w.Code != http.StatusCreated || w.Body.String() != "done" {
+			t.Errorf("request %d = %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestIdempotencyKeysAreScopedToTheClient(t *testing.T) {
+	var calls atomic.Int32
+	router := newIdempotentTestRouter(newIdempotencyStore(time.Hour, 100), func(c *gin.Context) {
+		c.String(http.StatusCreated, "created %d for %s", calls.Add(1), c.GetHeader("Authorization"))
+	})
+	post := func(auth, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, "abc")
+		req.Header.Set("Authorization", auth)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	post("Bearer ann", "192.0.2.1:1234")
+	if w := post("Bearer bob", "192.0.2.1:1234"); w.Body.String() != "created 2 for Bearer bob" {
+		t.Errorf("another credential got %q; want its own response", w.Body)
+	}
+	if w := post("Bearer ann", "192.0.2.9:1234"); w.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("same credential from another address got %q; want the replay", w.Body)
+	}
+	post("", "192.0.2.1:1234")
+	if w := post("", "192.0.2.2:1234"); w.Body.String() != "created 4 for " {
+		t.Errorf("another address got %q; want its own response", w.Body)
+	}
+}
+
+func TestIdempotencyStoreIsBounded(t *testing.T) {
+	store := newIdempotencyStore(time.Hour, 3)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	router := newIdempotentTestRouter(store, func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	for i := 0; i < 5; i++ {
+		postWithKey(router, strconv.Itoa(i), `{}`)
+		now = now.Add(time.Second)
+	}
+	if len(store.entries) != 3 {
+		t.Fatalf("store has %d entries; want 3", len(store.entries))
+	}
+	if w := postWithKey(router, "4", `{}`); w.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Error("the newest key was dropped")
+	}
+	if w := postWithKey(router, "0", `{}`); w.Header().Get(idempotentReplayedHeader) != "" {
+		t.Error("the oldest key was kept")
+	}
+}
+
+func TestIdempotencyStoreSweepsExpiredEntries(t *testing.T) {
+	store := newIdempotencyStore(time.Hour, 100)
+	start := time.Now()
+	now := start
+	store.now = func() time.Time { return now }
+	router := newIdempotentTestRouter(store, func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	postWithKey(router, "a", `{}`)
+	// This request sweeps just before "a" expires, and the next one sweeps again soon after, not a whole TTL later.
+	now = start.Add(time.Hour)
+	postWithKey(router, "b", `{}`)
+	now = now.Add(2 * idempotencySweepInterval)
+	postWithKey(router, "c", `{}`)
+	if len(store.entries) != 2 {
+		t.Errorf("store has %d entries; want 2 once \"a\" expired", len(store.entries))
+	}
+}
+
+func TestIdempotencyKeysAreScopedToTheClientCertificate(t *testing.T) {
+	var calls atomic.Int32
+	router := newIdempotentTestRouter(newIdempotencyStore(time.Hour, 100), func(c *gin.Context) {
+		c.String(http.StatusCreated, "created %d", calls.Add(1))
+	})
+	post := func(commonName, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, "abc")
+		req.RemoteAddr = addr
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}}}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	post("billing", "192.0.2.1:1234")
+	if w := post("shipping", "192.0.2.1:1234"); w.Body.String() != "created 2" {
+		t.Errorf("another certificate from the same address got %q; want its own response", w.Body)
+	}
+	if w := post("billing", "192.0.2.9:1234"); w.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("same certificate from another address got %q; want the replay", w.Body)
+	}
+}
@@ -0,0 +1,565 @@
+package records_api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The files of a `logStore` directory. Both start with a magic line followed by frames, where a frame is
+// the length of its payload and the CRC-32C of the payload as big-endian uint32s, then the payload itself.
+const (
+	logFileName      = "albums.log"
+	snapshotFileName = "albums.snapshot"
+	logMagic         = "RECLOG1\n"
+	snapshotMagic    = "RECSNP1\n"
+	frameHeaderSize  = 8
+)
+
+// How `logStore` flushes the log to disk.
+const (
+	// Every write is on disk before it is acknowledged.
+	fsyncAlways = "always"
+	// The log is flushed every `-store-fsync-interval`; a crash loses at most that much.
+	fsyncInterval = "interval"
+	// Flushing is left to the operating system.
+	fsyncNever = "never"
+)
+
+var (
+	crcTable       = crc32.MakeTable(crc32.Castagnoli)
+	errChecksum    = errors.New("checksum mismatch")
+	errStoreClosed = errors.New("album store is closed")
+)
+
+type logStoreOptions struct {
+	Fsync           string
+	FsyncInterval   time.Duration
+	CompactInterval time.Duration
+}
+
+// `logStore` keeps albums in memory and makes them durable with an append-only log of every committed write.
+// Each log entry carries a sequence number. `Compact` writes all albums to a snapshot that records the last
+// sequence number it includes, then starts an empty log; replaying skips entries the snapshot already has,
+// so a crash between the two steps is harmless.
+//
+// Reads are served from memory and never wait for the disk. Writers are serialized: each one works on a
+// copy of the albums, appends its changes as a single entry and only then makes them visible.
+type logStore struct {
+	dir  string
+	opts logStoreOptions
+	// `state` serves reads. It is replaced as a whole after every committed write.
+	state *memoryStore
+
+	// `mu` serializes writes, syncs and compactions, and guards the fields below.
+	mu      sync.Mutex
+	log     *os.File
+	logSize int64
+	seq     uint64
+	dirty   bool
+	// `err` is set once the log can no longer be trusted, e.g. after a failed fsync, and fails every later write.
+	err error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// `logEntry` is one committed write: an operation, or all operations of a transaction.
+type logEntry struct {
+	Seq uint64  `json:"seq"`
+	Ops []logOp `json:"ops"`
+}
+
+type logOp struct {
+	Op    string `json:"op"`
+	Album *album `json:"album,omitempty"`
+	ID    string `json:"id,omitempty"`
+}
+
+type logSnapshot struct {
+	Seq    uint64  `json:"seq"`
+	NextID int     `json:"nextID"`
+	Albums []album `json:"albums"`
+}
+
+// `openLogStore` opens the store in `dir`, creating it if needed, and rebuilds the albums from the snapshot
+// and the log. A new store starts with the `seed` albums.
+func openLogStore(dir string, opts logStoreOptions, seed []album) (*logStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &logStore{dir: dir, opts: opts, state: newMemoryStore(nil), stop: make(chan struct{}), done: make(chan struct{})}
+
+	hasSnapshot, err := s.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.replay()
+	if err != nil {
+		return nil, err
+	}
+	if !hasSnapshot && entries == 0 && len(seed) > 0 {
+		err := s.write(func(tx albumStore) error {
+			for _, a := range seed {
+				if _, err := tx.Create(context.Background(), a); err != nil {
+					return fmt.Errorf("seed album %s: %w", a.ID, err)
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			err = s.Sync()
+		}
+		if err != nil {
+			s.log.Close()
+			return nil, err
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+func (s *logStore) loadSnapshot() (bool, error) {
+	path := filepath.Join(s.dir, snapshotFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Snapshots are renamed into place once complete, so unlike the log they cannot have a torn tail.
+	payload, ok := bytes.CutPrefix(data, []byte(snapshotMagic))
+	if !ok {
+		return false, fmt.Errorf("%s is not an album snapshot", path)
+	}
+	payload, err = readFrame(bufio.NewReader(bytes.NewReader(payload)), int64(len(payload)))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	var snap logSnapshot
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	s.state = &memoryStore{albums: snap.Albums, nextID: snap.NextID}
+	s.seq = snap.Seq
+	return true, nil
+}
+
+// `replay` applies the log entries newer than the snapshot and returns how many entries the log has.
+// An incomplete entry at the end, left by a crash in the middle of a write, is cut off.
+// A damaged entry anywhere else is an error, since dropping it would silently lose the writes after it.
+func (s *logStore) replay() (int, error) {
+	path := filepath.Join(s.dir, logFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	s.log = f
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(logMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		// An empty log, or one whose creation was cut short.
+		if !bytes.HasPrefix([]byte(logMagic), magic[:info.Size()]) {
+			f.Close()
+			return 0, fmt.Errorf("%s is not an album log", path)
+		}
+		return 0, s.resetLog()
+	}
+	if string(magic) != logMagic {
+		f.Close()
+		return 0, fmt.Errorf("%s is not an album log", path)
+	}
+
+	offset, entries := int64(len(logMagic)), 0
+	for {
+		payload, err := readFrame(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		torn := errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, errChecksum) && offset+frameHeaderSize+int64(len(payload)) == info.Size()
+		if torn {
+			logger.Printf("store: %s has an incomplete entry at offset %d, probably from a crash; truncating it", path, offset)
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return 0, err
+			}
+			if err := f.Sync(); err != nil {
+				f.Close()
+				return 0, err
+			}
+			break
+		}
+		if err == nil {
+			err = s.applyEntry(payload)
+		}
+		if err != nil {
+			f.Close()
+			return 0, fmt.Errorf("%s is damaged at offset %d: %w", path, offset, err)
+		}
+		offset += frameHeaderSize + int64(len(payload))
+		entries++
+	}
+
+	s.logSize = offset
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return entries, nil
+}
+
+func (s *logStore) applyEntry(payload []byte) error {
+	var entry logEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return err
+	}
+	if entry.Seq <= s.seq {
+		// Already part of the snapshot.
+		return nil
+	}
+	ctx := context.Background()
+	for _, op := range entry.Ops {
+		var err error
+		switch {
+		case op.Op == "create" && op.Album != nil:
+			_, err = s.state.Create(ctx, *op.Album)
+		case op.Op == "update" && op.Album != nil:
+			_, err = s.state.Update(ctx, *op.Album)
+		case op.Op == "delete":
+			err = s.state.Delete(ctx, op.ID)
+		default:
+			err = fmt.Errorf("invalid operation %q", op.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("entry %d: %s: %w", entry.Seq, op.Op, err)
+		}
+	}
+	s.seq = entry.Seq
+	return nil
+}
+
+// `readFrame` reads the next frame, of which at most `remaining` bytes are left. It returns `io.EOF` at the
+// end, `io.ErrUnexpectedEOF` for a frame that is cut short, and the payload with `errChecksum` if it is damaged.
+func readFrame(r *bufio.Reader, remaining int64) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[0:4]))
+	if size > remaining-frameHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return payload, errChecksum
+	}
+	return payload, nil
+}
+
+func appendFrame(b []byte, payload []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(payload)))
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(payload, crcTable))
+	return append(b, payload...)
+}
+
+func (s *logStore) List(ctx context.Context) ([]album, error) { return s.state.List(ctx) }
+
+func (s *logStore) Get(ctx context.Context, id string) (album, error) { return s.state.Get(ctx, id) }
+
+func (s *logStore) Create(ctx context.Context, a album) (album, error) {
+	var created album
+	err := s.write(func(tx albumStore) (err error) {
+		created, err = tx.Create(ctx, a)
+		return err
+	})
+	return created, err
+}
+
+func (s *logStore) Update(ctx context.Context, a album) (album, error) {
+	var updated album
+	err := s.write(func(tx albumStore) (err error) {
+		updated, err = tx.Update(ctx, a)
+		return err
+	})
+	return updated, err
+}
+
+func (s *logStore) Delete(ctx context.Context, id string) error {
+	return s.write(func(tx albumStore) error { return tx.Delete(ctx, id) })
+}
+
+// The writes of a transaction go into a single log entry, so a crash can never leave half of them behind.
+func (s *logStore) Transaction(ctx context.Context, fn func(tx albumStore) error) error {
+	return s.write(fn)
+}
+
+// `write` runs `fn` against a copy of the albums, logs what it changed and then swaps the copy in.
+func (s *logStore) write(fn func(tx albumStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+
+	s.state.mu.RLock()
+	tx := &memoryStore{albums: slices.Clone(s.state.albums), nextID: s.state.nextID}
+	s.state.mu.RUnlock()
+
+	rec := &logRecorder{albumStore: tx}
+	if err := fn(rec); err != nil {
+		return err
+	}
+	if len(rec.ops) == 0 {
+		return nil
+	}
+	if err := s.append(rec.ops); err != nil {
+		return err
+	}
+
+	s.state.mu.Lock()
+	s.state.albums, s.state.nextID = tx.albums, tx.nextID
+	s.state.mu.Unlock()
+	return nil
+}
+
+// Must be called with `mu` held.
+func (s *logStore) append(ops []logOp) error {
+	payload, err := json.Marshal(logEntry{Seq: s.seq + 1, Ops: ops})
+	if err != nil {
+		return err
+	}
+	frame := appendFrame(nil, payload)
+	if _, err := s.log.Write(frame); err != nil {
+		// Cut off whatever made it to the file, so that later entries do not follow a damaged one.
+		if truncErr := s.log.Truncate(s.logSize); truncErr != nil {
+			s.err = fmt.Errorf("album log is damaged: %w", truncErr)
+		} else if _, seekErr := s.log.Seek(s.logSize, io.SeekStart); seekErr != nil {
+			s.err = fmt.Errorf("album log is damaged: %w", seekErr)
+		}
+		return err
+	}
+	s.logSize += int64(len(frame))
+	s.seq++
+
+	if s.opts.Fsync == fsyncAlways {
+		return s.syncLocked()
+	}
+	s.dirty = true
+	return nil
+}
+
+// `Sync` flushes the log to disk.
+func (s *logStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.dirty {
+		return nil
+	}
+	return s.syncLocked()
+}
+
+// Must be called with `mu` held.
+func (s *logStore) syncLocked() error {
+	if err := s.log.Sync(); err != nil {
+		// After a failed fsync, the kernel may have dropped the unwritten pages, and retrying would
+		// report success without writing them. Refuse further writes instead.
+		s.err = fmt.Errorf("album log could not be flushed: %w", err)
+		return s.err
+	}
+	s.dirty = false
+	return nil
+}
+
+// `Compact` writes a snapshot of all albums and starts a new, empty log.
+// Readers carry on as usual; writers wait until it is done.
+func (s *logStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.logSize == int64(len(logMagic)) {
+		return nil
+	}
+
+	s.state.mu.RLock()
+	snap := logSnapshot{Seq: s.seq, NextID: s.state.nextID, Albums: s.state.albums}
+	payload, err := json.Marshal(snap)
+	s.state.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := writeFileSynced(filepath.Join(s.dir, snapshotFileName), appendFrame([]byte(snapshotMagic), payload)); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	// From here on, the entries in the old log are all in the snapshot.
+	return s.resetLog()
+}
+
+// `resetLog` replaces the log with an empty one. Must be called with `mu` held.
+func (s *logStore) resetLog() error {
+	path := filepath.Join(s.dir, logFileName)
+	if err := writeFileSynced(path, []byte(logMagic)); err != nil {
+		return fmt.Errorf("start new log: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		// The writes so far are safe, but there is nowhere to append new ones.
+		s.err = fmt.Errorf("open new log: %w", err)
+		return s.err
+	}
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, s.logSize, s.dirty = f, int64(len(logMagic)), false
+	return nil
+}
+
+// `writeFileSynced` atomically replaces `path` with `data`, and makes sure both are on disk.
+func writeFileSynced(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// The rename itself is only durable once the directory is flushed.
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// `run` flushes and compacts the log in the background until `Close`.
+func (s *logStore) run() {
+	defer close(s.done)
+
+	var syncs, compactions <-chan time.Time
+	if s.opts.Fsync == fsyncInterval {
+		t := time.NewTicker(s.opts.FsyncInterval)
+		defer t.Stop()
+		syncs = t.C
+	}
+	if s.opts.CompactInterval > 0 {
+		t := time.NewTicker(s.opts.CompactInterval)
+		defer t.Stop()
+		compactions = t.C
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-syncs:
+			if err := s.Sync(); err != nil {
+				logger.Printf("store: %v", err)
+			}
+		case <-compactions:
+			if err := s.Compact(); err != nil {
+				logger.Printf("store: compaction: %v", err)
+			}
+		}
+	}
+}
+
+// `Close` stops the background work, flushes the log and closes it.
+func (s *logStore) Close() error {
+	close(s.stop)
+	<-s.done
+
+	err := s.Sync()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = errStoreClosed
+	}
+	return errors.Join(err, s.log.Close())
+}
+
+// `logRecorder` notes the writes made through it as log operations.
+type logRecorder struct {
+	albumStore
+	ops []logOp
+}
+
+func (r *logRecorder) Create(ctx context.Context, a album) (album, error) {
+	created, err := r.albumStore.Create(ctx, a)
+	if err == nil {
+		r.ops = append(r.ops, logOp{Op: "create", Album: &created})
+	}
+	return created, err
+}
+
+func (r *logRecorder) Update(ctx context.Context, a album) (album, error) {
+	updated, err := r.albumStore.Update(ctx, a)
+	if err == nil {
+		r.ops = append(r.ops, logOp{Op: "update", Album: &updated})
+	}
+	return updated, err
+}
+
+func (r *logRecorder) Delete(ctx context.Context, id string) error {
+	err := r.albumStore.Delete(ctx, id)
+	if err == nil {
+		r.ops = append(r.ops, logOp{Op: "delete", ID: id})
+	}
+	return err
+}
+
+// The writes of a nested transaction are kept only if it commits.
+func (r *logRecorder) Transaction(ctx context.Context, fn func(tx albumStore) error) error {
+	var inner *logRecorder
+	err := r.albumStore.Transaction(ctx, func(tx albumStore) error {
+		inner = &logRecorder{albumStore: tx}
+		return fn(inner)
+	})
+	if err == nil {
+		r.ops = append(r.ops, inner.ops...)
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+package records_api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestLogStore(t *testing.T, dir string) *logStore {
+	t.Helper()
+	s, err := openLogStore(dir, logStoreOptions{Fsync: fsyncAlways}, albums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func titles(t *testing.T, s albumStore) string {
+	t.Helper()
+	list, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, a := range list {
+		titles = append(titles, a.ID+"="+a.Title)
+	}
+	return strings.Join(titles, ",")
+}
+
+func TestLogStoreReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := openTestLogStore(t, dir)
+
+	created, _ := s.Create(ctx, album{Title: "Giant Steps", Artist: "John Coltrane", Price: 9.99})
+	s.Update(ctx, album{ID: "2", Title: "Jeru (Remastered)", Artist: "Gerry Mulligan", Price: 19.99})
+	s.Delete(ctx, "3")
+	// A failed transaction leaves nothing in the log.
+	s.Transaction(ctx, func(tx albumStore) error {
+		tx.Delete(ctx, "1")
+		return errors.New("abort")
+	})
+	want := "1=Blue Train,2=Jeru (Remastered),4=Giant Steps"
+	if got := titles(t, s); got != want || created.ID != "4" {
+		t.Fatalf("albums = %s; want %s", got, want)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The seed only applies to a new store.
+	s = openTestLogStore(t, dir)
+	defer s.Close()
+	if got := titles(t, s); got != want {
+		t.Errorf("albums after reopening = %s; want %s", got, want)
+	}
+	// Generated IDs are not reused.
+	s.Delete(ctx, "4")
+	if a, _ := s.Create(ctx, album{Title: "Ballads", Artist: "John Coltrane", Price: 9.99}); a.ID != "5" {
+		t.Errorf("generated ID = %s; want 5", a.ID)
+	}
+}
+
+func TestLogStoreTornWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, logFileName)
+	s := openTestLogStore(t, dir)
+	s.Create(ctx, album{Title: "Giant Steps", Artist: "John Coltrane", Price: 9.99})
+	before, _ := os.Stat(path)
+	s.Create(ctx, album{Title: "Ballads", Artist: "John Coltrane", Price: 9.99})
+	s.Close()
+
+	// Simulate a crash in the middle of writing the last entry.
+	after, _ := os.Stat(path)
+	for _, cut := range []int64{1, frameHeaderSize, after.Size() - before.Size() - 1} {
+		if err := os.Truncate(path, after.Size()-cut); err != nil {
+			t.Fatal(err)
+		}
+		s = openTestLogStore(t, dir)
+		if got := titles(t, s); !strings.HasSuffix(got, "4=Giant Steps") {
+			t.Errorf("cut %d bytes: albums = %s; want the last write dropped", cut, got)
+		}
+		s.Close()
+		if info, _ := os.Stat(path); info.Size() != before.Size() {
+			t.Errorf("cut %d bytes: log is %d bytes; want it truncated to %d", cut, info.Size(), before.Size())
+		}
+		// Put the entry back for the next round.
+		s = openTestLogStore(t, dir)
+		s.Create(ctx, album{Title: "Ballads", Artist: "John Coltrane", Price: 9.99})
+		s.Close()
+	}
+
+	// Writes after recovering land after the last good entry.
+	s = openTestLogStore(t, dir)
+	s.Create(ctx, album{Title: "Crescent", Artist: "John Coltrane", Price: 9.99})
+	s.Close()
+	s = openTestLogStore(t, dir)
+	defer s.Close()
+	if got := titles(t, s); !strings.HasSuffix(got, "5=Ballads,6=Crescent") {
+		t.Errorf("albums = %s", got)
+	}
+}
+
+func TestLogStoreDamage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, logFileName)
+	s := openTestLogStore(t, dir)
+	s.Create(ctx, album{Title: "Giant Steps", Artist: "John Coltrane", Price: 9.99})
+	s.Close()
+
+	data, _ := os.ReadFile(path)
+	// A flipped bit in the last entry is a torn write...
+	last := append([]byte(nil), data...)
+	last[len(last)-2] ^= 1
+	os.WriteFile(path, last, 0o644)
+	s = openTestLogStore(t, dir)
+	if got := titles(t, s); strings.Contains(got, "Giant Steps") {
+		t.Errorf("albums = %s; want the damaged entry dropped", got)
+	}
+	s.Close()
+
+	// ...but anywhere else it would lose the entries after it.
+	middle := append([]byte(nil), data...)
+	middle[len(logMagic)+frameHeaderSize+2] ^= 1
+	os.WriteFile(path, middle, 0o644)
+	if _, err := openLogStore(dir, logStoreOptions{Fsync: fsyncAlways}, albums); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Errorf("opening a damaged log = %v; want an error", err)
+	}
+}
+
+func TestLogStoreCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, logFileName)
+	s := openTestLogStore(t, dir)
+	s.Create(ctx, album{Title: "Giant Steps", Artist: "John Coltrane", Price: 9.99})
+	s.Delete(ctx, "1")
+	want := titles(t, s)
+	oldLog, _ := os.ReadFile(path)
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(logMagic)) {
+		t.Errorf("log after compaction is %d bytes; want it empty", info.Size())
+	}
+	s.Update(ctx, album{ID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Price: 9.99})
+	s.Close()
+
+	s = openTestLogStore(t, dir)
+	if got := titles(t, s); got != want {
+		t.Errorf("albums after compaction = %s; want %s", got, want)
+	}
+	if a, _ := s.Get(ctx, "2"); a.Price != 9.99 {
+		t.Errorf("update after compaction was lost: %+v", a)
+	}
+	s.Close()
+
+	// A crash after writing the snapshot but before starting the new log leaves the old log behind,
+	// whose entries are already in the snapshot.
+	os.WriteFile(path, oldLog, 0o644)
+	s = openTestLogStore(t, dir)
+	defer s.Close()
+	if got := titles(t, s); got != want {
+		t.Errorf("albums with the old log = %s; want %s", got, want)
+	}
+}
@@ -1,9 +1,18 @@
 package records_api
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 )
 
 var logger = log.Default()
@@ -11,11 +20,241 @@ var logger = log.Default()
 func Init() {}
 
 func Main() {
-	router := gin.Default()
+	// `go run . migrate ...` manages the database schema instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(os.Args[2:]); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
 
-	router.GET("/albums", getAlbums)
-	router.GET("/albums/:id", getAlbumByID)
-	router.POST("/albums", postAlbums)
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-	router.Run("localhost:8080")
+	if cfg.DB != "" {
+		db, err := openDatabase(cfg.DB)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer db.Close()
+		if err := checkMigrations(context.Background(), db, cfg.AutoMigrate); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	spans, closeSpans, err := openSpanSink(cfg.TraceFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer closeSpans()
+	cfg.spans = spans
+
+	notifier, closeNotifier, err := openNotifier(cfg.NotifyOutbox)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer closeNotifier()
+	cfg.notifier = notifier
+
+	var handler http.Handler
+	var closeStores func() error
+	if cfg.MultiTenant {
+		tenants, err := newTenantRouter(cfg)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		handler, closeStores = tenants, tenants.Close
+	} else {
+		seed, err := loadSeed(cfg.SeedFile, cfg.SeedStrict)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		store, closeStore, err := openAlbumStore(cfg, cfg.StoreDir, seed)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		handler, closeStores = newRouter(newServer(cfg, store)), closeStore
+	}
+	defer func() {
+		if err := closeStores(); err != nil {
+			logger.Println(err)
+		}
+	}()
+
+	srv := newHTTPServer(cfg, handler)
+	if srv.TLSConfig, err = tlsConfig(cfg); err != nil {
+		logger.Fatal(err)
+	}
+	if err := serveUntilSignal(srv, cfg.ShutdownTimeout); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// `openAlbumStore` opens the album store kept in `dir`, or an in-memory one if `dir` is empty.
+// Either starts out with `seed` if it is new. The returned function closes the store.
+func openAlbumStore(cfg config, dir string, seed []album) (albumStore, func() error, error) {
+	if dir == "" {
+		return newMemoryStore(seed), func() error { return nil }, nil
+	}
+	store, err := openLogStore(dir, cfg.logStoreOptions(), seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, store.Close, nil
+}
+
+// `serveUntilSignal` runs `srv` until SIGINT or SIGTERM, then stops accepting connections and
+// gives the requests in flight up to `shutdownTimeout` to finish.
+func serveUntilSignal(srv *http.Server, shutdownTimeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			logger.Printf("listening on https://%s", srv.Addr)
+			// The certificates are already in `srv.TLSConfig`.
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		logger.Printf("listening on http://%s", srv.Addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	logger.Println("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// `NewHandler` builds the records API from command-line style `args` (see `loadConfig`) without
+// starting a server, e.g. to mount it in another server or to run it under `httptest`.
+// Albums are kept in memory, starting from the `-seed` catalogue, or empty for each tenant with `-multi-tenant`.
+func NewHandler(args []string) (http.Handler, error) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StoreDir != "" {
+		// A handler has no way to close the store, and so to flush it.
+		return nil, errors.New("store-dir is not supported by NewHandler")
+	}
+	// The trace file is never closed; every span is written to it as soon as it ends.
+	if cfg.spans, _, err = openSpanSink(cfg.TraceFile); err != nil {
+		return nil, err
+	}
+	// So is the notification outbox.
+	if cfg.notifier, _, err = openNotifier(cfg.NotifyOutbox); err != nil {
+		return nil, err
+	}
+	if cfg.MultiTenant {
+		tenants, err := newTenantRouter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return tenants, nil
+	}
+	seed, err := loadSeed(cfg.SeedFile, cfg.SeedStrict)
+	if err != nil {
+		return nil, err
+	}
+	return newRouter(newServer(cfg, newMemoryStore(seed))), nil
+}
+
+// `server` holds everything the handlers need to serve requests.
+type server struct {
+	cfg config
+	// The tenant the server belongs to with `-multi-tenant`; empty otherwise.
+	tenant      string
+	albums      *cachedStore
+	idempotency *idempotencyStore
+	covers      *coverStore
+	reviews     *reviewStore
+	promotions  *promotionStore
+	orders      *orderStore
+	wishlists   *wishlistStore
+	similar     *similarCache
+	diagnostics *diagnostics
+	// Reviews are validated against the configured profanity list, see `newReviewValidator`.
+	reviewValidator *validator.Validate
+	wishlistWatcher *wishlistWatcher
+	// Shared by everything that works with the catalogue, and held alone by a restore; see `restore`.
+	catalogue sync.RWMutex
+}
+
+// `newServer` wires the handlers to `store`, with the album cache in front of it.
+// Calls that get past the cache to `store` are traced; see `tracedStore`.
+func newServer(cfg config, store albumStore) *server {
+	s := &server{
+		cfg:    cfg,
+		albums: newCachedStore(tracedStore{store}, cfg.CacheSize, cfg.CacheTTL),
+		// Clients may retry non-idempotent requests with an `Idempotency-Key` header without creating duplicates.
+		idempotency: newIdempotencyStore(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys),
+		covers:      newCoverStore(cfg.CoverDir),
+		reviews:     newReviewStore(),
+		promotions:  newPromotionStore(),
+		orders:      newOrderStore(),
+		wishlists:   newWishlistStore(),
+		similar:     &similarCache{},
+		diagnostics: newDiagnostics(cfg.Debug),
+
+		reviewValidator: newReviewValidator(cfg.profanity),
+	}
+	s.wishlistWatcher = newWishlistWatcher(s, cfg.WishlistCheckInterval, cfg.notifier)
+	return s
+}
+
+// `newRouter` registers all the endpoints of the records API.
+func newRouter(s *server) *gin.Engine {
+	router := newEngine(s.cfg)
+	// Everything but a backup, a restore and the diagnostics works with the catalogue; see `shareCatalogue`.
+	catalogue := router.Group("/", s.shareCatalogue)
+
+	// Every API version gets its own route group, and unversioned paths are served by the default version.
+	for name, version := range apiVersions {
+		s.registerAlbumRoutes(catalogue.Group("/"+name, withAPIVersion(s.cfg.scheduled(version))))
+	}
+	s.registerAlbumRoutes(catalogue.Group("/", withAPIVersion(s.cfg.scheduled(apiVersions[s.cfg.DefaultAPIVersion]))))
+
+	catalogue.GET("/stats/cache", requireAdmin(s.cfg), s.getCacheStats)
+	catalogue.POST("/orders", requireAdmin(s.cfg), idempotent(s.idempotency), s.postOrder)
+	// Order IDs are sequential, so they would give away every sale.
+	catalogue.GET("/orders/:id", requireAdmin(s.cfg), s.getOrder)
+	catalogue.GET("/reports/sales", requireAdmin(s.cfg), s.getSalesReport)
+	// Customers are not authenticated here, so wishlists are only for a trusted backend that is.
+	customers := catalogue.Group("/customers", requireAdmin(s.cfg))
+	customers.GET("/:customer/wishlist", s.getWishlist)
+	customers.PUT("/:customer/wishlist/:albumID", s.putWishlistItem)
+	customers.DELETE("/:customer/wishlist/:albumID", s.deleteWishlistItem)
+	s.registerAdminRoutes(router, catalogue)
+	registerDebugRoutes(router, s.cfg, s.diagnostics)
+
+	return router
+}
+
+func (s *server) registerAlbumRoutes(group *gin.RouterGroup) {
+	group.GET("/albums", s.getAlbums)
+	group.GET("/albums/:id", s.getAlbumByID)
+	group.POST("/albums", idempotent(s.idempotency), s.postAlbums)
+	// `POST /albums:batch`; see `postAlbumsAction`.
+	group.POST("/albums:action", idempotent(s.idempotency), s.postAlbumsAction)
+	group.PUT("/albums/:id", s.putAlbum)
+	group.DELETE("/albums/:id", s.deleteAlbum)
+	group.GET("/albums/:id/cover", s.getAlbumCover)
+	group.PUT("/albums/:id/cover", limitBody(s.cfg.CoverMaxBytes), s.putAlbumCover)
+	group.GET("/albums/:id/tracks", s.getAlbumTracks)
+	group.PUT("/albums/:id/tracks", s.putAlbumTracks)
+	group.GET("/albums/:id/similar", s.getSimilarAlbums)
+	group.GET("/albums/:id/reviews", s.getReviews)
+	group.POST("/albums/:id/reviews", s.postReview)
+	group.DELETE("/albums/:id/reviews/:reviewID", s.deleteReview)
 }
@@ -0,0 +1,402 @@
+package records_api
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The schema of the album database. Every change is a pair of files `NNNN_name.up.sql` and
+// `NNNN_name.down.sql`, applied in order of their version number `NNNN`.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var errMigrationLocked = errors.New("another instance is migrating the database")
+
+// `migration` is one versioned schema change.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// `migrationStatus` is a migration together with when, if ever, it was applied.
+type migrationStatus struct {
+	migration
+	AppliedAt *time.Time
+}
+
+// `loadMigrations` reads all migrations from `fsys`, sorted by version.
+// Every version must have exactly one name and both an up and a down script.
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %q: name must look like 0001_name.up.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// `migrator` applies and rolls back migrations on a database.
+// The applied versions are recorded in `schema_migrations`. A single row in `schema_lock`
+// makes sure only one instance migrates at a time; the others wait for it, then find nothing left to do.
+type migrator struct {
+	db         *sql.DB
+	migrations []migration
+	owner      string
+
+	// How long to wait for another instance to release the lock.
+	LockTimeout time.Duration
+	// A lock older than this is assumed to belong to a crashed instance and is broken.
+	StaleLockAfter time.Duration
+}
+
+func newMigrator(db *sql.DB, migrations []migration) *migrator {
+	host, _ := os.Hostname()
+	return &migrator{
+		db:             db,
+		migrations:     migrations,
+		owner:          fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+		LockTimeout:    time.Minute,
+		StaleLockAfter: 15 * time.Minute,
+	}
+}
+
+// `openDatabase` opens the SQLite database at `path`, creating it if needed.
+func openDatabase(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+}
+
+func (m *migrator) init(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS schema_lock (
+			id          INTEGER PRIMARY KEY CHECK (id = 1),
+			owner       TEXT NOT NULL,
+			acquired_at INTEGER NOT NULL
+		);`)
+	return err
+}
+
+// `Status` lists every known migration and whether it has been applied.
+func (m *migrator) Status(ctx context.Context) ([]migrationStatus, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]migrationStatus, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i].migration = mig
+		if at, ok := applied[mig.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// `Pending` returns the migrations that have not been applied yet.
+func (m *migrator) Pending(ctx context.Context) ([]migration, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.migration)
+		}
+	}
+	return pending, nil
+}
+
+// `Up` applies all pending migrations in order and returns them.
+func (m *migrator) Up(ctx context.Context) ([]migration, error) {
+	var done []migration
+	err := m.withLock(ctx, func() error {
+		// Read the applied versions only now that we hold the lock; another instance may just have migrated.
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, mig.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+					mig.Version, mig.Name, time.Now().UTC().Format(time.RFC3339Nano))
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// `Down` rolls back the `n` most recently applied migrations and returns them.
+func (m *migrator) Down(ctx context.Context, n int) ([]migration, error) {
+	var done []migration
+	err := m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < n; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if err := m.apply(ctx, mig.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("rolling back migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// `apply` runs `script` and `record` in one transaction, so a failed migration leaves no trace.
+func (m *migrator) apply(ctx context.Context, script string, record func(*sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version], _ = time.Parse(time.RFC3339Nano, at)
+	}
+	return applied, rows.Err()
+}
+
+// `withLock` runs `fn` while holding the migration lock.
+func (m *migrator) withLock(ctx context.Context, fn func() error) error {
+	if err := m.init(ctx); err != nil {
+		return err
+	}
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+	defer m.db.ExecContext(context.Background(), `DELETE FROM schema_lock WHERE id = 1 AND owner = ?`, m.owner)
+
+	return fn()
+}
+
+func (m *migrator) lock(ctx context.Context) error {
+	deadline := time.Now().Add(m.LockTimeout)
+	for {
+		now := time.Now()
+		// Break the lock of an instance that died while migrating.
+		if _, err := m.db.ExecContext(ctx, `DELETE FROM schema_lock WHERE id = 1 AND acquired_at < ?`,
+			now.Add(-m.StaleLockAfter).UnixNano()); err != nil {
+			return err
+		}
+
+		res, err := m.db.ExecContext(ctx, `INSERT INTO schema_lock (id, owner, acquired_at) VALUES (1, ?, ?) ON CONFLICT (id) DO NOTHING`,
+			m.owner, now.UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// `checkMigrations` is run at startup. With `autoMigrate` pending migrations are applied,
+// otherwise their presence is an error: serving requests against an outdated schema is not safe.
+func checkMigrations(ctx context.Context, db *sql.DB, autoMigrate bool) error {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+	m := newMigrator(db, migrations)
+
+	if autoMigrate {
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			logger.Printf("applied migration %04d_%s", mig.Version, mig.Name)
+		}
+		return err
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database has %d pending migration(s), starting with %04d_%s; run `migrate up` or start with -auto-migrate",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// `migrateCommand` implements the `migrate` subcommand:
+//
+//	go run . migrate [-db path] up
+//	go run . migrate [-db path] down N
+//	go run . migrate [-db path] status
+func migrateCommand(args []string) error {
+	cmd := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	db := cmd.String("db", defaultConfig().DB, "path of the SQLite database")
+	lockTimeout := cmd.Duration("lock-timeout", time.Minute, "how long to wait for another instance that is migrating")
+	if err := applyEnv(cmd); err != nil {
+		return err
+	}
+	if err := cmd.Parse(args); err != nil {
+		return err
+	}
+	if *db == "" {
+		return errors.New("no database given; use -db or RECORDS_DB")
+	}
+
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		return err
+	}
+	conn, err := openDatabase(*db)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m := newMigrator(conn, migrations)
+	m.LockTimeout = *lockTimeout
+	ctx := context.Background()
+
+	switch cmd.Arg(0) {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return err
+	case "down":
+		n, err := strconv.Atoi(cmd.Arg(1))
+		if err != nil || n < 1 {
+			return errors.New("usage: migrate down N, where N is the number of migrations to roll back")
+		}
+		rolledBack, err := m.Down(ctx, n)
+		for _, mig := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", mig.Version, mig.Name)
+		}
+		return err
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return errors.New("expected 'up', 'down N' or 'status'")
+	}
+}
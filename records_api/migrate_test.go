@@ -0,0 +1,140 @@
+package records_api
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func openTestDatabase(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "records.db")
+	db, err := openDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func embeddedMigrations(t *testing.T) []migration {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrations
+}
+
+func TestLoadMigrationsValidatesFiles(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"bad name":     {"m/create.up.sql": {}},
+		"missing down": {"m/0001_a.up.sql": {Data: []byte("SELECT 1")}},
+		"two names": {
+			"m/0001_a.up.sql": {Data: []byte("SELECT 1")}, "m/0001_a.down.sql": {Data: []byte("SELECT 1")},
+			"m/0001_b.up.sql": {Data: []byte("SELECT 1")}, "m/0001_b.down.sql": {Data: []byte("SELECT 1")},
+		},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadMigrations(fsys, "m"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	migrations := embeddedMigrations(t)
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("migrations out of order: %d after %d", migrations[i].Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestMigratorUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	db, _ := openTestDatabase(t)
+	migrations := embeddedMigrations(t)
+	m := newMigrator(db, migrations)
+
+	if pending, _ := m.Pending(ctx); len(pending) != len(migrations) {
+		t.Fatalf("pending = %d; want %d", len(pending), len(migrations))
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != len(migrations) {
+		t.Fatalf("Up = %d, %v", len(applied), err)
+	}
+	if _, err := db.Exec(`INSERT INTO albums (id, title, artist, price, created_at) VALUES ('1', 'Jeru', 'Gerry Mulligan', 17.99, '')`); err != nil {
+		t.Fatalf("albums table not usable: %v", err)
+	}
+	if applied, _ := m.Up(ctx); len(applied) != 0 {
+		t.Errorf("second Up applied %d migrations", len(applied))
+	}
+
+	rolledBack, err := m.Down(ctx, 1)
+	if err != nil || len(rolledBack) != 1 || rolledBack[0].Version != migrations[len(migrations)-1].Version {
+		t.Fatalf("Down(1) = %v, %v", rolledBack, err)
+	}
+	status, _ := m.Status(ctx)
+	if status[len(status)-1].AppliedAt != nil || status[0].AppliedAt == nil {
+		t.Errorf("after Down(1) only the last migration should be pending")
+	}
+
+	if _, err := m.Down(ctx, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`SELECT 1 FROM albums`); err == nil {
+		t.Error("albums table should be gone after rolling everything back")
+	}
+}
+
+func TestMigratorConcurrentInstancesMigrateOnce(t *testing.T) {
+	ctx := context.Background()
+	_, path := openTestDatabase(t)
+	migrations := embeddedMigrations(t)
+
+	var wg sync.WaitGroup
+	applied := make([]int, 4)
+	for i := range applied {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every instance has its own connection pool, as separate processes would.
+			db, err := openDatabase(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer db.Close()
+			done, err := newMigrator(db, migrations).Up(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			applied[i] = len(done)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, n := range applied {
+		total += n
+	}
+	if total != len(migrations) {
+		t.Errorf("instances applied %v migrations in total; want each applied once (%d)", applied, len(migrations))
+	}
+}
+
+func TestCheckMigrationsRefusesPending(t *testing.T) {
+	ctx := context.Background()
+	db, _ := openTestDatabase(t)
+
+	if err := checkMigrations(ctx, db, false); err == nil {
+		t.Error("expected an error for a database with pending migrations")
+	}
+	if err := checkMigrations(ctx, db, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkMigrations(ctx, db, false); err != nil {
+		t.Errorf("after auto-migrate: %v", err)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX albums_artist;
+DROP TABLE albums;
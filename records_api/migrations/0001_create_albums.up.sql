@@ -0,0 +1,9 @@
+CREATE TABLE albums (
+    id         TEXT PRIMARY KEY,
+    title      TEXT NOT NULL,
+    artist     TEXT NOT NULL,
+    price      REAL NOT NULL CHECK (price > 0),
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX albums_artist ON albums (artist);
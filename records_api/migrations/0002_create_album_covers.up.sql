@@ -0,0 +1,9 @@
+CREATE TABLE album_covers (
+    album_id     TEXT PRIMARY KEY REFERENCES albums (id) ON DELETE CASCADE,
+    sha256       TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size         INTEGER NOT NULL,
+    width        INTEGER NOT NULL,
+    height       INTEGER NOT NULL,
+    uploaded_at  TEXT NOT NULL
+);
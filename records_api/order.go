@@ -0,0 +1,178 @@
+package records_api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `order` is a recorded sale of one or more albums. Orders are never changed once placed.
+type order struct {
+	ID       string      `json:"id"`
+	Items    []orderItem `json:"items"`
+	Total    float64     `json:"total"`
+	PlacedAt time.Time   `json:"placedAt"`
+}
+
+// `orderItem` records what an album sold for, with its title and artist at the time of the sale.
+type orderItem struct {
+	AlbumID  string `json:"albumId"`
+	Title    string `json:"title"`
+	Artist   string `json:"artist"`
+	Quantity int    `json:"quantity"`
+	// The price of one copy after promotions, see `albumPricing`.
+	UnitPrice float64 `json:"unitPrice"`
+	// What the copies cost together, which is less than `Quantity * UnitPrice` when a multi-buy offer applies.
+	Total      float64  `json:"total"`
+	Promotions []string `json:"promotions,omitempty"`
+}
+
+// `orderRequest` is the body of `POST /orders`.
+type orderRequest struct {
+	Items []struct {
+		AlbumID  string `json:"albumId" binding:"required"`
+		Quantity int    `json:"quantity" binding:"required,min=1,max=100"`
+	} `json:"items" binding:"required,min=1,max=100,dive"`
+}
+
+// `orderStore` keeps the orders in memory, in the order they were placed.
+type orderStore struct {
+	mu     sync.RWMutex
+	orders []order
+	byID   map[string]int
+	nextID int
+}
+
+func newOrderStore() *orderStore {
+	return &orderStore{byID: map[string]int{}, nextID: 1}
+}
+
+func (s *orderStore) Add(o order) order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o.ID = strconv.Itoa(s.nextID)
+	s.nextID++
+	s.byID[o.ID] = len(s.orders)
+	s.orders = append(s.orders, o)
+	return o
+}
+
+func (s *orderStore) Get(id string) (order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.byID[id]
+	if !ok {
+		return order{}, false
+	}
+	return s.orders[i], true
+}
+
+// `Snapshot` returns the orders placed so far without copying them. Orders are only ever appended,
+// so the snapshot stays valid while new orders come in, and reading it holds up nobody.
+func (s *orderStore) Snapshot() []order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.orders[:len(s.orders):len(s.orders)]
+}
+
+// `ReplaceAll` swaps all orders for `orders`, which are in the order they were placed. New orders
+// get IDs above theirs. Snapshots taken before keep the old orders.
+func (s *orderStore) ReplaceAll(orders []order) {
+	byID, nextID := make(map[string]int, len(orders)), 1
+	for i, o := range orders {
+		byID[o.ID] = i
+		if n, err := strconv.Atoi(o.ID); err == nil && n >= nextID {
+			nextID = n + 1
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders, s.byID, s.nextID = slices.Clip(orders), byID, max(s.nextID, nextID)
+}
+
+// `postOrder` records a sale of the albums in the request body, priced with the promotions running now.
+func (s *server) postOrder(c *gin.Context) {
+	var req orderRequest
+	if err := tracedBind(c.Request.Context(), c.ShouldBindJSON)(&req); err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return
+	}
+
+	now := time.Now()
+	running := s.promotions.Running(now)
+	o := order{Items: make([]orderItem, len(req.Items)), PlacedAt: now.UTC()}
+	seen := map[string]bool{}
+	for i, item := range req.Items {
+		field := fmt.Sprintf("items[%d].albumId", i)
+		if seen[item.AlbumID] {
+			writeProblem(c, problemValidation, "each album can only be listed once", fieldProblem{Field: field, Detail: "album " + item.AlbumID + " is listed twice"})
+			return
+		}
+		seen[item.AlbumID] = true
+
+		a, err := s.albums.Get(c.Request.Context(), item.AlbumID)
+		if err != nil {
+			if errors.Is(err, errAlbumNotFound) {
+				detail := "album " + item.AlbumID + " not found"
+				writeProblem(c, problemUnprocessable, detail, fieldProblem{Field: field, Detail: detail})
+				return
+			}
+			s.albumLookupFailed(c, err)
+			return
+		}
+		o.Items[i] = priceOrderItem(a, priceAlbum(a, running), item.Quantity)
+		o.Total += o.Items[i].Total
+	}
+	o.Total = roundCents(o.Total)
+
+	o = s.orders.Add(o)
+	c.Header("Location", "/orders/"+o.ID)
+	c.IndentedJSON(http.StatusCreated, o)
+}
+
+// `priceOrderItem` works out what `quantity` copies of `a` cost with `pricing`, which may be nil.
+// When several multi-buy offers apply, the one that makes the copies cheapest is used.
+func priceOrderItem(a album, pricing *albumPricing, quantity int) orderItem {
+	item := orderItem{AlbumID: a.ID, Title: a.Title, Artist: a.Artist, Quantity: quantity, UnitPrice: a.Price}
+	if pricing == nil {
+		item.Total = roundCents(a.Price * float64(quantity))
+		return item
+	}
+	item.UnitPrice = pricing.EffectivePrice
+	for _, p := range pricing.Promotions {
+		item.Promotions = append(item.Promotions, p.ID)
+	}
+	item.Total = roundCents(item.UnitPrice * float64(quantity))
+	var best *multiBuyOffer
+	for i, offer := range pricing.Offers {
+		// Of every `Buy + Get` copies, only `Buy` are paid for, and so are at most `Buy` of the rest.
+		group := offer.Buy + offer.Get
+		paid := quantity/group*offer.Buy + min(quantity%group, offer.Buy)
+		if total := roundCents(item.UnitPrice * float64(paid)); total < item.Total {
+			item.Total, best = total, &pricing.Offers[i]
+		}
+	}
+	if best != nil {
+		item.Promotions = append(item.Promotions, best.ID)
+	}
+	return item
+}
+
+func (s *server) getOrder(c *gin.Context) {
+	o, ok := s.orders.Get(c.Param("id"))
+	if !ok {
+		writeProblem(c, problemNotFound, "order not found")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, o)
+}
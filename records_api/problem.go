@@ -0,0 +1,159 @@
+package records_api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// Errors are sent as RFC 7807 problem details, with the media type `application/problem+json`.
+const problemContentType = "application/problem+json"
+
+// `problemType` is a kind of error. Its URI, `/problems/<slug>`, identifies it to clients, and every
+// problem of a type has the same title and status; the detail tells what went wrong this time.
+type problemType struct {
+	slug   string
+	title  string
+	status int
+}
+
+func (t problemType) uri() string { return "/problems/" + t.slug }
+
+var (
+	problemBadRequest           = problemType{"bad-request", "Bad request", http.StatusBadRequest}
+	problemInvalidJSON          = problemType{"invalid-json", "Request body is not valid JSON", http.StatusBadRequest}
+	problemValidation           = problemType{"validation", "Request failed validation", http.StatusBadRequest}
+	problemUnauthorized         = problemType{"unauthorized", "Authentication required", http.StatusUnauthorized}
+	problemForbidden            = problemType{"forbidden", "Forbidden", http.StatusForbidden}
+	problemNotFound             = problemType{"not-found", "Not found", http.StatusNotFound}
+	problemConflict             = problemType{"conflict", "Conflict", http.StatusConflict}
+	problemDuplicate            = problemType{"duplicate", "Possible duplicate", http.StatusConflict}
+	problemBodyTooLarge         = problemType{"body-too-large", "Request body too large", http.StatusRequestEntityTooLarge}
+	problemUnsupportedMediaType = problemType{"unsupported-media-type", "Unsupported media type", http.StatusUnsupportedMediaType}
+	problemUnprocessable        = problemType{"unprocessable", "Request cannot be processed", http.StatusUnprocessableEntity}
+	problemRateLimited          = problemType{"rate-limited", "Too many requests", http.StatusTooManyRequests}
+	problemInternal             = problemType{"internal", "Internal server error", http.StatusInternalServerError}
+)
+
+// `problem` is an error response body.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Extension members: what is wrong with each field of a `validation` problem, the existing albums
+	// a `duplicate` looks like, and the trace ID of the request, which its log lines and spans carry too;
+	// see `traceRequests`.
+	Errors     []fieldProblem       `json:"errors,omitempty"`
+	Candidates []duplicateCandidate `json:"candidates,omitempty"`
+	RequestID  string               `json:"requestId,omitempty"`
+}
+
+// `fieldProblem` is a reason a field failed validation, e.g. `{"field": "rating", "detail": "rating must be at most 5"}`.
+type fieldProblem struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// A `fieldProblem` is also an error, for validation done in code rather than by a validator.
+func (f fieldProblem) Error() string { return f.Detail }
+
+func newProblem(kind problemType, r *http.Request, detail string) problem {
+	return problem{Type: kind.uri(), Title: kind.title, Status: kind.status, Detail: detail, Instance: r.URL.Path}
+}
+
+// `writeProblem` is how handlers fail a request: it responds with a problem of `kind` and stops the handler chain.
+// `fields` are the field errors of a `problemValidation`.
+func writeProblem(c *gin.Context, kind problemType, detail string, fields ...fieldProblem) {
+	p := newProblem(kind, c.Request, detail)
+	p.Errors = fields
+	sendProblem(c, p)
+}
+
+// `sendProblem` is `writeProblem` for problems with other extension members.
+func sendProblem(c *gin.Context, p problem) {
+	p.RequestID = c.GetString(traceIDKey)
+	c.Abort()
+	// `IndentedJSON` keeps a content type that is already set.
+	c.Header("Content-Type", problemContentType)
+	c.IndentedJSON(p.Status, p)
+}
+
+// `write` sends the problem without gin, for responses sent before a request reaches a gin engine.
+func (p problem) write(w http.ResponseWriter) {
+	body, _ := json.MarshalIndent(p, "", "    ")
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+// `recoverProblem` turns panics in handlers into `problemInternal` responses. Gin logs the panic with its stack.
+func recoverProblem() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		writeProblem(c, problemInternal, "")
+	})
+}
+
+// `routeNotFound` answers requests for paths no route matches.
+func routeNotFound(c *gin.Context) {
+	writeProblem(c, problemNotFound, fmt.Sprintf("no endpoint at %s", c.Request.URL.Path))
+}
+
+// `bindFailed` answers a request whose body could not be bound by `c.ShouldBindJSON` or similar:
+// larger than `maxBytes`, not valid JSON, or invalid according to the binding's validation rules.
+func bindFailed(c *gin.Context, err error, maxBytes int64) {
+	if isBodyTooLarge(err) {
+		writeProblem(c, problemBodyTooLarge, fmt.Sprintf("request body must be at most %d bytes", maxBytes))
+		return
+	}
+	if fields := validationProblems(err, describeFieldError); fields != nil {
+		writeProblem(c, problemValidation, joinFieldProblems(fields), fields...)
+		return
+	}
+	var field fieldProblem
+	if errors.As(err, &field) {
+		writeProblem(c, problemValidation, field.Detail, field)
+		return
+	}
+	writeProblem(c, problemInvalidJSON, err.Error())
+}
+
+// `validationFailed` answers a request that failed validation with one of the validators' errors,
+// described by `describe`.
+func validationFailed(c *gin.Context, err error, describe func(validator.FieldError) fieldProblem) {
+	fields := validationProblems(err, describe)
+	if fields == nil {
+		writeProblem(c, problemValidation, err.Error())
+		return
+	}
+	writeProblem(c, problemValidation, joinFieldProblems(fields), fields...)
+}
+
+// `validationProblems` describes every field error in `err`, or returns nil if `err` is not from a validator.
+func validationProblems(err error, describe func(validator.FieldError) fieldProblem) []fieldProblem {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return nil
+	}
+	fields := make([]fieldProblem, len(fieldErrors))
+	for i, fe := range fieldErrors {
+		fields[i] = describe(fe)
+	}
+	return fields
+}
+
+func joinFieldProblems(fields []fieldProblem) string {
+	details := make([]string, len(fields))
+	for i, f := range fields {
+		details[i] = f.Detail
+	}
+	return strings.Join(details, "; ")
+}
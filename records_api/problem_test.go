@@ -0,0 +1,74 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `decodeProblem` checks that a response is a problem of `kind` and returns it.
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder, kind problemType) problem {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+		t.Errorf("Content-Type = %q; want %s", ct, problemContentType)
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body %s: %v", w.Body, err)
+	}
+	if w.Code != kind.status || p.Status != kind.status || p.Type != kind.uri() || p.Title != kind.title {
+		t.Errorf("response = %d %+v; want a %s problem", w.Code, p, kind.slug)
+	}
+	return p
+}
+
+func TestProblemResponses(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	w := serve(router, http.MethodGet, "/albums/999", "")
+	p := decodeProblem(t, w, problemNotFound)
+	if p.Detail != "album not found" || p.Instance != "/albums/999" {
+		t.Errorf("problem = %+v", p)
+	}
+	if traceID := strings.Split(w.Header().Get(traceresponseHeader), "-")[1]; p.RequestID != traceID {
+		t.Errorf("requestId = %q; want the trace ID %q", p.RequestID, traceID)
+	}
+
+	p = decodeProblem(t, serve(router, http.MethodPost, "/albums", `{"title": "", "artist": "Coltrane", "price": -1}`), problemValidation)
+	fields := map[string]string{}
+	for _, f := range p.Errors {
+		fields[f.Field] = f.Detail
+	}
+	if fields["title"] != "title is required" || fields["price"] == "" || len(fields) != 2 {
+		t.Errorf("validation errors = %+v", p.Errors)
+	}
+
+	decodeProblem(t, serve(router, http.MethodPost, "/albums", `{"title": `), problemInvalidJSON)
+	decodeProblem(t, serve(router, http.MethodPut, "/albums/1/tracks", `{"tracks": [{"side": "A", "position": 1, "title": "x", "duration": "soon"}]}`), problemValidation)
+	decodeProblem(t, serve(router, http.MethodGet, "/no/such/path", ""), problemNotFound)
+
+	p = decodeProblem(t, serve(router, http.MethodPost, "/v2/albums", `{"title": "Giant", "artist": {"name": "Coltrane"}, "price": {"amount": "0", "currency": "USD"}}`), problemValidation)
+	if len(p.Errors) != 1 || p.Errors[0].Field != "price.amount" {
+		t.Errorf("v2 price errors = %+v", p.Errors)
+	}
+}
+
+func TestPanicsBecomeProblems(t *testing.T) {
+	router := newEngine(defaultConfig())
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	p := decodeProblem(t, serve(router, http.MethodGet, "/panic", ""), problemInternal)
+	if strings.Contains(p.Detail, "boom") {
+		t.Errorf("detail %q leaks the panic", p.Detail)
+	}
+}
+
+func TestTenantProblems(t *testing.T) {
+	router := newTenantTestRouter(t, defaultConfig())
+	decodeProblem(t, serveTenant(router, "shop-z", http.MethodGet, "/albums", ""), problemNotFound)
+	decodeProblem(t, serveTenant(router, "", http.MethodGet, "/albums", ""), problemBadRequest)
+}
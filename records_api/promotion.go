@@ -0,0 +1,353 @@
+package records_api
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	discountPercent = "percent"
+	discountAmount  = "amount"
+	discountBuyGet  = "buy-get"
+)
+
+// `promotion` is a sale that lowers the price of the albums it targets while it runs,
+// e.g. 20% off an artist for a weekend, or buy 2 get 1 free.
+type promotion struct {
+	ID   string `json:"id"`
+	Name string `json:"name" binding:"required,max=100"`
+	// The promotion runs from `Starts` up to, but not including, `Ends`.
+	Starts   time.Time         `json:"starts" binding:"required"`
+	Ends     time.Time         `json:"ends" binding:"required,gtfield=Starts"`
+	Target   promotionTarget   `json:"target"`
+	Discount promotionDiscount `json:"discount"`
+	// Promotions that target the same album are applied from the highest priority down; see `albumPricing`.
+	Priority int `json:"priority" binding:"min=-1000,max=1000"`
+	// A stackable promotion can be combined with other stackable ones. One that isn't applies only on its own.
+	Stackable bool `json:"stackable"`
+}
+
+// `promotionTarget` selects the albums a promotion applies to. An album must match every criterion
+// that is set, and an empty target selects every album.
+type promotionTarget struct {
+	AlbumIDs []string `json:"albumIds,omitempty" binding:"max=1000,dive,required"`
+	// Compared case-insensitively.
+	Artist   string  `json:"artist,omitempty" binding:"max=100"`
+	MinPrice float64 `json:"minPrice,omitempty" binding:"min=0"`
+	MaxPrice float64 `json:"maxPrice,omitempty" binding:"min=0"`
+}
+
+// `promotionDiscount` is what a promotion takes off: `{"type": "percent", "percent": 20}`,
+// `{"type": "amount", "amount": 5}`, or `{"type": "buy-get", "buy": 2, "get": 1}` for one free copy with every two.
+type promotionDiscount struct {
+	Type    string  `json:"type" binding:"required,oneof=percent amount buy-get"`
+	Percent float64 `json:"percent,omitempty"`
+	Amount  float64 `json:"amount,omitempty"`
+	Buy     int     `json:"buy,omitempty"`
+	Get     int     `json:"get,omitempty"`
+}
+
+// `validate` checks what the binding tags can't: the discount fields that go with the type, and the price range.
+func (p promotion) validate() error {
+	d := p.Discount
+	switch {
+	case d.Type == discountPercent && (d.Percent <= 0 || d.Percent > 100 || d.Amount != 0 || d.Buy != 0 || d.Get != 0):
+		return fieldProblem{Field: "discount", Detail: "a percent discount needs a percent above 0 and at most 100, and nothing else"}
+	case d.Type == discountAmount && (d.Amount <= 0 || d.Percent != 0 || d.Buy != 0 || d.Get != 0):
+		return fieldProblem{Field: "discount", Detail: "an amount discount needs a positive amount, and nothing else"}
+	case d.Type == discountBuyGet && (d.Buy < 1 || d.Buy > 100 || d.Get < 1 || d.Get > 100 || d.Percent != 0 || d.Amount != 0):
+		return fieldProblem{Field: "discount", Detail: "a buy-get discount needs buy and get between 1 and 100, and nothing else"}
+	}
+	if t := p.Target; t.MaxPrice != 0 && t.MaxPrice < t.MinPrice {
+		return fieldProblem{Field: "target.maxPrice", Detail: "target.maxPrice must be at least target.minPrice"}
+	}
+	return nil
+}
+
+// `Active` reports whether the promotion runs at `now`.
+func (p promotion) Active(now time.Time) bool {
+	return !now.Before(p.Starts) && now.Before(p.Ends)
+}
+
+// `Targets` reports whether the promotion applies to `a`.
+func (p promotion) Targets(a album) bool {
+	t := p.Target
+	return (len(t.AlbumIDs) == 0 || slices.Contains(t.AlbumIDs, a.ID)) &&
+		(t.Artist == "" || strings.EqualFold(t.Artist, a.Artist)) &&
+		a.Price >= t.MinPrice &&
+		(t.MaxPrice == 0 || a.Price <= t.MaxPrice)
+}
+
+// `albumPricing` is included in album responses while promotions apply to the album.
+type albumPricing struct {
+	// The price of one copy after the applied promotions.
+	EffectivePrice float64            `json:"effectivePrice"`
+	Promotions     []appliedPromotion `json:"promotions,omitempty"`
+	// Multi-buy deals, which lower the price only when buying several copies.
+	Offers []multiBuyOffer `json:"offers,omitempty"`
+}
+
+type appliedPromotion struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Discount float64   `json:"discount"`
+	Ends     time.Time `json:"ends"`
+}
+
+// `multiBuyOffer` is a buy-get promotion: buying `Buy` copies gets `Get` more for free, which brings
+// the price of each copy down to `UnitPrice`.
+type multiBuyOffer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Buy       int       `json:"buy"`
+	Get       int       `json:"get"`
+	UnitPrice float64   `json:"unitPrice"`
+	Ends      time.Time `json:"ends"`
+}
+
+var errPromotionNotFound = errors.New("promotion not found")
+
+// `promotionStore` keeps the promotions in memory.
+type promotionStore struct {
+	mu         sync.RWMutex
+	promotions map[string]promotion
+	nextID     int
+}
+
+func newPromotionStore() *promotionStore {
+	return &promotionStore{promotions: map[string]promotion{}, nextID: 1}
+}
+
+// `List` returns the promotions in order of ID.
+func (s *promotionStore) List() []promotion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	promotions := make([]promotion, 0, len(s.promotions))
+	for _, p := range s.promotions {
+		promotions = append(promotions, p)
+	}
+	slices.SortFunc(promotions, func(a, b promotion) int { return cmp.Compare(promotionNumber(a.ID), promotionNumber(b.ID)) })
+	return promotions
+}
+
+func promotionNumber(id string) int {
+	n, _ := strconv.Atoi(id)
+	return n
+}
+
+func (s *promotionStore) Get(id string) (promotion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.promotions[id]
+	if !ok {
+		return promotion{}, errPromotionNotFound
+	}
+	return p, nil
+}
+
+func (s *promotionStore) Add(p promotion) promotion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.ID = strconv.Itoa(s.nextID)
+	s.nextID++
+	s.promotions[p.ID] = p
+	return p
+}
+
+func (s *promotionStore) Replace(p promotion) (promotion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.promotions[p.ID]; !ok {
+		return promotion{}, errPromotionNotFound
+	}
+	s.promotions[p.ID] = p
+	return p, nil
+}
+
+// `ReplaceAll` swaps all promotions for `promotions`. New promotions get IDs above theirs.
+func (s *promotionStore) ReplaceAll(promotions []promotion) {
+	byID, nextID := make(map[string]promotion, len(promotions)), 1
+	for _, p := range promotions {
+		byID[p.ID] = p
+		nextID = max(nextID, promotionNumber(p.ID)+1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promotions, s.nextID = byID, max(s.nextID, nextID)
+}
+
+func (s *promotionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.promotions[id]; !ok {
+		return errPromotionNotFound
+	}
+	delete(s.promotions, id)
+	return nil
+}
+
+// `Running` returns the promotions running at `now`, from the highest priority down, ties going to
+// the older promotion. Requests take them once and price all their albums with them; see `priceAlbum`.
+func (s *promotionStore) Running(now time.Time) []promotion {
+	var running []promotion
+	for _, p := range s.List() {
+		if p.Active(now) {
+			running = append(running, p)
+		}
+	}
+	slices.SortStableFunc(running, func(x, y promotion) int { return cmp.Compare(y.Priority, x.Priority) })
+	return running
+}
+
+// `priceAlbum` works out what `a` costs with the `running` promotions, in the order `Running` returns
+// them, or returns nil if none of them applies to it.
+//
+// The first promotion that targets the album always applies. After that, a promotion only applies if
+// it and every promotion applied so far are stackable. Percent discounts are taken off the price left
+// by the promotions before them, and the price never goes below zero. Buy-get promotions don't
+// change the price of one copy; they are listed as offers at the discounted price instead.
+func priceAlbum(a album, running []promotion) *albumPricing {
+	var pricing *albumPricing
+	var deals []promotion
+	stackable := true
+	for _, p := range running {
+		if !p.Targets(a) {
+			continue
+		}
+		if pricing == nil {
+			pricing = &albumPricing{EffectivePrice: a.Price}
+		}
+		if p.Discount.Type == discountBuyGet {
+			deals = append(deals, p)
+			continue
+		}
+		if len(pricing.Promotions) > 0 && !(stackable && p.Stackable) {
+			continue
+		}
+		stackable = stackable && p.Stackable
+
+		discount := p.Discount.Amount
+		if p.Discount.Type == discountPercent {
+			discount = pricing.EffectivePrice * p.Discount.Percent / 100
+		}
+		discount = roundCents(min(discount, pricing.EffectivePrice))
+		pricing.EffectivePrice = roundCents(pricing.EffectivePrice - discount)
+		pricing.Promotions = append(pricing.Promotions, appliedPromotion{ID: p.ID, Name: p.Name, Discount: discount, Ends: p.Ends})
+	}
+	if pricing == nil {
+		return nil
+	}
+	for _, p := range deals {
+		unitPrice := roundCents(pricing.EffectivePrice * float64(p.Discount.Buy) / float64(p.Discount.Buy+p.Discount.Get))
+		pricing.Offers = append(pricing.Offers, multiBuyOffer{ID: p.ID, Name: p.Name, Buy: p.Discount.Buy, Get: p.Discount.Get, UnitPrice: unitPrice, Ends: p.Ends})
+	}
+	return pricing
+}
+
+func roundCents(price float64) float64 {
+	return math.Round(price*100) / 100
+}
+
+// `promotionResponse` is a promotion as the admin API shows it, with whether it is running now.
+type promotionResponse struct {
+	promotion
+	Active bool `json:"active"`
+}
+
+// `getPromotions` lists the promotions; with `?active=true`, only those running now.
+func (s *server) getPromotions(c *gin.Context) {
+	onlyActive, err := strconv.ParseBool(c.DefaultQuery("active", "false"))
+	if err != nil {
+		writeProblem(c, problemBadRequest, "active must be true or false")
+		return
+	}
+	now := time.Now()
+	promotions := []promotionResponse{}
+	for _, p := range s.promotions.List() {
+		if !onlyActive || p.Active(now) {
+			promotions = append(promotions, promotionResponse{p, p.Active(now)})
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, promotions)
+}
+
+func (s *server) getPromotion(c *gin.Context) {
+	p, err := s.promotions.Get(c.Param("id"))
+	if err != nil {
+		writeProblem(c, problemNotFound, "promotion not found")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, promotionResponse{p, p.Active(time.Now())})
+}
+
+// `postPromotion` adds a promotion from the JSON in the request body.
+func (s *server) postPromotion(c *gin.Context) {
+	p, ok := s.bindPromotion(c)
+	if !ok {
+		return
+	}
+	p = s.promotions.Add(p)
+	logger.Printf("promotion %s %q added, running %s to %s", p.ID, p.Name, p.Starts.Format(time.RFC3339), p.Ends.Format(time.RFC3339))
+	c.IndentedJSON(http.StatusCreated, promotionResponse{p, p.Active(time.Now())})
+}
+
+// `putPromotion` replaces the promotion with the `id` parameter.
+func (s *server) putPromotion(c *gin.Context) {
+	p, ok := s.bindPromotion(c)
+	if !ok {
+		return
+	}
+	p.ID = c.Param("id")
+	p, err := s.promotions.Replace(p)
+	if err != nil {
+		writeProblem(c, problemNotFound, "promotion not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, promotionResponse{p, p.Active(time.Now())})
+}
+
+func (s *server) deletePromotion(c *gin.Context) {
+	if err := s.promotions.Delete(c.Param("id")); err != nil {
+		writeProblem(c, problemNotFound, "promotion not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// `bindPromotion` reads and validates the promotion in the request body, or responds with the problem.
+// The body must be sent as `Content-Type: application/json`, which a cross-site form cannot send,
+// so the promotion endpoints need no CSRF token.
+func (s *server) bindPromotion(c *gin.Context) (promotion, bool) {
+	var p promotion
+	if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType != "application/json" {
+		writeProblem(c, problemUnsupportedMediaType, "send the promotion as application/json")
+		return p, false
+	}
+	if err := tracedBind(c.Request.Context(), c.ShouldBindJSON)(&p); err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return p, false
+	}
+	if err := p.validate(); err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return p, false
+	}
+	return p, true
+}
@@ -0,0 +1,193 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPromotionTargets(t *testing.T) {
+	a := album{ID: "1", Artist: "John Coltrane", Price: 20}
+	tests := []struct {
+		target promotionTarget
+		want   bool
+	}{
+		{promotionTarget{}, true},
+		{promotionTarget{AlbumIDs: []string{"2", "1"}}, true},
+		{promotionTarget{AlbumIDs: []string{"2"}}, false},
+		{promotionTarget{Artist: "john coltrane"}, true},
+		{promotionTarget{Artist: "John Coltrane", AlbumIDs: []string{"2"}}, false},
+		{promotionTarget{MinPrice: 10, MaxPrice: 20}, true},
+		{promotionTarget{MinPrice: 25}, false},
+		{promotionTarget{MaxPrice: 19.99}, false},
+	}
+	for _, tt := range tests {
+		if got := (promotion{Target: tt.target}).Targets(a); got != tt.want {
+			t.Errorf("target %+v = %v; want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPromotionPrice(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	running := func(p promotion) promotion {
+		p.Starts, p.Ends = now.Add(-time.Hour), now.Add(time.Hour)
+		return p
+	}
+	percent := func(n float64) promotionDiscount { return promotionDiscount{Type: discountPercent, Percent: n} }
+	amount := func(n float64) promotionDiscount { return promotionDiscount{Type: discountAmount, Amount: n} }
+	a := album{ID: "1", Artist: "John Coltrane", Price: 50}
+
+	tests := []struct {
+		name       string
+		promotions []promotion
+		want       float64
+		applied    []string
+	}{
+		{"none", nil, 50, nil},
+		{"expired", []promotion{{Name: "old", Starts: now.Add(-2 * time.Hour), Ends: now, Discount: percent(50)}}, 50, nil},
+		{"not yet", []promotion{{Name: "new", Starts: now.Add(time.Minute), Ends: now.Add(time.Hour), Discount: percent(50)}}, 50, nil},
+		{"one", []promotion{running(promotion{Name: "weekend", Discount: percent(20)})}, 40, []string{"weekend"}},
+		{"highest priority wins", []promotion{
+			running(promotion{Name: "low", Discount: percent(50)}),
+			running(promotion{Name: "high", Priority: 10, Discount: amount(5)}),
+		}, 45, []string{"high"}},
+		{"stacked", []promotion{
+			running(promotion{Name: "percent", Priority: 2, Stackable: true, Discount: percent(10)}),
+			running(promotion{Name: "amount", Priority: 1, Stackable: true, Discount: amount(5)}),
+		}, 40, []string{"percent", "amount"}},
+		{"exclusive first stops the stack", []promotion{
+			running(promotion{Name: "exclusive", Priority: 2, Discount: percent(10)}),
+			running(promotion{Name: "stackable", Priority: 1, Stackable: true, Discount: amount(5)}),
+		}, 45, []string{"exclusive"}},
+		{"exclusive later is skipped", []promotion{
+			running(promotion{Name: "stackable", Priority: 2, Stackable: true, Discount: amount(5)}),
+			running(promotion{Name: "exclusive", Priority: 1, Discount: percent(50)}),
+			running(promotion{Name: "also stackable", Stackable: true, Discount: amount(5)}),
+		}, 40, []string{"stackable", "also stackable"}},
+		{"never below zero", []promotion{running(promotion{Name: "giveaway", Discount: amount(80)})}, 0, []string{"giveaway"}},
+	}
+	for _, tt := range tests {
+		store := newPromotionStore()
+		for _, p := range tt.promotions {
+			store.Add(p)
+		}
+		pricing := priceAlbum(a, store.Running(now))
+		if tt.applied == nil {
+			if pricing != nil {
+				t.Errorf("%s: pricing = %+v; want none", tt.name, pricing)
+			}
+			continue
+		}
+		var applied []string
+		for _, p := range pricing.Promotions {
+			applied = append(applied, p.Name)
+		}
+		if pricing.EffectivePrice != tt.want || len(applied) != len(tt.applied) {
+			t.Errorf("%s: price %v with %v; want %v with %v", tt.name, pricing.EffectivePrice, applied, tt.want, tt.applied)
+			continue
+		}
+		for i := range applied {
+			if applied[i] != tt.applied[i] {
+				t.Errorf("%s: applied %v; want %v", tt.name, applied, tt.applied)
+			}
+		}
+	}
+}
+
+func TestPromotionMultiBuyOffer(t *testing.T) {
+	now := time.Now()
+	store := newPromotionStore()
+	store.Add(promotion{Name: "3 for 2", Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour), Discount: promotionDiscount{Type: discountBuyGet, Buy: 2, Get: 1}})
+	store.Add(promotion{Name: "sale", Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour), Discount: promotionDiscount{Type: discountPercent, Percent: 10}})
+
+	pricing := priceAlbum(album{ID: "1", Price: 30}, store.Running(now))
+	if pricing == nil || pricing.EffectivePrice != 27 || len(pricing.Promotions) != 1 {
+		t.Fatalf("pricing = %+v; want the sale applied to one copy", pricing)
+	}
+	if len(pricing.Offers) != 1 || pricing.Offers[0].UnitPrice != 18 || pricing.Offers[0].Buy != 2 || pricing.Offers[0].Get != 1 {
+		t.Errorf("offers = %+v; want 3 for 2 at 18 a copy", pricing.Offers)
+	}
+}
+
+func TestPromotionAdminAPI(t *testing.T) {
+	router := newBackupTestRouter(t)
+	now := time.Now().UTC()
+	body, _ := json.Marshal(promotion{
+		Name:     "Coltrane weekend",
+		Starts:   now.Add(-time.Hour),
+		Ends:     now.Add(48 * time.Hour),
+		Target:   promotionTarget{Artist: "john coltrane"},
+		Discount: promotionDiscount{Type: discountPercent, Percent: 20},
+	})
+
+	if w := adminRequest(router, http.MethodPost, "/admin/promotions", "application/json", body); w.Code != http.StatusCreated {
+		t.Fatalf("POST /admin/promotions = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodPost, "/admin/promotions", string(body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials = %d; want 401", w.Code)
+	}
+	if w := adminRequest(router, http.MethodPost, "/admin/promotions", "text/plain", body); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain = %d; want 415", w.Code)
+	}
+
+	var a album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums/1", "").Body.Bytes(), &a)
+	if a.Price != 56.99 || a.Pricing == nil || a.Pricing.EffectivePrice != 45.59 || a.Pricing.Promotions[0].Name != "Coltrane weekend" {
+		t.Errorf("album 1 = %+v; want the weekend promotion applied", a)
+	}
+	var list []album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums", "").Body.Bytes(), &list)
+	for _, a := range list {
+		if (a.Pricing != nil) != (a.ID == "1") {
+			t.Errorf("album %s pricing = %+v; want it only for album 1", a.ID, a.Pricing)
+		}
+	}
+	var v2 albumV2
+	json.Unmarshal(serve(router, http.MethodGet, "/v2/albums/1", "").Body.Bytes(), &v2)
+	if v2.Pricing == nil || v2.Pricing.EffectivePrice != 45.59 {
+		t.Errorf("v2 pricing = %+v", v2.Pricing)
+	}
+
+	var listed []promotionResponse
+	json.Unmarshal(adminRequest(router, http.MethodGet, "/admin/promotions?active=true", "", nil).Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != "1" || !listed[0].Active {
+		t.Errorf("promotions = %+v", listed)
+	}
+
+	ended, _ := json.Marshal(promotion{Name: "over", Starts: now.Add(-2 * time.Hour), Ends: now.Add(-time.Hour), Discount: promotionDiscount{Type: discountAmount, Amount: 1}})
+	if w := adminRequest(router, http.MethodPut, "/admin/promotions/1", "application/json", ended); w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	var after album
+	json.Unmarshal(serve(router, http.MethodGet, "/albums/1", "").Body.Bytes(), &after)
+	if after.Pricing != nil {
+		t.Errorf("pricing after the promotion ended = %+v", after.Pricing)
+	}
+
+	if w := adminRequest(router, http.MethodDelete, "/admin/promotions/1", "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", w.Code)
+	}
+	decodeProblem(t, adminRequest(router, http.MethodGet, "/admin/promotions/1", "", nil), problemNotFound)
+	decodeProblem(t, adminRequest(router, http.MethodPut, "/admin/promotions/1", "application/json", ended), problemNotFound)
+}
+
+func TestPromotionValidation(t *testing.T) {
+	router := newBackupTestRouter(t)
+	tests := map[string]string{
+		"ends before it starts": `{"name": "x", "starts": "2024-06-02T00:00:00Z", "ends": "2024-06-01T00:00:00Z", "discount": {"type": "percent", "percent": 10}}`,
+		"percent above 100":     `{"name": "x", "starts": "2024-06-01T00:00:00Z", "ends": "2024-06-02T00:00:00Z", "discount": {"type": "percent", "percent": 120}}`,
+		"mixed discount":        `{"name": "x", "starts": "2024-06-01T00:00:00Z", "ends": "2024-06-02T00:00:00Z", "discount": {"type": "amount", "amount": 5, "percent": 10}}`,
+		"buy without get":       `{"name": "x", "starts": "2024-06-01T00:00:00Z", "ends": "2024-06-02T00:00:00Z", "discount": {"type": "buy-get", "buy": 2}}`,
+		"unknown type":          `{"name": "x", "starts": "2024-06-01T00:00:00Z", "ends": "2024-06-02T00:00:00Z", "discount": {"type": "bogof"}}`,
+		"inverted price range":  `{"name": "x", "starts": "2024-06-01T00:00:00Z", "ends": "2024-06-02T00:00:00Z", "target": {"minPrice": 20, "maxPrice": 10}, "discount": {"type": "amount", "amount": 5}}`,
+		"no name":               `{"starts": "2024-06-01T00:00:00Z", "ends": "2024-06-02T00:00:00Z", "discount": {"type": "amount", "amount": 5}}`,
+	}
+	for name, body := range tests {
+		w := adminRequest(router, http.MethodPost, "/admin/promotions", "application/json", []byte(body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d %s; want 400", name, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package records_api
+
+import (
+	"cmp"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	// Bucket boundaries can be in any timezone, even where the system has no timezone database.
+	_ "time/tzdata"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The ways `GET /reports/sales` can group sales: by what was sold, or by when.
+var salesGroupings = map[string]bool{"artist": false, "album": false, "day": true, "week": true, "month": true}
+
+// `salesReport` is the response of `GET /reports/sales`.
+type salesReport struct {
+	GroupBy  string       `json:"groupBy"`
+	From     *time.Time   `json:"from,omitempty"`
+	To       *time.Time   `json:"to,omitempty"`
+	Timezone string       `json:"timezone"`
+	Groups   []salesGroup `json:"groups"`
+	// All orders in the range, counted once each.
+	Total salesGroup `json:"total"`
+}
+
+// `salesGroup` sums up the sales of one artist, album or period.
+//
+// Grouped by artist or album, an order counts towards every group it has an item of, with only the
+// revenue of those items, so `Orders` of the groups can add up to more than the orders in the range.
+type salesGroup struct {
+	// The artist, the album ID, or the first day of the period: `2024-06-03` for days and weeks, `2024-06` for months.
+	Key string `json:"key"`
+	// The album title when grouped by album.
+	Label string `json:"label,omitempty"`
+	// When the period starts, in the report's timezone.
+	Start             *time.Time `json:"start,omitempty"`
+	Revenue           float64    `json:"revenue"`
+	Orders            int        `json:"orders"`
+	Units             int        `json:"units"`
+	AverageOrderValue float64    `json:"averageOrderValue"`
+}
+
+func (g *salesGroup) add(revenue float64, units int) {
+	g.Revenue += revenue
+	g.Units += units
+	g.Orders++
+}
+
+func (g *salesGroup) finish() {
+	g.Revenue = roundCents(g.Revenue)
+	if g.Orders > 0 {
+		g.AverageOrderValue = roundCents(g.Revenue / float64(g.Orders))
+	}
+}
+
+// `buildSalesReport` sums up the `orders` placed from `from` up to, but not including, `to`. A zero
+// `from` or `to` leaves the range open at that end. Periods start at midnight in `loc`, and weeks on Monday.
+func buildSalesReport(orders []order, groupBy string, from, to time.Time, loc *time.Location) salesReport {
+	report := salesReport{GroupBy: groupBy, Timezone: loc.String(), Total: salesGroup{Key: "total"}}
+	if !from.IsZero() {
+		report.From = &from
+	}
+	if !to.IsZero() {
+		report.To = &to
+	}
+
+	groups := map[string]*salesGroup{}
+	group := func(key, label string) *salesGroup {
+		g, ok := groups[key]
+		if !ok {
+			g = &salesGroup{Key: key, Label: label}
+			groups[key] = g
+		}
+		return g
+	}
+	for _, o := range orders {
+		if o.PlacedAt.Before(from) || !to.IsZero() && !o.PlacedAt.Before(to) {
+			continue
+		}
+		units := 0
+		for _, item := range o.Items {
+			units += item.Quantity
+		}
+		report.Total.add(o.Total, units)
+
+		switch groupBy {
+		case "artist", "album":
+			// The items of the order are added up by group first, so the order counts once towards each group.
+			perOrder := map[string]*salesGroup{}
+			for _, item := range o.Items {
+				key, label := item.Artist, ""
+				if groupBy == "album" {
+					key, label = item.AlbumID, item.Title
+				}
+				if perOrder[key] == nil {
+					perOrder[key] = &salesGroup{Label: label}
+				}
+				perOrder[key].Revenue += item.Total
+				perOrder[key].Units += item.Quantity
+			}
+			for key, sold := range perOrder {
+				group(key, sold.Label).add(sold.Revenue, sold.Units)
+			}
+		default:
+			start, key := periodStart(o.PlacedAt.In(loc), groupBy)
+			g := group(key, "")
+			g.Start = &start
+			g.add(o.Total, units)
+		}
+	}
+
+	report.Groups = make([]salesGroup, 0, len(groups))
+	for _, g := range groups {
+		g.finish()
+		report.Groups = append(report.Groups, *g)
+	}
+	report.Total.finish()
+	slices.SortFunc(report.Groups, func(a, b salesGroup) int {
+		if salesGroupings[groupBy] {
+			return a.Start.Compare(*b.Start)
+		}
+		// Best sellers first.
+		return cmp.Or(cmp.Compare(b.Revenue, a.Revenue), cmp.Compare(a.Key, b.Key))
+	})
+	return report
+}
+
+// `periodStart` returns the start of the day, week or month that `t` falls in, in the location of `t`, and its key.
+func periodStart(t time.Time, period string) (time.Time, string) {
+	year, month, day := t.Date()
+	switch period {
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location()), t.Format("2006-01")
+	case "week":
+		// `time.Weekday` starts on Sunday; ISO weeks start on Monday.
+		day -= (int(t.Weekday()) + 6) % 7
+	}
+	start := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	return start, start.Format("2006-01-02")
+}
+
+// `getSalesReport` sums up the recorded orders. The report is computed from a snapshot of the orders,
+// so it doesn't hold up new orders however long it takes; see `orderStore.Snapshot`.
+func (s *server) getSalesReport(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "month")
+	if _, ok := salesGroupings[groupBy]; !ok {
+		writeProblem(c, problemBadRequest, "group_by must be artist, album, day, week or month")
+		return
+	}
+	loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+	if err != nil || loc == time.Local {
+		writeProblem(c, problemBadRequest, "tz must be an IANA timezone like Europe/Paris")
+		return
+	}
+	var from, to time.Time
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if value := c.Query(bound.name); value != "" {
+			if *bound.t, err = time.Parse(time.RFC3339, value); err != nil {
+				writeProblem(c, problemBadRequest, bound.name+" must be an RFC 3339 timestamp like 2024-06-01T00:00:00Z")
+				return
+			}
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		writeProblem(c, problemBadRequest, "from must be before to")
+		return
+	}
+	format := c.Query("format")
+	if format == "" {
+		format = "json"
+		if c.NegotiateFormat(gin.MIMEJSON, "text/csv") == "text/csv" {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		writeProblem(c, problemBadRequest, "format must be json or csv")
+		return
+	}
+
+	report := buildSalesReport(s.orders.Snapshot(), groupBy, from, to, loc)
+	c.Header("Cache-Control", "no-store")
+	c.Writer.Header().Add("Vary", "Accept")
+	if format == "json" {
+		c.IndentedJSON(http.StatusOK, report)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="sales-by-`+groupBy+`.csv"`)
+	c.Status(http.StatusOK)
+	if err := writeSalesCSV(c.Writer, report); err != nil {
+		logError(c.Request.Context(), err)
+	}
+}
+
+// `writeSalesCSV` writes a header row, a row per group, and a last row with the totals, whose key is empty.
+func writeSalesCSV(w io.Writer, report salesReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"key", "label", "start", "revenue", "orders", "units", "averageOrderValue"})
+	row := func(g salesGroup) {
+		start := ""
+		if g.Start != nil {
+			start = g.Start.Format(time.RFC3339)
+		}
+		out.Write([]string{csvText(g.Key), csvText(g.Label), start, formatCents(g.Revenue), strconv.Itoa(g.Orders), strconv.Itoa(g.Units), formatCents(g.AverageOrderValue)})
+	}
+	for _, g := range report.Groups {
+		row(g)
+	}
+	total := report.Total
+	total.Key, total.Label = "", "total"
+	row(total)
+	out.Flush()
+	return out.Error()
+}
+
+// `csvText` keeps text from clients, such as artist names, from being taken for a formula by
+// spreadsheets: a cell that starts like one gets a leading `'`, which they don't show.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatCents(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
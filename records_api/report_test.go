@@ -0,0 +1,180 @@
+package records_api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostOrder(t *testing.T) {
+	router := newBackupTestRouter(t)
+	now := time.Now()
+	body, _ := json.Marshal(promotion{
+		Name: "3 for 2", Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour),
+		Target:   promotionTarget{AlbumIDs: []string{"2"}},
+		Discount: promotionDiscount{Type: discountBuyGet, Buy: 2, Get: 1},
+	})
+	adminRequest(router, http.MethodPost, "/admin/promotions", "application/json", body)
+
+	w := adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "1", "quantity": 1}, {"albumId": "2", "quantity": 4}]}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /orders = %d %s", w.Code, w.Body)
+	}
+	var o order
+	json.Unmarshal(w.Body.Bytes(), &o)
+	// Three copies of Jeru are paid for: two of the first three, and the fourth.
+	if len(o.Items) != 2 || o.Items[1].Total != 53.97 || o.Items[1].Promotions[0] != "1" || o.Total != 110.96 {
+		t.Errorf("order = %+v", o)
+	}
+	if location := w.Header().Get("Location"); location != "/orders/"+o.ID {
+		t.Errorf("Location = %q", location)
+	}
+	if w := adminRequest(router, http.MethodGet, "/orders/"+o.ID, "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /orders/%s = %d", o.ID, w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/orders/"+o.ID, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /orders/%s without credentials = %d; want 401", o.ID, w.Code)
+	}
+	// Sales are recorded by the shop's backend, so nobody else can feed made-up ones into the reports.
+	decodeProblem(t, serve(router, http.MethodPost, "/orders", `{"items": [{"albumId": "1", "quantity": 1}]}`), problemUnauthorized)
+
+	p := decodeProblem(t, adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "1", "quantity": 1}, {"albumId": "9", "quantity": 1}]}`)), problemUnprocessable)
+	if len(p.Errors) != 1 || p.Errors[0].Field != "items[1].albumId" {
+		t.Errorf("errors = %+v", p.Errors)
+	}
+	decodeProblem(t, adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "1", "quantity": 1}, {"albumId": "1", "quantity": 1}]}`)), problemValidation)
+	decodeProblem(t, adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": []}`)), problemValidation)
+	decodeProblem(t, adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "1", "quantity": 0}]}`)), problemValidation)
+}
+
+func TestPeriodStart(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	// A Sunday evening, which is already Monday in Paris.
+	at := time.Date(2024, 3, 31, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		t       time.Time
+		period  string
+		wantKey string
+		want    time.Time
+	}{
+		{at, "day", "2024-03-31", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{at, "week", "2024-03-25", time.Date(2024, 3, 25, 0, 0, 0, 0, time.UTC)},
+		{at, "month", "2024-03", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{at.In(paris), "day", "2024-04-01", time.Date(2024, 4, 1, 0, 0, 0, 0, paris)},
+		{at.In(paris), "week", "2024-04-01", time.Date(2024, 4, 1, 0, 0, 0, 0, paris)},
+		{at.In(paris), "month", "2024-04", time.Date(2024, 4, 1, 0, 0, 0, 0, paris)},
+	}
+	for _, tt := range tests {
+		start, key := periodStart(tt.t, tt.period)
+		if key != tt.wantKey || !start.Equal(tt.want) {
+			t.Errorf("periodStart(%v, %s) = %v, %s; want %v, %s", tt.t, tt.period, start, key, tt.want, tt.wantKey)
+		}
+	}
+}
+
+func TestBuildSalesReport(t *testing.T) {
+	day := func(d, hour int) time.Time { return time.Date(2024, 6, d, hour, 0, 0, 0, time.UTC) }
+	orders := []order{
+		{ID: "1", PlacedAt: day(3, 10), Total: 30, Items: []orderItem{
+			{AlbumID: "1", Title: "Blue Train", Artist: "John Coltrane", Quantity: 1, Total: 20},
+			{AlbumID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Quantity: 1, Total: 10},
+		}},
+		{ID: "2", PlacedAt: day(3, 23), Total: 50, Items: []orderItem{
+			{AlbumID: "1", Title: "Blue Train", Artist: "John Coltrane", Quantity: 2, Total: 40},
+			{AlbumID: "4", Title: "Giant Steps", Artist: "John Coltrane", Quantity: 1, Total: 10},
+		}},
+		{ID: "3", PlacedAt: day(10, 12), Total: 10, Items: []orderItem{
+			{AlbumID: "2", Title: "Jeru", Artist: "Gerry Mulligan", Quantity: 1, Total: 10},
+		}},
+	}
+
+	report := buildSalesReport(orders, "artist", time.Time{}, time.Time{}, time.UTC)
+	if len(report.Groups) != 2 || report.Groups[0].Key != "John Coltrane" {
+		t.Fatalf("groups = %+v", report.Groups)
+	}
+	if g := report.Groups[0]; g.Revenue != 70 || g.Orders != 2 || g.Units != 4 || g.AverageOrderValue != 35 {
+		t.Errorf("John Coltrane = %+v", g)
+	}
+	if total := report.Total; total.Revenue != 90 || total.Orders != 3 || total.Units != 6 || total.AverageOrderValue != 30 {
+		t.Errorf("total = %+v", total)
+	}
+
+	report = buildSalesReport(orders, "album", day(3, 0), day(10, 12), time.UTC)
+	if len(report.Groups) != 3 || report.Groups[0].Key != "1" || report.Groups[0].Label != "Blue Train" || report.Total.Orders != 2 {
+		t.Errorf("albums up to the third order = %+v, total %+v", report.Groups, report.Total)
+	}
+
+	// At 23:00 UTC, the second order is already on June 4th in Tokyo.
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	report = buildSalesReport(orders, "day", time.Time{}, time.Time{}, tokyo)
+	var keys []string
+	for _, g := range report.Groups {
+		keys = append(keys, g.Key)
+	}
+	if strings.Join(keys, ",") != "2024-06-03,2024-06-04,2024-06-10" || report.Timezone != "Asia/Tokyo" {
+		t.Errorf("days in Tokyo = %v", keys)
+	}
+
+	report = buildSalesReport(orders, "week", time.Time{}, time.Time{}, time.UTC)
+	if len(report.Groups) != 2 || report.Groups[0].Key != "2024-06-03" || report.Groups[0].Revenue != 80 || report.Groups[0].Orders != 2 {
+		t.Errorf("weeks = %+v", report.Groups)
+	}
+}
+
+func TestGetSalesReport(t *testing.T) {
+	router := newBackupTestRouter(t)
+	adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "1", "quantity": 2}]}`))
+	adminRequest(router, http.MethodPost, "/orders", "application/json", []byte(`{"items": [{"albumId": "2", "quantity": 1}]}`))
+
+	w := adminRequest(router, http.MethodGet, "/reports/sales?group_by=artist", "", nil)
+	var report salesReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /reports/sales = %d %s", w.Code, w.Body)
+	}
+	if len(report.Groups) != 2 || report.Groups[0].Key != "John Coltrane" || report.Total.Revenue != 131.97 || report.Total.Orders != 2 {
+		t.Errorf("report = %+v", report)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/reports/sales?group_by=month&tz=America/New_York", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") || len(rows) != 3 {
+		t.Fatalf("CSV = %d %q (%v)", w.Code, rows, err)
+	}
+	if rows[0][3] != "revenue" || rows[1][3] != "131.97" || rows[2][1] != "total" || rows[2][4] != "2" {
+		t.Errorf("CSV rows = %q", rows)
+	}
+
+	if w := serve(router, http.MethodGet, "/reports/sales", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials = %d; want 401", w.Code)
+	}
+	for _, query := range []string{"group_by=year", "tz=Mars/Olympus", "tz=Local", "from=yesterday", "from=2024-06-02T00:00:00Z&to=2024-06-01T00:00:00Z", "format=xml"} {
+		decodeProblem(t, adminRequest(router, http.MethodGet, "/reports/sales?"+query, "", nil), problemBadRequest)
+	}
+}
+
+func TestSalesCSVEscapesFormulas(t *testing.T) {
+	report := salesReport{Groups: []salesGroup{
+		{Key: "=HYPERLINK(\"http://example.com\")", Revenue: 10, Orders: 1},
+		{Key: "@SUM(A1)", Label: "+1", Revenue: 5, Orders: 1},
+		{Key: "John Coltrane", Label: "-", Revenue: 1, Orders: 1},
+		{Key: "\t=1+1", Label: "\r=1+1", Revenue: 1, Orders: 1},
+	}}
+	var buf strings.Builder
+	writeSalesCSV(&buf, report)
+	rows, _ := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	if rows[0][6] != "averageOrderValue" {
+		t.Errorf("CSV header = %q; want the JSON field names", rows[0])
+	}
+	if rows[1][0] != "'=HYPERLINK(\"http://example.com\")" || rows[2][0] != "'@SUM(A1)" || rows[2][1] != "'+1" ||
+		rows[3][0] != "John Coltrane" || rows[3][1] != "'-" || rows[4][0] != "'\t=1+1" || rows[4][1] != "'\r=1+1" {
+		t.Errorf("CSV rows = %q", rows)
+	}
+}
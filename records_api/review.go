@@ -0,0 +1,367 @@
+package records_api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// `review` is a customer's rating of an album with a short text.
+type review struct {
+	ID        string    `json:"id"`
+	AlbumID   string    `json:"albumId"`
+	Author    string    `json:"author" validate:"required,max=50,clean"`
+	Rating    int       `json:"rating" validate:"required,min=1,max=5"`
+	Text      string    `json:"text" validate:"required,min=10,max=2000,clean"`
+	CreatedAt time.Time `json:"createdAt"`
+	// The SHA-256 of the token that lets the author delete the review, hex-encoded; see `postReview`.
+	// Responses never show it, but backups keep it.
+	deleteTokenHash string
+}
+
+// `postedReview` is the response of `POST /albums/:id/reviews`. The delete token is only ever shown there.
+type postedReview struct {
+	review
+	DeleteToken string `json:"deleteToken"`
+}
+
+// `newDeleteToken` returns a random token for deleting a review and its hash.
+func newDeleteToken() (token, hash string) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:])
+}
+
+// `deletableWith` reports whether `token` is the review's delete token. Reviews without one can only
+// be deleted by the admin.
+func (r review) deletableWith(token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	return r.deleteTokenHash != "" && subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(r.deleteTokenHash)) == 1
+}
+
+// `reviewSummary` is included in every album response.
+type reviewSummary struct {
+	Count         int     `json:"count"`
+	AverageRating float64 `json:"averageRating"`
+}
+
+var errReviewNotFound = errors.New("review not found")
+
+// `reviewStore` keeps reviews in memory, newest first per album, together with a running count
+// and rating sum, so albums can show their summary without going through all their reviews.
+type reviewStore struct {
+	mu      sync.RWMutex
+	byAlbum map[string][]review
+	sums    map[string]int
+	nextID  int
+}
+
+func newReviewStore() *reviewStore {
+	return &reviewStore{byAlbum: map[string][]review{}, sums: map[string]int{}, nextID: 1}
+}
+
+func (s *reviewStore) Add(r review) review {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.ID = strconv.Itoa(s.nextID)
+	s.nextID++
+	s.byAlbum[r.AlbumID] = slices.Insert(s.byAlbum[r.AlbumID], 0, r)
+	s.sums[r.AlbumID] += r.Rating
+	return r
+}
+
+// `List` returns up to `limit` reviews of an album starting at `offset`, and the total number of reviews.
+func (s *reviewStore) List(albumID string, offset, limit int) ([]review, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reviews := s.byAlbum[albumID]
+	start := min(offset, len(reviews))
+	end := min(start+limit, len(reviews))
+	return slices.Clone(reviews[start:end]), len(reviews)
+}
+
+func (s *reviewStore) Get(albumID, id string) (review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := slices.IndexFunc(s.byAlbum[albumID], func(r review) bool { return r.ID == id })
+	if i < 0 {
+		return review{}, errReviewNotFound
+	}
+	return s.byAlbum[albumID][i], nil
+}
+
+func (s *reviewStore) Delete(albumID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reviews := s.byAlbum[albumID]
+	i := slices.IndexFunc(reviews, func(r review) bool { return r.ID == id })
+	if i < 0 {
+		return errReviewNotFound
+	}
+	s.sums[albumID] -= reviews[i].Rating
+	s.byAlbum[albumID] = slices.Delete(reviews, i, i+1)
+	return nil
+}
+
+// `DeleteAlbum` drops all reviews of an album.
+func (s *reviewStore) DeleteAlbum(albumID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.byAlbum, albumID)
+	delete(s.sums, albumID)
+}
+
+// `All` returns every review, grouped by album in order of album ID, newest first within an album.
+func (s *reviewStore) All() []review {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	albumIDs := make([]string, 0, len(s.byAlbum))
+	for albumID := range s.byAlbum {
+		albumIDs = append(albumIDs, albumID)
+	}
+	slices.Sort(albumIDs)
+	var all []review
+	for _, albumID := range albumIDs {
+		all = append(all, s.byAlbum[albumID]...)
+	}
+	return all
+}
+
+// `ReplaceAll` swaps all reviews for `reviews`, which are in the order `All` returns them.
+func (s *reviewStore) ReplaceAll(reviews []review) {
+	byAlbum, sums, nextID := map[string][]review{}, map[string]int{}, 1
+	for _, r := range reviews {
+		byAlbum[r.AlbumID] = append(byAlbum[r.AlbumID], r)
+		sums[r.AlbumID] += r.Rating
+		if n, err := strconv.Atoi(r.ID); err == nil && n >= nextID {
+			nextID = n + 1
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byAlbum, s.sums, s.nextID = byAlbum, sums, max(s.nextID, nextID)
+}
+
+func (s *reviewStore) Summary(albumID string) reviewSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := len(s.byAlbum[albumID])
+	if count == 0 {
+		return reviewSummary{}
+	}
+	average := float64(s.sums[albumID]) / float64(count)
+	return reviewSummary{Count: count, AverageRating: math.Round(average*100) / 100}
+}
+
+// `withComputed` returns copies of albums on their way out with the review summary and the promotional
+// pricing filled in. `albums` may be shared with the cache, so it is left as it is.
+func (s *server) withComputed(albums ...album) []album {
+	running := s.promotions.Running(time.Now())
+	computed := slices.Clone(albums)
+	for i := range computed {
+		computed[i].Reviews = s.reviews.Summary(computed[i].ID)
+		computed[i].Pricing = priceAlbum(computed[i], running)
+	}
+	return computed
+}
+
+//go:embed profanity.txt
+var defaultProfanity []byte
+
+// `loadProfanity` reads the word list at `path`, or returns the built-in list if `path` is empty.
+func loadProfanity(path string) ([]string, error) {
+	if path == "" {
+		return parseWordList(defaultProfanity), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read profanity list: %w", err)
+	}
+	return parseWordList(data), nil
+}
+
+// `parseWordList` returns the lowercased words of a list with one word per line.
+// Blank lines and lines starting with `#` are skipped.
+func parseWordList(data []byte) []string {
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, strings.ToLower(line))
+		}
+	}
+	return words
+}
+
+// `newReviewValidator` returns a validator with the `clean` tag, which rejects text containing any of `profanity`.
+// Reviews have their own validator rather than gin's shared one, since the word list is part of the server's configuration.
+func newReviewValidator(profanity []string) *validator.Validate {
+	banned := make(map[string]bool, len(profanity))
+	for _, word := range profanity {
+		banned[word] = true
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterValidation("clean", func(fl validator.FieldLevel) bool {
+		words := strings.FieldsFunc(strings.ToLower(fl.Field().String()), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		return !slices.ContainsFunc(words, func(word string) bool { return banned[word] })
+	})
+	return validate
+}
+
+// `describeValidation` turns validation errors into a message for the client, e.g. "rating must be at most 5".
+func describeValidation(err error) string {
+	fields := validationProblems(err, describeFieldError)
+	if fields == nil {
+		return err.Error()
+	}
+	return joinFieldProblems(fields)
+}
+
+// `describeFieldError` says what is wrong with one field of an album or review.
+func describeFieldError(fe validator.FieldError) fieldProblem {
+	field := strings.ToLower(fe.Field())
+	var detail string
+	switch fe.Tag() {
+	case "required":
+		detail = field + " is required"
+	case "min":
+		detail = fmt.Sprintf("%s must be at least %s%s", field, fe.Param(), characters(fe))
+	case "max":
+		detail = fmt.Sprintf("%s must be at most %s%s", field, fe.Param(), characters(fe))
+	case "gt":
+		detail = fmt.Sprintf("%s must be greater than %s", field, fe.Param())
+	case "clean":
+		detail = field + " contains inappropriate language"
+	default:
+		detail = field + " is invalid"
+	}
+	return fieldProblem{Field: field, Detail: detail}
+}
+
+func characters(fe validator.FieldError) string {
+	if fe.Kind() == reflect.String {
+		return " characters"
+	}
+	return ""
+}
+
+// `postReview` adds a review to the album with the `id` parameter.
+func (s *server) postReview(c *gin.Context) {
+	albumID := c.Param("id")
+	if _, err := s.albums.Get(c.Request.Context(), albumID); err != nil {
+		s.albumLookupFailed(c, err)
+		return
+	}
+
+	var r review
+	if err := tracedBind(c.Request.Context(), c.ShouldBindJSON)(&r); err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return
+	}
+	if err := s.reviewValidator.Struct(r); err != nil {
+		validationFailed(c, err, describeFieldError)
+		return
+	}
+
+	r.AlbumID = albumID
+	r.CreatedAt = time.Now().UTC()
+	token, hash := newDeleteToken()
+	r.deleteTokenHash = hash
+	c.IndentedJSON(http.StatusCreated, postedReview{review: s.reviews.Add(r), DeleteToken: token})
+}
+
+// `getReviews` lists the reviews of an album, newest first, a page at a time: `?page=2&perPage=10`.
+func (s *server) getReviews(c *gin.Context) {
+	albumID := c.Param("id")
+	if _, err := s.albums.Get(c.Request.Context(), albumID); err != nil {
+		s.albumLookupFailed(c, err)
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		writeProblem(c, problemBadRequest, "page must be a positive integer")
+		return
+	}
+	perPage, err := strconv.Atoi(c.DefaultQuery("perPage", "20"))
+	if err != nil || perPage < 1 || perPage > 100 {
+		writeProblem(c, problemBadRequest, "perPage must be between 1 and 100")
+		return
+	}
+
+	reviews, total := s.reviews.List(albumID, (page-1)*perPage, perPage)
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"reviews": reviews,
+		"page":    page,
+		"perPage": perPage,
+		"total":   total,
+		"summary": s.reviews.Summary(albumID),
+	})
+}
+
+// `deleteReview` removes one review of an album. Only its author may, with `Authorization: Bearer`
+// and the delete token from `postReview`, and the admin.
+func (s *server) deleteReview(c *gin.Context) {
+	r, err := s.reviews.Get(c.Param("id"), c.Param("reviewID"))
+	if err != nil {
+		writeProblem(c, problemNotFound, "review not found")
+		return
+	}
+	if !isAdmin(s.cfg, c) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="records reviews"`)
+			writeProblem(c, problemUnauthorized, "send the review's delete token as a bearer token")
+			return
+		}
+		if !r.deletableWith(token) {
+			writeProblem(c, problemForbidden, "only the author of the review or the admin can delete it")
+			return
+		}
+	}
+	// Review IDs are never reused, so the review is the same one unless it is gone already.
+	if err := s.reviews.Delete(r.AlbumID, r.ID); err != nil {
+		writeProblem(c, problemNotFound, "review not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *server) albumLookupFailed(c *gin.Context, err error) {
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
+	}
+	writeProblem(c, problemInternal, "could not get album")
+	logError(c.Request.Context(), err)
+}
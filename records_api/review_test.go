@@ -0,0 +1,187 @@
+package records_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func postReviewJSON(author string, rating int, text string) string {
+	body, _ := json.Marshal(review{Author: author, Rating: rating, Text: text})
+	return string(body)
+}
+
+func TestReviewValidation(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{postReviewJSON("ann", 6, "Lovely tone throughout."), "rating must be at most 5"},
+		{postReviewJSON("ann", 0, "Lovely tone throughout."), "rating is required"},
+		{postReviewJSON("ann", 4, "Nice."), "text must be at least 10 characters"},
+		{postReviewJSON("ann", 4, strings.Repeat("a", 2001)), "text must be at most 2000 characters"},
+		{postReviewJSON("ann", 1, "What a load of CRAP, honestly."), "text contains inappropriate language"},
+		{postReviewJSON("", 4, "Lovely tone throughout."), "author is required"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodPost, "/albums/1/reviews", tt.body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("POST %s = %d %s; want 400 %q", tt.body, w.Code, w.Body, tt.want)
+		}
+	}
+
+	// Words are matched whole, so innocent words containing a banned one pass.
+	if w := serve(router, http.MethodPost, "/albums/1/reviews", postReviewJSON("ann", 5, "Scrappy, classic hard bop.")); w.Code != http.StatusCreated {
+		t.Errorf("clean review = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodPost, "/albums/nope/reviews", postReviewJSON("ann", 5, "Lovely tone throughout.")); w.Code != http.StatusNotFound {
+		t.Errorf("review of a missing album = %d; want 404", w.Code)
+	}
+}
+
+func TestReviewSummary(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	summary := func(version string) reviewSummary {
+		var a struct {
+			Reviews reviewSummary `json:"reviews"`
+		}
+		w := serve(router, http.MethodGet, "/"+version+"/albums/2", "")
+		json.Unmarshal(w.Body.Bytes(), &a)
+		return a.Reviews
+	}
+
+	var created []postedReview
+	for _, rating := range []int{5, 4, 4} {
+		w := serve(router, http.MethodPost, "/albums/2/reviews", postReviewJSON("bob", rating, "Mulligan at his best."))
+		var r postedReview
+		json.Unmarshal(w.Body.Bytes(), &r)
+		created = append(created, r)
+	}
+	if got := summary("v1"); got != (reviewSummary{Count: 3, AverageRating: 4.33}) {
+		t.Errorf("summary after 3 reviews = %+v", got)
+	}
+	if got := summary("v2"); got.Count != 3 {
+		t.Errorf("v2 summary = %+v", got)
+	}
+
+	if w := deleteReviewWith(router, "/albums/2/reviews/"+created[0].ID, created[0].DeleteToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete review = %d", w.Code)
+	}
+	if got := summary("v1"); got != (reviewSummary{Count: 2, AverageRating: 4}) {
+		t.Errorf("summary after deleting a review = %+v", got)
+	}
+	if w := deleteReviewWith(router, "/albums/2/reviews/"+created[0].ID, created[0].DeleteToken); w.Code != http.StatusNotFound {
+		t.Errorf("deleting a review twice = %d; want 404", w.Code)
+	}
+}
+
+func deleteReviewWith(router http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDeleteReviewNeedsAuthorOrAdmin(t *testing.T) {
+	router := newBackupTestRouter(t)
+	var ann, bob postedReview
+	json.Unmarshal(serve(router, http.MethodPost, "/albums/1/reviews", postReviewJSON("ann", 5, "A landmark of hard bop.")).Body.Bytes(), &ann)
+	json.Unmarshal(serve(router, http.MethodPost, "/albums/1/reviews", postReviewJSON("bob", 4, "Lovely tone throughout.")).Body.Bytes(), &bob)
+	if ann.DeleteToken == "" || strings.Contains(serve(router, http.MethodGet, "/albums/1/reviews", "").Body.String(), ann.DeleteToken) {
+		t.Fatalf("delete token = %q; want one that is only in the response to POST", ann.DeleteToken)
+	}
+
+	decodeProblem(t, deleteReviewWith(router, "/albums/1/reviews/"+ann.ID, ""), problemUnauthorized)
+	decodeProblem(t, deleteReviewWith(router, "/albums/1/reviews/"+ann.ID, bob.DeleteToken), problemForbidden)
+	if w := deleteReviewWith(router, "/albums/1/reviews/"+ann.ID, ann.DeleteToken); w.Code != http.StatusNoContent {
+		t.Errorf("delete by the author = %d %s", w.Code, w.Body)
+	}
+	if w := deleteReviewWith(router, "/albums/1/reviews/"+bob.ID, "test-token"); w.Code != http.StatusNoContent {
+		t.Errorf("delete by the admin = %d %s", w.Code, w.Body)
+	}
+}
+
+// The album list is shared with the cache, so filling in the review summaries must not write to it.
+// Run with -race to catch it.
+func TestComputedFieldsLeaveCachedListAlone(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	s := newServer(cfg, newMemoryStore(albums))
+	router := newRouter(s)
+	serve(router, http.MethodPost, "/albums/1/reviews", postReviewJSON("ann", 5, "A landmark of hard bop."))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := serve(router, http.MethodGet, "/albums", ""); !strings.Contains(w.Body.String(), `"count": 1`) {
+				t.Errorf("GET /albums = %d %s", w.Code, w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cached, _ := s.albums.List(context.Background())
+	for _, a := range cached {
+		if a.Reviews != (reviewSummary{}) {
+			t.Errorf("cached album %s has reviews %+v", a.ID, a.Reviews)
+		}
+	}
+}
+
+func TestReviewPagination(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+	for i := range 5 {
+		serve(router, http.MethodPost, "/albums/3/reviews", postReviewJSON("cat", 3, fmt.Sprintf("Review number %d here.", i)))
+	}
+
+	var page struct {
+		Reviews []review `json:"reviews"`
+		Total   int      `json:"total"`
+	}
+	w := serve(router, http.MethodGet, "/albums/3/reviews?page=2&perPage=2", "")
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if page.Total != 5 || len(page.Reviews) != 2 || page.Reviews[0].Text != "Review number 2 here." {
+		t.Errorf("page 2 = %s", w.Body)
+	}
+
+	w = serve(router, http.MethodGet, "/albums/3/reviews?page=3&perPage=2", "")
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Reviews) != 1 {
+		t.Errorf("last page has %d reviews; want 1", len(page.Reviews))
+	}
+	if w := serve(router, http.MethodGet, "/albums/3/reviews?perPage=500", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("perPage=500 = %d; want 400", w.Code)
+	}
+}
+
+func TestProfanityFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	os.WriteFile(path, []byte("# house rules\n\nKazoo\n"), 0o644)
+
+	cfg, err := loadConfig([]string{"-profanity-file", path, "-cover-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(newServer(cfg, newMemoryStore(albums)))
+	if w := serve(router, http.MethodPost, "/albums/1/reviews", postReviewJSON("dan", 2, "Too much kazoo for me.")); w.Code != http.StatusBadRequest {
+		t.Errorf("review with a word from the file = %d; want 400", w.Code)
+	}
+	if w := serve(router, http.MethodPost, "/albums/1/reviews", postReviewJSON("dan", 2, "Crap, not my thing.")); w.Code != http.StatusCreated {
+		t.Errorf("the file replaces the built-in list, got %d", w.Code)
+	}
+}
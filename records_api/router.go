@@ -1,44 +1,169 @@
 package records_api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 // `getAlbums` responds with the list of all albums in JSON.
-func getAlbums(ctx *gin.Context) {
-	ctx.IndentedJSON(http.StatusOK, albums)
+func (s *server) getAlbums(c *gin.Context) {
+	albums, err := s.albums.List(c.Request.Context())
+	if err != nil {
+		writeProblem(c, problemInternal, "could not list albums")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	s.setCacheControl(c)
+	c.IndentedJSON(http.StatusOK, encodeAlbums(c, s.withComputed(albums...)))
 }
 
-// `postAlbums` adds an album from JSON received in the request body.
-func postAlbums(c *gin.Context) {
-	var newAlbum album
+// `postAlbums` adds an album from JSON received in the request body. An album that looks like one
+// already in the catalogue is refused with 409 unless the request has `?force=true`; see `findDuplicates`.
+func (s *server) postAlbums(c *gin.Context) {
+	force, err := strconv.ParseBool(c.DefaultQuery("force", "false"))
+	if err != nil {
+		writeProblem(c, problemBadRequest, "force must be true or false")
+		return
+	}
+	// The request adapter of the API version binds the recieved JSON to `newAlbum`.
+	newAlbum, err := versionOf(c).decodeAlbum(tracedBind(c.Request.Context(), c.ShouldBindJSON))
+	if err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return
+	}
+	if !force && s.cfg.DuplicateThreshold > 0 {
+		catalogue, err := s.albums.List(c.Request.Context())
+		if err != nil {
+			writeProblem(c, problemInternal, "could not list albums")
+			logError(c.Request.Context(), err)
+			return
+		}
+		if matches := findDuplicates(newAlbum, catalogue, s.cfg.DuplicateThreshold); len(matches) > 0 {
+			s.duplicateFound(c, matches)
+			return
+		}
+	}
+
+	// The cover, tracks and reviews can only be set through their own endpoints.
+	newAlbum.Cover = nil
+	newAlbum.Tracks, newAlbum.RunningTime = nil, nil
+	newAlbum.Reviews, newAlbum.Pricing = reviewSummary{}, nil
 
-	// Call `BindJSON` to bind the recieved JSON to `newAlbum`.
-	if err := c.BindJSON(&newAlbum); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "invalid JSON provided"})
-		logger.Println(err)
+	// Add the new album to the store.
+	created, err := s.albums.Create(c.Request.Context(), newAlbum)
+	if errors.Is(err, errAlbumExists) {
+		writeProblem(c, problemConflict, "album already exists")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not create album")
+		logError(c.Request.Context(), err)
 		return
 	}
+	c.IndentedJSON(http.StatusCreated, versionOf(c).encodeAlbum(s.withComputed(created)[0]))
+}
 
-	// Add the new album to the slice.
-	albums = append(albums, newAlbum)
-	c.IndentedJSON(http.StatusCreated, newAlbum)
+// `duplicateFound` refuses a new album that looks like the existing albums in `matches`.
+func (s *server) duplicateFound(c *gin.Context, matches []duplicateMatch) {
+	p := newProblem(problemDuplicate, c.Request, fmt.Sprintf("the album looks like %d existing album(s); send it with ?force=true to add it anyway", len(matches)))
+	for _, m := range matches {
+		encoded := versionOf(c).encodeAlbum(s.withComputed(m.album)[0])
+		p.Candidates = append(p.Candidates, duplicateCandidate{Album: encoded, Score: m.score})
+	}
+	sendProblem(c, p)
 }
 
 // `getAlbumByID` locates the album whose ID value matches the `id`
 // parameter sent by the client, then returns that album as a response.
-func getAlbumByID(c *gin.Context) {
+func (s *server) getAlbumByID(c *gin.Context) {
+	album, err := s.albums.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not get album")
+		logError(c.Request.Context(), err)
+		return
+	}
+
+	s.setCacheControl(c)
+	c.IndentedJSON(http.StatusOK, versionOf(c).encodeAlbum(s.withComputed(album)[0]))
+}
+
+// `putAlbum` replaces the album whose ID matches the `id` parameter with the JSON in the request body.
+// The cover and tracks are kept; they can only be changed through their own endpoints.
+func (s *server) putAlbum(c *gin.Context) {
 	id := c.Param("id")
+	updated, err := versionOf(c).decodeAlbum(tracedBind(c.Request.Context(), c.ShouldBindJSON))
+	if err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return
+	}
+	if updated.ID != "" && updated.ID != id {
+		writeProblem(c, problemBadRequest, "album ID in the body does not match the URL")
+		return
+	}
+	updated.ID = id
 
-	// Loop over the list of albums, looking for an album whose ID value matches the parameter.
-	for _, album := range albums {
-		if album.ID == id {
-			c.IndentedJSON(http.StatusOK, album)
-			return
+	ctx := c.Request.Context()
+	// The fields the body doesn't set are kept from the album as it is in the transaction, so a cover
+	// or track list stored at the same time isn't lost.
+	err = s.albums.Transaction(ctx, func(tx albumStore) error {
+		existing, err := tx.Get(ctx, id)
+		if err != nil {
+			return err
 		}
+		updated.Cover = existing.Cover
+		updated.Tracks, updated.RunningTime = existing.Tracks, existing.RunningTime
+		updated.Reviews, updated.Pricing = reviewSummary{}, nil
+		updated, err = tx.Update(ctx, updated)
+		return err
+	})
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not update album")
+		logError(c.Request.Context(), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, versionOf(c).encodeAlbum(s.withComputed(updated)[0]))
+}
+
+// `deleteAlbum` removes the album whose ID matches the `id` parameter.
+func (s *server) deleteAlbum(c *gin.Context) {
+	err := s.albums.Delete(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
 	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not delete album")
+		logError(c.Request.Context(), err)
+		return
+	}
+	s.reviews.DeleteAlbum(c.Param("id"))
+	c.Status(http.StatusNoContent)
+}
 
-	c.IndentedJSON((http.StatusNotFound), gin.H{"message": "album not found"})
-}
\ No newline at end of file
+// `getCacheStats` responds with the hit, miss and eviction counters of the album cache.
+func (s *server) getCacheStats(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, s.albums.Stats())
+}
+
+// `setCacheControl` tells clients they may cache a read for as long as the server itself would.
+func (s *server) setCacheControl(c *gin.Context) {
+	if !s.albums.enabled() {
+		c.Header("Cache-Control", "no-cache")
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.albums.ttl.Seconds())))
+}
@@ -0,0 +1,124 @@
+package records_api
+
+import (
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"gopkg.in/yaml.v3"
+)
+
+// The catalogue the server starts with unless `-seed` names another file.
+//
+//go:embed seed/albums.json
+var defaultSeed []byte
+
+// `loadSeed` reads the initial albums from the JSON or YAML file at `path`, or from the built-in
+// catalogue if `path` is empty. Invalid albums are logged and skipped, or fail the whole seed if `strict`.
+func loadSeed(path string, strict bool) ([]album, error) {
+	data, format := defaultSeed, "json"
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read seed: %w", err)
+		}
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".json":
+		case ".yaml", ".yml":
+			format = "yaml"
+		default:
+			return nil, fmt.Errorf("seed %s: unknown format %q; use .json, .yaml or .yml", path, ext)
+		}
+	}
+
+	albums, problems, err := parseSeed(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("seed %s: %w", cmp.Or(path, "(built-in)"), err)
+	}
+	if len(problems) > 0 && strict {
+		return nil, fmt.Errorf("seed %s: %w", cmp.Or(path, "(built-in)"), errors.Join(problems...))
+	}
+	for _, problem := range problems {
+		logger.Printf("seed: skipping %v", problem)
+	}
+	return albums, nil
+}
+
+// `parseSeed` decodes a list of albums and checks each one as if it had been sent to `POST /albums`.
+// It returns the valid albums and a problem for every invalid one; `err` is set only if the list
+// itself cannot be read.
+func parseSeed(data []byte, format string) (albums []album, problems []error, err error) {
+	var records []json.RawMessage
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &records)
+	case "yaml":
+		// YAML records are converted to JSON, so both formats use the same field names and decoding.
+		var list []any
+		if err = yaml.Unmarshal(data, &list); err == nil {
+			records = make([]json.RawMessage, len(list))
+			for i, item := range list {
+				if records[i], err = json.Marshal(item); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("expected a list of albums: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for i, record := range records {
+		a, err := parseSeedAlbum(record)
+		switch {
+		case err != nil:
+		case a.ID == "":
+			err = errors.New("id is required")
+		case seen[a.ID]:
+			err = fmt.Errorf("id %s is used twice", a.ID)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("album %d%s: %s", i, seedLabel(a), describeValidation(err)))
+			continue
+		}
+		seen[a.ID] = true
+		albums = append(albums, a)
+	}
+	return albums, problems, nil
+}
+
+func parseSeedAlbum(record json.RawMessage) (album, error) {
+	var a album
+	if err := binding.JSON.BindBody(record, &a); err != nil {
+		return a, err
+	}
+	// Seeds may list tracks, which are held to the rules of `PUT /albums/:id/tracks`.
+	if err := trackValidator.Struct(trackList{Tracks: a.Tracks}); err != nil {
+		return a, errors.New(describeTrackValidation(err))
+	}
+	sortTracks(a.Tracks)
+	a.RunningTime = newRunningTime(a.Tracks)
+	// Covers and reviews are not part of the catalogue.
+	a.Cover, a.Reviews, a.Pricing = nil, reviewSummary{}, nil
+	return a, nil
+}
+
+// `seedLabel` names an album in problem reports, as far as it could be decoded.
+func seedLabel(a album) string {
+	switch {
+	case a.ID != "" && a.Title != "":
+		return fmt.Sprintf(" (id %s, %q)", a.ID, a.Title)
+	case a.ID != "":
+		return fmt.Sprintf(" (id %s)", a.ID)
+	case a.Title != "":
+		return fmt.Sprintf(" (%q)", a.Title)
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package records_api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// `albums` is the built-in catalogue, which most tests start from.
+var albums = func() []album {
+	seed, err := loadSeed("", true)
+	if err != nil {
+		panic(err)
+	}
+	return seed
+}()
+
+func TestDefaultSeed(t *testing.T) {
+	if len(albums) != 3 || albums[2].Title != "Sarah Vaughan and Clifford Brown" || albums[0].Year != 1957 {
+		t.Errorf("built-in seed = %+v", albums)
+	}
+}
+
+const invalidSeed = `
+- id: "1"
+  title: Kind of Blue
+  artist: Miles Davis
+  price: 29.99
+  genres: [jazz, modal jazz]
+  tracks:
+    - {side: A, position: 2, title: Freddie Freeloader, duration: PT9M46S}
+    - {side: A, position: 1, title: So What, duration: PT9M22S}
+- id: "2"
+  title: Giant Steps
+  artist: John Coltrane
+  price: -1
+- title: Moanin'
+  artist: Art Blakey
+  price: 19.99
+- id: "1"
+  title: Somethin' Else
+  artist: Cannonball Adderley
+  price: 24.99
+- id: "5"
+  title: Mingus Ah Um
+  artist: Charles Mingus
+  price: 21.99
+  tracks:
+    - {side: A, position: 1, title: Better Git It in Your Soul, duration: "7:23"}
+`
+
+func writeSeed(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSeedSkipsInvalidAlbums(t *testing.T) {
+	seed, err := loadSeed(writeSeed(t, "albums.yaml", invalidSeed), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seed) != 1 || seed[0].Title != "Kind of Blue" {
+		t.Fatalf("seed = %+v; want only Kind of Blue", seed)
+	}
+	if seed[0].Tracks[0].Title != "So What" || seed[0].RunningTime == nil || seed[0].RunningTime.Total != "PT19M8S" {
+		t.Errorf("tracks = %+v, running time %+v", seed[0].Tracks, seed[0].RunningTime)
+	}
+}
+
+func TestLoadSeedStrict(t *testing.T) {
+	_, err := loadSeed(writeSeed(t, "albums.yml", invalidSeed), true)
+	if err == nil {
+		t.Fatal("strict seed with invalid albums succeeded")
+	}
+	for _, want := range []string{
+		`album 1 (id 2, "Giant Steps"): price must be greater than 0`,
+		`album 2 ("Moanin'"): id is required`,
+		`album 3 (id 1, "Somethin' Else"): id 1 is used twice`,
+		`album 4 (id 5, "Mingus Ah Um"): tracks[0].duration must be a positive ISO 8601 duration`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v\nwant it to contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadSeedErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"albums.json": `{"id": "1"}`,
+		"albums.yaml": "title: [",
+		"albums.csv":  "1,Blue Train",
+	} {
+		if _, err := loadSeed(writeSeed(t, name, data), false); err == nil {
+			t.Errorf("loadSeed(%s) succeeded", name)
+		}
+	}
+	if _, err := loadSeed(filepath.Join(t.TempDir(), "missing.json"), false); err == nil {
+		t.Error("loadSeed of a missing file succeeded")
+	}
+}
@@ -0,0 +1,233 @@
+package records_api
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `similarityWeights` blends the factors of `similarity` into one score. Weights are relative:
+// `artist=2,genre=1` counts the artist twice as much as the genres; a weight of 0 ignores a factor.
+type similarityWeights struct {
+	Artist float64 `json:"artist"`
+	Genre  float64 `json:"genre"`
+	Era    float64 `json:"era"`
+	Price  float64 `json:"price"`
+}
+
+func (w *similarityWeights) String() string {
+	return fmt.Sprintf("artist=%g,genre=%g,era=%g,price=%g", w.Artist, w.Genre, w.Era, w.Price)
+}
+
+// `Set` parses `name=weight` pairs; factors that are not mentioned keep their weight.
+func (w *similarityWeights) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		f, err := strconv.ParseFloat(weight, 64)
+		if !ok || err != nil || f < 0 || math.IsInf(f, 0) {
+			return fmt.Errorf("want name=weight with a weight of at least 0, got %q", pair)
+		}
+		switch name {
+		case "artist":
+			w.Artist = f
+		case "genre":
+			w.Genre = f
+		case "era":
+			w.Era = f
+		case "price":
+			w.Price = f
+		default:
+			return fmt.Errorf("unknown factor %q; want artist, genre, era or price", name)
+		}
+	}
+	return nil
+}
+
+func (w similarityWeights) total() float64 {
+	return w.Artist + w.Genre + w.Era + w.Price
+}
+
+// `similarityFactors` are the scores of one album against another, each between 0 and 1.
+type similarityFactors struct {
+	Artist float64 `json:"artist"`
+	Genre  float64 `json:"genre"`
+	Era    float64 `json:"era"`
+	Price  float64 `json:"price"`
+}
+
+// `similarity` scores `b` against `a`:
+//   - artist: 1 for the same artist, else 0;
+//   - genre: shared genre tags divided by all tags of both (Jaccard index);
+//   - era: 1 for the same year, falling linearly to 0 at `eraYears` apart, 0 if a year is unknown;
+//   - price: 1 minus the price difference relative to the higher price.
+func similarity(a, b album, eraYears int) similarityFactors {
+	var f similarityFactors
+	if strings.EqualFold(a.Artist, b.Artist) {
+		f.Artist = 1
+	}
+
+	genres := func(a album) []string {
+		tags := make([]string, len(a.Genres))
+		for i, g := range a.Genres {
+			tags[i] = strings.ToLower(strings.TrimSpace(g))
+		}
+		slices.Sort(tags)
+		return slices.Compact(tags)
+	}
+	ga, gb := genres(a), genres(b)
+	shared := 0
+	for _, g := range ga {
+		if _, found := slices.BinarySearch(gb, g); found {
+			shared++
+		}
+	}
+	if union := len(ga) + len(gb) - shared; union > 0 {
+		f.Genre = float64(shared) / float64(union)
+	}
+
+	if a.Year != 0 && b.Year != 0 && eraYears > 0 {
+		apart := math.Abs(float64(a.Year - b.Year))
+		f.Era = math.Max(0, 1-apart/float64(eraYears))
+	}
+
+	if higher := math.Max(a.Price, b.Price); higher > 0 {
+		f.Price = 1 - math.Abs(a.Price-b.Price)/higher
+	}
+	return f
+}
+
+func (f similarityFactors) score(w similarityWeights) float64 {
+	total := w.total()
+	if total == 0 {
+		return 0
+	}
+	return (w.Artist*f.Artist + w.Genre*f.Genre + w.Era*f.Era + w.Price*f.Price) / total
+}
+
+// `similarAlbum` is one recommendation. The factors are only sent in debug mode.
+type similarAlbum struct {
+	Album   any                `json:"album"`
+	Score   float64            `json:"score"`
+	Factors *similarityFactors `json:"factors,omitempty"`
+
+	album   album
+	factors similarityFactors
+}
+
+// `rankSimilar` returns the albums of `catalogue` with a positive score against `a`, best first.
+// Ties are broken by ID so the order never depends on the catalogue's order.
+func rankSimilar(a album, catalogue []album, w similarityWeights, eraYears int) []similarAlbum {
+	var ranked []similarAlbum
+	for _, b := range catalogue {
+		if b.ID == a.ID {
+			continue
+		}
+		factors := similarity(a, b, eraYears)
+		// Rounded so that float noise can't reorder equally similar albums.
+		score := math.Round(factors.score(w)*10000) / 10000
+		if score > 0 {
+			ranked = append(ranked, similarAlbum{Score: score, album: b, factors: factors})
+		}
+	}
+	slices.SortFunc(ranked, func(x, y similarAlbum) int {
+		if c := cmp.Compare(y.Score, x.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.album.ID, y.album.ID)
+	})
+	return ranked
+}
+
+// `similarCache` keeps the rankings computed for each album until the catalogue changes,
+// which it notices through the generation of the album store.
+type similarCache struct {
+	mu         sync.Mutex
+	generation uint64
+	rankings   map[string][]similarAlbum
+}
+
+func (c *similarCache) get(generation uint64, id string) ([]similarAlbum, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return nil, false
+	}
+	ranking, ok := c.rankings[id]
+	return ranking, ok
+}
+
+func (c *similarCache) set(generation uint64, id string, ranking []similarAlbum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case generation > c.generation || c.rankings == nil:
+		c.generation = generation
+		c.rankings = map[string][]similarAlbum{id: ranking}
+	case generation == c.generation:
+		c.rankings[id] = ranking
+	}
+}
+
+// `getSimilarAlbums` recommends albums like the one with the `id` parameter.
+// `?limit=` caps the number of results (default 5, at most 50) and `?debug=true` adds the per-factor scores.
+func (s *server) getSimilarAlbums(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "5"))
+	if err != nil || limit < 1 || limit > 50 {
+		writeProblem(c, problemBadRequest, "limit must be between 1 and 50")
+		return
+	}
+	debug, err := strconv.ParseBool(c.DefaultQuery("debug", "false"))
+	if err != nil {
+		writeProblem(c, problemBadRequest, "debug must be true or false")
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	// Read the generation first: if the catalogue changes while ranking, the result is stored
+	// under the old generation and recomputed on the next request.
+	generation := s.albums.Generation()
+	ranking, ok := s.similar.get(generation, id)
+	if !ok {
+		a, err := s.albums.Get(ctx, id)
+		if err != nil {
+			s.albumLookupFailed(c, err)
+			return
+		}
+		catalogue, err := s.albums.List(ctx)
+		if err != nil {
+			writeProblem(c, problemInternal, "could not list albums")
+			logError(c.Request.Context(), err)
+			return
+		}
+		ranking = rankSimilar(a, catalogue, s.cfg.SimilarWeights, s.cfg.SimilarEraYears)
+		s.similar.set(generation, id, ranking)
+	}
+
+	v := versionOf(c)
+	results := make([]similarAlbum, 0, min(limit, len(ranking)))
+	for _, r := range ranking[:min(limit, len(ranking))] {
+		r.Album = v.encodeAlbum(s.withComputed(r.album)[0])
+		if debug {
+			factors := r.factors
+			r.Factors = &factors
+		}
+		results = append(results, r)
+	}
+
+	response := gin.H{"similar": results}
+	if debug {
+		response["weights"] = s.cfg.SimilarWeights
+	}
+	s.setCacheControl(c)
+	c.IndentedJSON(http.StatusOK, response)
+}
@@ -0,0 +1,123 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSimilarityFactors(t *testing.T) {
+	a := album{ID: "a", Artist: "John Coltrane", Price: 20, Genres: []string{"Jazz", "hard bop"}, Year: 1960}
+	b := album{ID: "b", Artist: "john coltrane", Price: 10, Genres: []string{"jazz", "modal"}, Year: 1965}
+
+	got := similarity(a, b, 20)
+	want := similarityFactors{Artist: 1, Genre: 1.0 / 3, Era: 0.75, Price: 0.5}
+	if got != want {
+		t.Errorf("similarity = %+v; want %+v", got, want)
+	}
+
+	// Unknown years say nothing about the era.
+	b.Year = 0
+	if got := similarity(a, b, 20); got.Era != 0 {
+		t.Errorf("era with an unknown year = %g; want 0", got.Era)
+	}
+
+	w := similarityWeights{Artist: 1, Price: 1}
+	if got := want.score(w); got != 0.75 {
+		t.Errorf("score = %g; want 0.75", got)
+	}
+}
+
+func TestRankSimilarIsDeterministic(t *testing.T) {
+	a := album{ID: "1", Artist: "A", Price: 10}
+	catalogue := []album{
+		{ID: "4", Artist: "B", Price: 10},
+		a,
+		{ID: "2", Artist: "B", Price: 10},
+		{ID: "3", Artist: "A", Price: 10},
+	}
+	ranked := rankSimilar(a, catalogue, similarityWeights{Artist: 1, Price: 1}, 20)
+
+	var ids []string
+	for _, r := range ranked {
+		ids = append(ids, r.album.ID)
+	}
+	if len(ids) != 3 || ids[0] != "3" || ids[1] != "2" || ids[2] != "4" {
+		t.Errorf("ranking = %v; want [3 2 4], ties ordered by ID", ids)
+	}
+}
+
+type similarResponse struct {
+	Similar []struct {
+		Album   album              `json:"album"`
+		Score   float64            `json:"score"`
+		Factors *similarityFactors `json:"factors"`
+	} `json:"similar"`
+	Weights *similarityWeights `json:"weights"`
+}
+
+func getSimilar(t *testing.T, router http.Handler, path string) similarResponse {
+	w := serve(router, http.MethodGet, path, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", path, w.Code, w.Body)
+	}
+	var res similarResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return res
+}
+
+func TestSimilarEndpoint(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	res := getSimilar(t, router, "/v1/albums/1/similar")
+	if len(res.Similar) != 2 || res.Similar[0].Factors != nil || res.Weights != nil {
+		t.Fatalf("similar = %+v; want 2 albums without debug details", res)
+	}
+	// Blue Train (1957) is closer in era and price to Sarah Vaughan (1955) than to Jeru (1962).
+	if res.Similar[0].Album.ID != "3" {
+		t.Errorf("most similar = %s; want 3", res.Similar[0].Album.ID)
+	}
+
+	res = getSimilar(t, router, "/v1/albums/1/similar?debug=true&limit=1")
+	if len(res.Similar) != 1 || res.Similar[0].Factors == nil || res.Weights == nil {
+		t.Errorf("debug = %+v; want factors and weights", res)
+	}
+
+	if w := serve(router, http.MethodGet, "/albums/nope/similar", ""); w.Code != http.StatusNotFound {
+		t.Errorf("similar for a missing album = %d; want 404", w.Code)
+	}
+}
+
+func TestSimilarRecomputedOnChange(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+	getSimilar(t, router, "/albums/1/similar")
+
+	// A new album by the same artist from the same year must show up first straight away.
+	w := serve(router, http.MethodPost, "/albums", `{"id":"4","title":"Giant","artist":"Coltrane","price":56.99,"genres":["jazz","hard bop"],"year":1957}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	if res := getSimilar(t, router, "/albums/1/similar"); res.Similar[0].Album.ID != "4" {
+		t.Errorf("most similar after adding a close match = %s; want 4", res.Similar[0].Album.ID)
+	}
+
+	serve(router, http.MethodDelete, "/albums/4", "")
+	if res := getSimilar(t, router, "/albums/1/similar"); len(res.Similar) != 2 {
+		t.Errorf("after deleting the match: %d similar albums; want 2", len(res.Similar))
+	}
+}
+
+func TestSimilarWeightsFlag(t *testing.T) {
+	cfg, err := loadConfig([]string{"-similar-weights", "artist=0, price=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (similarityWeights{Artist: 0, Genre: 4, Era: 2, Price: 2}); cfg.SimilarWeights != want {
+		t.Errorf("weights = %+v; want %+v", cfg.SimilarWeights, want)
+	}
+	for _, bad := range []string{"tempo=1", "artist=-1", "artist", "artist=0,genre=0,era=0,price=0"} {
+		if _, err := loadConfig([]string{"-similar-weights", bad}); err == nil {
+			t.Errorf("-similar-weights %q was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package records_api
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+var (
+	errAlbumNotFound = errors.New("album not found")
+	errAlbumExists   = errors.New("album already exists")
+)
+
+// `albumStore` is where the albums are kept. Handlers only talk to this interface,
+// so the backing storage can change without touching them.
+type albumStore interface {
+	// `List` returns all albums in insertion order.
+	List(ctx context.Context) ([]album, error)
+	// `Get` returns the album with the given ID, or `errAlbumNotFound`.
+	Get(ctx context.Context, id string) (album, error)
+	// `Create` adds a new album. An empty ID is replaced by a generated one.
+	// Returns `errAlbumExists` if the ID is already taken.
+	Create(ctx context.Context, a album) (album, error)
+	// `Update` replaces the album with the same ID, or returns `errAlbumNotFound`.
+	Update(ctx context.Context, a album) (album, error)
+	// `Delete` removes the album with the given ID, or returns `errAlbumNotFound`.
+	Delete(ctx context.Context, id string) error
+	// `Transaction` runs `fn` against `tx`, a view of the store whose changes take effect all at once
+	// if `fn` returns nil and are discarded if it returns an error. Other writes wait until it is done.
+	Transaction(ctx context.Context, fn func(tx albumStore) error) error
+}
+
+// `memoryStore` keeps albums in a slice guarded by a mutex. Nothing survives a restart.
+type memoryStore struct {
+	mu     sync.RWMutex
+	albums []album
+	nextID int
+}
+
+func newMemoryStore(seed []album) *memoryStore {
+	s := &memoryStore{albums: make([]album, 0, len(seed)), nextID: 1}
+	for _, a := range seed {
+		s.albums = append(s.albums, a)
+		s.bumpNextID(a.ID)
+	}
+	return s
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]album(nil), s.albums...), nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.indexOf(id); i >= 0 {
+		return s.albums[i], nil
+	}
+	return album{}, errAlbumNotFound
+}
+
+func (s *memoryStore) Create(ctx context.Context, a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.ID == "" {
+		a.ID = strconv.Itoa(s.nextID)
+	}
+	if s.indexOf(a.ID) >= 0 {
+		return album{}, errAlbumExists
+	}
+	s.albums = append(s.albums, a)
+	s.bumpNextID(a.ID)
+	return a, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, a album) (album, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(a.ID)
+	if i < 0 {
+		return album{}, errAlbumNotFound
+	}
+	s.albums[i] = a
+	return a, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(id)
+	if i < 0 {
+		return errAlbumNotFound
+	}
+	s.albums = append(s.albums[:i], s.albums[i+1:]...)
+	return nil
+}
+
+// The transaction works on a copy of the albums, which replaces the original on success.
+func (s *memoryStore) Transaction(ctx context.Context, fn func(tx albumStore) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryStore{albums: slices.Clone(s.albums), nextID: s.nextID}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.albums, s.nextID = tx.albums, tx.nextID
+	return nil
+}
+
+// Must be called with `mu` held.
+func (s *memoryStore) indexOf(id string) int {
+	for i, a := range s.albums {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// `bumpNextID` keeps generated IDs clear of numeric IDs chosen by clients. Must be called with `mu` held.
+func (s *memoryStore) bumpNextID(id string) {
+	if n, err := strconv.Atoi(id); err == nil && n >= s.nextID {
+		s.nextID = n + 1
+	}
+}
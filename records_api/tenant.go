@@ -0,0 +1,428 @@
+package records_api
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tenantHeader = "X-Tenant-ID"
+
+// Tenant IDs double as subdomains and directory names, so they are limited to a DNS label.
+var tenantID = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$`)
+
+var (
+	errTenantNotFound = errors.New("tenant not found")
+	errTenantExists   = errors.New("tenant already exists")
+)
+
+// `tenant` is one record shop sharing the deployment.
+type tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name" binding:"required,max=100"`
+	Suspended bool      `json:"suspended"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// `tenantRegistry` knows the tenants. With a `path`, it saves them there after every change.
+type tenantRegistry struct {
+	mu      sync.RWMutex
+	tenants map[string]tenant
+	path    string
+}
+
+func loadTenantRegistry(path string) (*tenantRegistry, error) {
+	r := &tenantRegistry{tenants: map[string]tenant{}, path: path}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tenants []tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, t := range tenants {
+		r.tenants[t.ID] = t
+	}
+	return r, nil
+}
+
+// `List` returns the tenants in order of their ID.
+func (r *tenantRegistry) List() []tenant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.listLocked()
+}
+
+func (r *tenantRegistry) listLocked() []tenant {
+	tenants := make([]tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		tenants = append(tenants, t)
+	}
+	slices.SortFunc(tenants, func(a, b tenant) int { return strings.Compare(a.ID, b.ID) })
+	return tenants
+}
+
+func (r *tenantRegistry) Get(id string) (tenant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.tenants[id]
+	if !ok {
+		return tenant{}, errTenantNotFound
+	}
+	return t, nil
+}
+
+func (r *tenantRegistry) Create(t tenant) (tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tenants[t.ID]; ok {
+		return tenant{}, errTenantExists
+	}
+	t.CreatedAt = time.Now().UTC()
+	r.tenants[t.ID] = t
+	if err := r.saveLocked(); err != nil {
+		delete(r.tenants, t.ID)
+		return tenant{}, err
+	}
+	return t, nil
+}
+
+func (r *tenantRegistry) SetSuspended(id string, suspended bool) (tenant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tenants[id]
+	if !ok {
+		return tenant{}, errTenantNotFound
+	}
+	previous := t
+	t.Suspended = suspended
+	r.tenants[id] = t
+	if err := r.saveLocked(); err != nil {
+		r.tenants[id] = previous
+		return tenant{}, err
+	}
+	return t, nil
+}
+
+func (r *tenantRegistry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.listLocked(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(r.path, data)
+}
+
+// `tenantRouter` serves every tenant from its own `server`, with its own album store, cache, reviews
+// and idempotency keys, so no request can reach another tenant's data. The servers are created on
+// first use. `/admin/tenants` manages the tenants themselves.
+type tenantRouter struct {
+	cfg      config
+	registry *tenantRegistry
+	admin    http.Handler
+
+	mu      sync.Mutex
+	servers map[string]*tenantServer
+}
+
+// `tenantServer` is what a tenant's requests go through.
+type tenantServer struct {
+	handler http.Handler
+	limiter *tokenBucket
+	close   func() error
+
+	requests  atomic.Uint64
+	throttled atomic.Uint64
+}
+
+// `tenantStats` are the per-tenant counters shown by `GET /admin/tenants`.
+type tenantStats struct {
+	Requests  uint64 `json:"requests"`
+	Throttled uint64 `json:"throttled"`
+}
+
+func newTenantRouter(cfg config) (*tenantRouter, error) {
+	var registryPath string
+	if cfg.StoreDir != "" {
+		registryPath = filepath.Join(cfg.StoreDir, "tenants.json")
+	}
+	registry, err := loadTenantRegistry(registryPath)
+	if err != nil {
+		return nil, err
+	}
+	t := &tenantRouter{cfg: cfg, registry: registry, servers: map[string]*tenantServer{}}
+
+	admin := newEngine(cfg)
+	group := admin.Group("/admin/tenants", requireAdmin(cfg))
+	group.GET("", t.getTenants)
+	group.POST("", t.postTenant)
+	group.GET("/:tenant", t.getTenant)
+	group.POST("/:tenant/suspend", t.suspendTenant(true))
+	group.POST("/:tenant/resume", t.suspendTenant(false))
+	registerDebugRoutes(admin, cfg, newDiagnostics(cfg.Debug))
+	t.admin = admin
+	return t, nil
+}
+
+// `isTenantAdminPath` reports whether `path` belongs to the whole deployment rather than a tenant:
+// the tenant admin endpoints and the diagnostics of the process.
+func isTenantAdminPath(path string) bool {
+	for _, prefix := range []string{"/admin/tenants", "/admin/debug", "/debug"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Responses differ by tenant, so shared caches must not hand one tenant's response to another.
+	w.Header().Add("Vary", tenantHeader)
+
+	if isTenantAdminPath(r.URL.Path) {
+		t.admin.ServeHTTP(w, r)
+		return
+	}
+
+	id, err := resolveTenant(r, t.cfg.TenantDomain)
+	if err != nil {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			// Browsers send preflight requests without the custom header; they need no tenant.
+			t.admin.ServeHTTP(w, r)
+			return
+		}
+		newProblem(problemBadRequest, r, err.Error()).write(w)
+		return
+	}
+	tn, err := t.registry.Get(id)
+	if err != nil {
+		newProblem(problemNotFound, r, fmt.Sprintf("unknown tenant %q", id)).write(w)
+		return
+	}
+	if tn.Suspended {
+		newProblem(problemForbidden, r, fmt.Sprintf("tenant %q is suspended", id)).write(w)
+		return
+	}
+	ts, err := t.server(id)
+	if err != nil {
+		newProblem(problemInternal, r, "could not open the tenant's catalogue").write(w)
+		logger.Printf("tenant %s: %v", id, err)
+		return
+	}
+
+	ts.requests.Add(1)
+	if ok, wait := ts.limiter.Allow(time.Now()); !ok {
+		ts.throttled.Add(1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		newProblem(problemRateLimited, r, "too many requests for this tenant; retry later").write(w)
+		return
+	}
+	ts.handler.ServeHTTP(w, r)
+}
+
+// `resolveTenant` takes the tenant from the `X-Tenant-ID` header or, with a `domain` such as
+// `records.example.com`, from the subdomain in `shop.records.example.com`. If both are given, they must agree.
+func resolveTenant(r *http.Request, domain string) (string, error) {
+	fromHeader := strings.ToLower(strings.TrimSpace(r.Header.Get(tenantHeader)))
+
+	var fromHost string
+	if domain != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain)); ok && !strings.Contains(sub, ".") {
+			fromHost = sub
+		}
+	}
+
+	id := cmp.Or(fromHeader, fromHost)
+	switch {
+	case fromHeader != "" && fromHost != "" && fromHeader != fromHost:
+		return "", fmt.Errorf("%s %q does not match the subdomain %q", tenantHeader, fromHeader, fromHost)
+	case id == "":
+		return "", fmt.Errorf("no tenant: send an %s header or use a tenant subdomain", tenantHeader)
+	case !tenantID.MatchString(id):
+		return "", fmt.Errorf("invalid tenant %q", id)
+	}
+	return id, nil
+}
+
+// `server` returns the tenant's server, creating it on first use.
+func (t *tenantRouter) server(id string) (*tenantServer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ts, ok := t.servers[id]; ok {
+		return ts, nil
+	}
+	var dir string
+	if t.cfg.StoreDir != "" {
+		dir = filepath.Join(t.cfg.StoreDir, "tenants", id)
+	}
+	// New shops start with an empty catalogue.
+	store, closeStore, err := openAlbumStore(t.cfg, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := newServer(t.cfg, store)
+	s.tenant = id
+	ts := &tenantServer{
+		handler: newRouter(s),
+		limiter: newTokenBucket(t.cfg.TenantRateLimit, t.cfg.TenantRateBurst),
+		close:   closeStore,
+	}
+	t.servers[id] = ts
+	return ts, nil
+}
+
+// `Close` closes the album stores of all tenants.
+func (t *tenantRouter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var errs []error
+	for id, ts := range t.servers {
+		if err := ts.close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *tenantRouter) stats(id string) tenantStats {
+	t.mu.Lock()
+	ts, ok := t.servers[id]
+	t.mu.Unlock()
+	if !ok {
+		return tenantStats{}
+	}
+	return tenantStats{Requests: ts.requests.Load(), Throttled: ts.throttled.Load()}
+}
+
+type tenantResponse struct {
+	tenant
+	Stats tenantStats `json:"stats"`
+}
+
+func (t *tenantRouter) getTenants(c *gin.Context) {
+	tenants := t.registry.List()
+	res := make([]tenantResponse, len(tenants))
+	for i, tn := range tenants {
+		res[i] = tenantResponse{tn, t.stats(tn.ID)}
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+func (t *tenantRouter) getTenant(c *gin.Context) {
+	tn, err := t.registry.Get(c.Param("tenant"))
+	if err != nil {
+		writeProblem(c, problemNotFound, "tenant not found")
+		return
+	}
+	c.IndentedJSON(http.StatusOK, tenantResponse{tn, t.stats(tn.ID)})
+}
+
+// `postTenant` adds a tenant: `{"id": "blue-note", "name": "Blue Note Records"}`.
+func (t *tenantRouter) postTenant(c *gin.Context) {
+	var tn tenant
+	if err := tracedBind(c.Request.Context(), c.ShouldBindJSON)(&tn); err != nil {
+		bindFailed(c, err, t.cfg.MaxBodyBytes)
+		return
+	}
+	if !tenantID.MatchString(tn.ID) {
+		writeProblem(c, problemValidation, "id must be 1 to 32 lowercase letters, digits and inner hyphens",
+			fieldProblem{Field: "id", Detail: "id must be 1 to 32 lowercase letters, digits and inner hyphens"})
+		return
+	}
+	tn.Suspended = false
+	created, err := t.registry.Create(tn)
+	if errors.Is(err, errTenantExists) {
+		writeProblem(c, problemConflict, "tenant already exists")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not create tenant")
+		logError(c.Request.Context(), err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, tenantResponse{tenant: created})
+}
+
+// `suspendTenant` suspends or resumes a tenant. A suspended tenant's requests are refused with 403;
+// its data is kept.
+func (t *tenantRouter) suspendTenant(suspended bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tn, err := t.registry.SetSuspended(c.Param("tenant"), suspended)
+		if errors.Is(err, errTenantNotFound) {
+			writeProblem(c, problemNotFound, "tenant not found")
+			return
+		}
+		if err != nil {
+			writeProblem(c, problemInternal, "could not update tenant")
+			logError(c.Request.Context(), err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, tenantResponse{tn, t.stats(tn.ID)})
+	}
+}
+
+// `tokenBucket` allows `rate` requests per second on average, with bursts of up to `burst`.
+// A rate of zero allows everything.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// `Allow` takes a token if there is one, or reports how long until there will be.
+func (b *tokenBucket) Allow(now time.Time) (bool, time.Duration) {
+	if b.rate <= 0 {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
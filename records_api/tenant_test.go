@@ -0,0 +1,188 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTenantTestRouter(t *testing.T, cfg config, tenants ...string) *tenantRouter {
+	cfg.MultiTenant = true
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminToken = "test-token"
+	router, err := newTenantRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { router.Close() })
+	for _, id := range tenants {
+		body := `{"id": "` + id + `", "name": "Shop ` + id + `"}`
+		if w := adminRequest(router, http.MethodPost, "/admin/tenants", "application/json", []byte(body)); w.Code != http.StatusCreated {
+			t.Fatalf("create tenant %s = %d %s", id, w.Code, w.Body)
+		}
+	}
+	return router
+}
+
+// `serveTenant` is `serve` for one tenant, chosen by header.
+func serveTenant(router http.Handler, tenantID, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tenantHeader, tenantID)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestTenantIsolation(t *testing.T) {
+	router := newTenantTestRouter(t, defaultConfig(), "shop-a", "shop-b")
+
+	w := serveTenant(router, "shop-a", http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`,
+		idempotencyKeyHeader, "key-1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create in shop-a = %d %s", w.Code, w.Body)
+	}
+	var created album
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	// New tenants start empty, and shop-b sees none of shop-a's albums by any route.
+	for _, path := range []string{"/albums", "/v2/albums", "/albums/" + created.ID, "/albums/" + created.ID + "/reviews", "/admin/albums"} {
+		w := serveTenant(router, "shop-b", http.MethodGet, path, "", "Authorization", "Bearer test-token")
+		if strings.Contains(w.Body.String(), "Giant Steps") {
+			t.Errorf("shop-b GET %s = %d %s", path, w.Code, w.Body)
+		}
+	}
+	if w := serveTenant(router, "shop-b", http.MethodGet, "/albums/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("shop-b GET of shop-a's album = %d; want 404", w.Code)
+	}
+	if w := serveTenant(router, "shop-b", http.MethodDelete, "/albums/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("shop-b DELETE of shop-a's album = %d; want 404", w.Code)
+	}
+
+	// Idempotency keys are per tenant too: the same key in shop-b is a new request, not a replay of shop-a's.
+	w = serveTenant(router, "shop-b", http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`,
+		idempotencyKeyHeader, "key-1")
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("same idempotency key in shop-b = %d %v", w.Code, w.Header())
+	}
+
+	// So are the cache and its statistics.
+	var stats struct{ Hits, Misses uint64 }
+	json.Unmarshal(serveTenant(router, "shop-a", http.MethodGet, "/stats/cache", "", "Authorization", "Bearer test-token").Body.Bytes(), &stats)
+	before := stats
+	serveTenant(router, "shop-b", http.MethodGet, "/albums", "")
+	serveTenant(router, "shop-b", http.MethodGet, "/albums", "")
+	json.Unmarshal(serveTenant(router, "shop-a", http.MethodGet, "/stats/cache", "", "Authorization", "Bearer test-token").Body.Bytes(), &stats)
+	if stats != before {
+		t.Errorf("shop-a cache stats changed from %+v to %+v by shop-b's reads", before, stats)
+	}
+
+	if vary := w.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), tenantHeader) {
+		t.Errorf("Vary = %v; want %s", vary, tenantHeader)
+	}
+}
+
+func TestTenantResolution(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.TenantDomain = "records.example.com"
+	router := newTenantTestRouter(t, cfg, "shop-a")
+	serveTenant(router, "shop-a", http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`)
+
+	tests := []struct {
+		host, header string
+		want         int
+	}{
+		{"shop-a.records.example.com", "", http.StatusOK},
+		{"Shop-A.records.example.com:8443", "", http.StatusOK},
+		{"shop-a.records.example.com", "shop-a", http.StatusOK},
+		{"records.example.com", "shop-a", http.StatusOK},
+		{"shop-a.records.example.com", "shop-b", http.StatusBadRequest},
+		{"records.example.com", "", http.StatusBadRequest},
+		{"x.shop-a.records.example.com", "", http.StatusBadRequest},
+		{"records.example.com", "../shop-a", http.StatusBadRequest},
+		{"shop-z.records.example.com", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/albums", nil)
+		req.Host = tt.host
+		if tt.header != "" {
+			req.Header.Set(tenantHeader, tt.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("GET %s with %s %q = %d %s; want %d", tt.host, tenantHeader, tt.header, w.Code, w.Body, tt.want)
+		}
+	}
+}
+
+func TestTenantAdmin(t *testing.T) {
+	router := newTenantTestRouter(t, defaultConfig(), "shop-a")
+
+	for _, body := range []string{`{"id": "Shop A", "name": "x"}`, `{"id": "shop-b"}`, `{"id": "-b", "name": "x"}`} {
+		if w := adminRequest(router, http.MethodPost, "/admin/tenants", "application/json", []byte(body)); w.Code != http.StatusBadRequest {
+			t.Errorf("create %s = %d; want 400", body, w.Code)
+		}
+	}
+	if w := adminRequest(router, http.MethodPost, "/admin/tenants", "application/json", []byte(`{"id": "shop-a", "name": "x"}`)); w.Code != http.StatusConflict {
+		t.Errorf("create existing tenant = %d; want 409", w.Code)
+	}
+	if w := serveTenant(router, "shop-a", http.MethodGet, "/admin/tenants", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("tenant list without credentials = %d; want 401", w.Code)
+	}
+
+	if w := adminRequest(router, http.MethodPost, "/admin/tenants/shop-a/suspend", "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"suspended": true`) {
+		t.Fatalf("suspend = %d %s", w.Code, w.Body)
+	}
+	if w := serveTenant(router, "shop-a", http.MethodGet, "/albums", ""); w.Code != http.StatusForbidden {
+		t.Errorf("suspended tenant = %d; want 403", w.Code)
+	}
+	adminRequest(router, http.MethodPost, "/admin/tenants/shop-a/resume", "", nil)
+	if w := serveTenant(router, "shop-a", http.MethodGet, "/albums", ""); w.Code != http.StatusOK {
+		t.Errorf("resumed tenant = %d; want 200", w.Code)
+	}
+
+	w := adminRequest(router, http.MethodGet, "/admin/tenants/shop-a", "", nil)
+	// Requests refused while suspended never reach the tenant and are not counted.
+	if !strings.Contains(w.Body.String(), `"requests": 1`) {
+		t.Errorf("tenant = %s; want 1 request counted", w.Body)
+	}
+}
+
+func TestTenantRateLimit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.TenantRateLimit, cfg.TenantRateBurst = 1, 2
+	router := newTenantTestRouter(t, cfg, "shop-a", "shop-b")
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := serveTenant(router, "shop-a", http.MethodGet, "/albums", "")
+		if w.Code != want {
+			t.Errorf("request %d = %d; want %d", i, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Retry-After = %q; want 1", w.Header().Get("Retry-After"))
+		}
+	}
+	// Another tenant has its own budget.
+	if w := serveTenant(router, "shop-b", http.MethodGet, "/albums", ""); w.Code != http.StatusOK {
+		t.Errorf("shop-b = %d; want 200", w.Code)
+	}
+
+	b := newTokenBucket(2, 1)
+	now := time.Now()
+	if ok, _ := b.Allow(now); !ok {
+		t.Error("first request refused")
+	}
+	if ok, wait := b.Allow(now); ok || wait != 500*time.Millisecond {
+		t.Errorf("second request = %v, wait %s; want refused for 500ms", ok, wait)
+	}
+	if ok, _ := b.Allow(now.Add(500 * time.Millisecond)); !ok {
+		t.Error("request after refill refused")
+	}
+}
@@ -0,0 +1,182 @@
+package records_api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `tlsConfig` returns the TLS configuration of the server, or nil to serve plain HTTP.
+// Certificates come from `-tls-cert` and `-tls-key`; in `-dev` mode without them, an ephemeral CA and
+// server certificate are generated, and the CA is written to `-dev-ca-out` so clients can trust it.
+func tlsConfig(cfg config) (*tls.Config, error) {
+	var certificate tls.Certificate
+	switch {
+	case cfg.TLSCert != "":
+		var err error
+		if certificate, err = tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+	case cfg.Dev:
+		ca, err := newDevCA()
+		if err != nil {
+			return nil, err
+		}
+		if certificate, err = ca.issue(pkix.Name{CommonName: "records_api"}, devHosts(cfg.Addr), x509.ExtKeyUsageServerAuth); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(cfg.DevCAOut), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(cfg.DevCAOut, ca.pem(), 0o644); err != nil {
+			return nil, fmt.Errorf("write dev CA: %w", err)
+		}
+		logger.Printf("serving HTTPS with an ephemeral certificate; trust %s to connect", cfg.DevCAOut)
+	default:
+		if cfg.ClientCA != "" {
+			return nil, errors.New("client-ca requires HTTPS: set tls-cert and tls-key, or dev")
+		}
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCA != "" {
+		bundle, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA bundle: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("client CA bundle %s has no PEM certificates", cfg.ClientCA)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.ClientCertOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return config, nil
+}
+
+const clientSubjectKey = "clientSubject"
+
+// `identifyClient` puts the subject of the verified client certificate of the request in the context,
+// where handlers and middleware find it with `clientSubject`. With `-client-ca`, it names the client.
+func identifyClient(c *gin.Context) {
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		c.Set(clientSubjectKey, c.Request.TLS.VerifiedChains[0][0].Subject)
+	}
+	c.Next()
+}
+
+// `clientSubject` returns the subject of the verified client certificate of the request; see `identifyClient`.
+// It reports false for plain HTTP and for TLS connections without a client certificate.
+func clientSubject(c *gin.Context) (pkix.Name, bool) {
+	subject, ok := c.Get(clientSubjectKey)
+	if !ok {
+		return pkix.Name{}, false
+	}
+	return subject.(pkix.Name), true
+}
+
+// `devHosts` are the names the dev certificate is valid for: localhost and the host of `addr`.
+func devHosts(addr string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// `devCA` is a throwaway certificate authority for development and tests. Its key only lives in memory.
+type devCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newDevCA() (*devCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certificateTemplate(pkix.Name{CommonName: "records_api dev CA"}, 7*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &devCA{cert: cert, key: key}, nil
+}
+
+// `issue` creates a certificate for `subject` signed by the CA. `hosts` become DNS or IP subject
+// alternative names, and `usage` says whether it is a server or a client certificate.
+func (ca *devCA) issue(subject pkix.Name, hosts []string, usage x509.ExtKeyUsage) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template, err := certificateTemplate(subject, 7*24*time.Hour)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}, nil
+}
+
+// `pem` returns the CA certificate in PEM form, as clients expect it in their trust store.
+func (ca *devCA) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+func certificateTemplate(subject pkix.Name, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		// Allow for clocks that are slightly behind.
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(validity),
+	}, nil
+}
@@ -0,0 +1,127 @@
+package records_api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `startTLSServer` serves `handler` with the TLS configuration built from `cfg`.
+func startTLSServer(t *testing.T, cfg config, handler http.Handler) *httptest.Server {
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(handler)
+	ts.TLS = tlsCfg
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func tlsClient(t *testing.T, caFile string, certificates ...tls.Certificate) *http.Client {
+	bundle, err := os.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(bundle)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates}}}
+}
+
+func TestDevTLS(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.Dev = true
+	cfg.DevCAOut = filepath.Join(t.TempDir(), "ca.pem")
+	ts := startTLSServer(t, cfg, newRouter(newServer(cfg, newMemoryStore(albums))))
+
+	// The server certificate verifies against the CA written to `DevCAOut`.
+	res, err := tlsClient(t, cfg.DevCAOut).Get(ts.URL + "/albums/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Strict-Transport-Security") == "" {
+		t.Errorf("GET over HTTPS = %d %v; want 200 with HSTS", res.StatusCode, res.Header)
+	}
+
+	if _, err := http.Get(ts.URL + "/albums/1"); err == nil {
+		t.Error("the dev certificate was trusted without its CA")
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	clientCA, err := newDevCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "clients.pem"), clientCA.pem(), 0o644)
+	clientCert, err := clientCA.issue(pkix.Name{CommonName: "billing", Organization: []string{"records"}}, nil, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Dev = true
+	cfg.DevCAOut = filepath.Join(dir, "ca.pem")
+	cfg.ClientCA = filepath.Join(dir, "clients.pem")
+
+	whoami := gin.New()
+	whoami.GET("/whoami", identifyClient, func(c *gin.Context) {
+		subject, ok := clientSubject(c)
+		if !ok {
+			c.String(http.StatusUnauthorized, "anonymous")
+			return
+		}
+		c.String(http.StatusOK, subject.CommonName)
+	})
+
+	ts := startTLSServer(t, cfg, whoami)
+	res, err := tlsClient(t, cfg.DevCAOut, clientCert).Get(ts.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "billing" {
+		t.Errorf("subject seen by the handler = %q; want billing", body)
+	}
+
+	if res, err := tlsClient(t, cfg.DevCAOut).Get(ts.URL + "/whoami"); err == nil {
+		res.Body.Close()
+		t.Error("request without a client certificate was accepted")
+	}
+
+	// With optional client certificates, anonymous clients get through but have no subject.
+	cfg.ClientCertOptional = true
+	ts = startTLSServer(t, cfg, whoami)
+	res, err = tlsClient(t, cfg.DevCAOut).Get(ts.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous client = %d; want 401 from the handler", res.StatusCode)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	if _, err := loadConfig([]string{"-tls-cert", "cert.pem"}); err == nil {
+		t.Error("tls-cert without tls-key was accepted")
+	}
+	cfg := defaultConfig()
+	cfg.ClientCA = "clients.pem"
+	if _, err := tlsConfig(cfg); err == nil {
+		t.Error("client-ca over plain HTTP was accepted")
+	}
+}
@@ -0,0 +1,302 @@
+package records_api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests are traced following W3C Trace Context (https://www.w3.org/TR/trace-context/): a request with a
+// `traceparent` header continues the caller's trace, and any other request starts a new one. Each request
+// gets a span, with child spans for decoding and validating its body and for every call to the album store.
+// The response names the request's span in a `traceresponse` header, shaped like `traceparent`.
+const (
+	traceparentHeader   = "traceparent"
+	tracestateHeader    = "tracestate"
+	traceresponseHeader = "traceresponse"
+
+	// `traceIDKey` is where the trace ID is kept in the gin context, for the access log.
+	traceIDKey = "traceID"
+)
+
+// version-traceid-parentid-flags, all lowercase hex. Version ff is invalid.
+var traceparent = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+const traceFlagSampled = 0x01
+
+// `spanSink` receives every finished span of a sampled trace.
+type spanSink interface {
+	Export(s spanRecord)
+}
+
+// `spanRecord` is a finished span as it is exported.
+type spanRecord struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	DurationMS   float64        `json:"durationMs"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	TraceState   string         `json:"traceState,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// `span` times one piece of work. The methods of a nil span do nothing, so code can be traced
+// whether or not it runs within a request.
+type span struct {
+	sink       spanSink
+	traceID    string
+	spanID     string
+	parentID   string
+	traceState string
+	sampled    bool
+	name       string
+	start      time.Time
+
+	mu         sync.Mutex
+	attributes map[string]any
+	err        string
+}
+
+type spanContextKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanContextKey{}).(*span)
+	return s
+}
+
+// `startSpan` starts a child of the span in `ctx`. Without one, it returns `ctx` and a nil span.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &span{
+		sink: parent.sink, traceID: parent.traceID, spanID: newSpanID(), parentID: parent.spanID,
+		traceState: parent.traceState, sampled: parent.sampled, name: name, start: time.Now(),
+	}
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+func (s *span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]any{}
+	}
+	s.attributes[key] = value
+}
+
+// `End` finishes the span, marking it as failed if `err` is not nil, and exports it.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if err != nil && s.err == "" {
+		s.err = err.Error()
+	}
+	record := spanRecord{
+		TraceID: s.traceID, SpanID: s.spanID, ParentSpanID: s.parentID, Name: s.name,
+		Start: s.start, End: end, DurationMS: float64(end.Sub(s.start).Microseconds()) / 1000,
+		Attributes: s.attributes, TraceState: s.traceState, Error: s.err,
+	}
+	s.mu.Unlock()
+
+	if s.sampled && s.sink != nil {
+		s.sink.Export(record)
+	}
+}
+
+// `traceparent` is the header value that names this span as the parent of others.
+func (s *span) traceparent() string {
+	flags := 0
+	if s.sampled {
+		flags = traceFlagSampled
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", s.traceID, s.spanID, flags)
+}
+
+// `traceRequests` starts a span for every request, continuing the trace of a valid `traceparent` header.
+func traceRequests(sink spanSink) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := &span{sink: sink, spanID: newSpanID(), sampled: true, start: time.Now()}
+		if m := traceparent.FindStringSubmatch(c.GetHeader(traceparentHeader)); m != nil && validTraceparent(m) {
+			var flags byte
+			fmt.Sscanf(m[4], "%02x", &flags)
+			s.traceID, s.parentID, s.sampled = m[2], m[3], flags&traceFlagSampled != 0
+			// `tracestate` is only meaningful as part of the trace it came with.
+			s.traceState = c.GetHeader(tracestateHeader)
+		} else {
+			s.traceID = newTraceID()
+		}
+
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), spanContextKey{}, s))
+		c.Set(traceIDKey, s.traceID)
+		c.Header(traceresponseHeader, s.traceparent())
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched route"
+		}
+		s.name = c.Request.Method + " " + route
+		s.SetAttribute("http.method", c.Request.Method)
+		s.SetAttribute("http.route", c.FullPath())
+		s.SetAttribute("http.target", c.Request.URL.RequestURI())
+		s.SetAttribute("http.status_code", c.Writer.Status())
+		var err error
+		if c.Writer.Status() >= 500 {
+			err = fmt.Errorf("%d %s", c.Writer.Status(), http.StatusText(c.Writer.Status()))
+		}
+		s.End(err)
+	}
+}
+
+// `validTraceparent` applies the rules the regular expression can't express.
+func validTraceparent(m []string) bool {
+	version, traceID, parentID := m[1], m[2], m[3]
+	if version == "ff" || (version == "00" && m[5] != "") {
+		return false
+	}
+	return traceID != "00000000000000000000000000000000" && parentID != "0000000000000000"
+}
+
+func newTraceID() string { return randomHex(16) }
+
+func newSpanID() string { return randomHex(8) }
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// `tracedBind` puts a `bind` function, such as `c.ShouldBindJSON`, into a span of its own, so that decoding
+// and validating a request body shows up separately from the rest of the handler.
+func tracedBind(ctx context.Context, bind func(obj any) error) func(obj any) error {
+	return func(obj any) error {
+		_, s := startSpan(ctx, "validate")
+		err := bind(obj)
+		s.SetAttribute("type", fmt.Sprintf("%T", obj))
+		s.End(err)
+		return err
+	}
+}
+
+// `logError` logs an error that failed a request, with the request's trace and span IDs.
+func logError(ctx context.Context, err error) {
+	if s := spanFromContext(ctx); s != nil {
+		logger.Printf("trace=%s span=%s %v", s.traceID, s.spanID, err)
+		return
+	}
+	logger.Println(err)
+}
+
+// `accessLog` formats gin's request log like its default format, followed by the trace ID.
+func accessLog(p gin.LogFormatterParams) string {
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v trace=%v\n%s",
+		p.TimeStamp.Format("2006/01/02 - 15:04:05"), p.StatusCode, p.Latency, p.ClientIP,
+		p.Method, p.Path, p.Keys[traceIDKey], p.ErrorMessage)
+}
+
+// `tracedStore` records a span for every call to the album store behind it.
+type tracedStore struct {
+	backend albumStore
+}
+
+func (s tracedStore) List(ctx context.Context) ([]album, error) {
+	ctx, sp := startSpan(ctx, "store.List")
+	albums, err := s.backend.List(ctx)
+	sp.SetAttribute("albums", len(albums))
+	sp.End(err)
+	return albums, err
+}
+
+func (s tracedStore) Get(ctx context.Context, id string) (album, error) {
+	ctx, sp := startSpan(ctx, "store.Get")
+	sp.SetAttribute("album.id", id)
+	a, err := s.backend.Get(ctx, id)
+	sp.End(err)
+	return a, err
+}
+
+func (s tracedStore) Create(ctx context.Context, a album) (album, error) {
+	ctx, sp := startSpan(ctx, "store.Create")
+	created, err := s.backend.Create(ctx, a)
+	sp.SetAttribute("album.id", created.ID)
+	sp.End(err)
+	return created, err
+}
+
+func (s tracedStore) Update(ctx context.Context, a album) (album, error) {
+	ctx, sp := startSpan(ctx, "store.Update")
+	sp.SetAttribute("album.id", a.ID)
+	updated, err := s.backend.Update(ctx, a)
+	sp.End(err)
+	return updated, err
+}
+
+func (s tracedStore) Delete(ctx context.Context, id string) error {
+	ctx, sp := startSpan(ctx, "store.Delete")
+	sp.SetAttribute("album.id", id)
+	err := s.backend.Delete(ctx, id)
+	sp.End(err)
+	return err
+}
+
+func (s tracedStore) Transaction(ctx context.Context, fn func(tx albumStore) error) error {
+	ctx, sp := startSpan(ctx, "store.Transaction")
+	err := s.backend.Transaction(ctx, func(tx albumStore) error { return fn(tracedStore{tx}) })
+	sp.End(err)
+	return err
+}
+
+// `jsonLinesSink` writes every span as a line of JSON, e.g. to a file that tools can read later.
+type jsonLinesSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *jsonLinesSink) Export(record spanRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Printf("trace: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		logger.Printf("trace: %v", err)
+	}
+}
+
+// `openSpanSink` opens the file at `path` for appending spans, or returns a nil sink if `path` is empty.
+// The returned function closes the file.
+func openSpanSink(path string) (spanSink, func() error, error) {
+	if path == "" {
+		return nil, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open trace file: %w", err)
+	}
+	return &jsonLinesSink{w: f}, f.Close, nil
+}
@@ -0,0 +1,153 @@
+package records_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// `recordingSink` keeps exported spans for tests to inspect.
+type recordingSink struct {
+	mu    sync.Mutex
+	spans []spanRecord
+}
+
+func (s *recordingSink) Export(record spanRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = append(s.spans, record)
+}
+
+func (s *recordingSink) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, sp := range s.spans {
+		names = append(names, sp.Name)
+	}
+	return names
+}
+
+func newTraceTestRouter(t *testing.T) (http.Handler, *recordingSink) {
+	sink := &recordingSink{}
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.CacheSize = 0
+	cfg.spans = sink
+	return newRouter(newServer(cfg, newMemoryStore(albums))), sink
+}
+
+func TestTraceContinuesIncomingTrace(t *testing.T) {
+	router, sink := newTraceTestRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/albums/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=abc")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	response := w.Header().Get("traceresponse")
+	if !strings.HasPrefix(response, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(response, "-01") {
+		t.Fatalf("traceresponse = %q; want the incoming trace ID", response)
+	}
+	var root *spanRecord
+	for i, sp := range sink.spans {
+		if sp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sp.TraceState != "vendor=abc" {
+			t.Errorf("span %s is in trace %s with state %q", sp.Name, sp.TraceID, sp.TraceState)
+		}
+		if sp.Name == "GET /albums/:id" {
+			root = &sink.spans[i]
+		}
+	}
+	if root == nil || root.ParentSpanID != "00f067aa0ba902b7" || response != "00-"+root.TraceID+"-"+root.SpanID+"-01" {
+		t.Errorf("request span = %+v; want a child of the incoming span named in traceresponse", root)
+	}
+}
+
+func TestTraceIgnoresInvalidTraceparent(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		router, sink := newTraceTestRouter(t)
+		req := httptest.NewRequest(http.MethodGet, "/albums/1", nil)
+		req.Header.Set("traceparent", header)
+		req.Header.Set("tracestate", "vendor=abc")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		for _, sp := range sink.spans {
+			if sp.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" || sp.TraceState != "" || sp.Name == "GET /albums/:id" && sp.ParentSpanID != "" {
+				t.Errorf("traceparent %q: span %+v continues it", header, sp)
+			}
+		}
+		if len(sink.spans) == 0 {
+			t.Errorf("traceparent %q: no spans exported", header)
+		}
+	}
+
+	// A caller that doesn't sample the trace gets no spans exported either.
+	router, sink := newTraceTestRouter(t)
+	req := httptest.NewRequest(http.MethodGet, "/albums/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if len(sink.spans) != 0 || !strings.HasSuffix(w.Header().Get("traceresponse"), "-00") {
+		t.Errorf("unsampled trace exported %v, traceresponse %q", sink.names(), w.Header().Get("traceresponse"))
+	}
+}
+
+func TestTraceSpans(t *testing.T) {
+	router, sink := newTraceTestRouter(t)
+
+	w := serve(router, http.MethodPost, "/albums", `{"title": "Giant Steps", "artist": "John Coltrane", "price": 9.99}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /albums = %d %s", w.Code, w.Body)
+	}
+	names := sink.names()
+	// The catalogue is listed to look for duplicates before the album is created.
+	want := []string{"validate", "store.List", "store.Create", "POST /albums"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("spans = %v; want %v in the order they end", names, want)
+	}
+	root := sink.spans[3]
+	for _, sp := range sink.spans[:3] {
+		if sp.TraceID != root.TraceID || sp.ParentSpanID != root.SpanID {
+			t.Errorf("span %s = %+v; want a child of the request span", sp.Name, sp)
+		}
+	}
+	if root.Attributes["http.status_code"] != http.StatusCreated {
+		t.Errorf("request span attributes = %v", root.Attributes)
+	}
+
+	sink.spans = nil
+	serve(router, http.MethodGet, "/albums/999", "")
+	for _, sp := range sink.spans {
+		if sp.Name == "store.Get" && !strings.Contains(sp.Error, "not found") {
+			t.Errorf("store.Get span error = %q; want the lookup error", sp.Error)
+		}
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &jsonLinesSink{w: &buf}
+	sink.Export(spanRecord{TraceID: "a", SpanID: "b", Name: "one"})
+	sink.Export(spanRecord{TraceID: "a", SpanID: "c", ParentSpanID: "b", Name: "two", Error: "boom"})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2:\n%s", len(lines), buf.String())
+	}
+	var second spanRecord
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil || second.ParentSpanID != "b" || second.Error != "boom" {
+		t.Errorf("second line = %s (%v)", lines[1], err)
+	}
+}
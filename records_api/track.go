@@ -0,0 +1,238 @@
+package records_api
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// `track` is one song on an album, identified by its side and its position on that side.
+type track struct {
+	Side     string   `json:"side" validate:"required,oneof=A B C D"`
+	Position int      `json:"position" validate:"required,min=1,max=99"`
+	Title    string   `json:"title" validate:"required,max=200"`
+	Duration string   `json:"duration" validate:"required,duration"`
+	Credits  []credit `json:"credits,omitempty" validate:"max=50,dive"`
+}
+
+// `credit` names someone who worked on a track, e.g. `{"name": "Lee Morgan", "role": "trumpet"}`.
+type credit struct {
+	Name string `json:"name" validate:"required,max=100"`
+	Role string `json:"role" validate:"required,max=100"`
+}
+
+// `trackList` is the body of `PUT /albums/:id/tracks`.
+type trackList struct {
+	Tracks []track `json:"tracks" validate:"max=100,dive"`
+}
+
+// `runningTime` totals the durations of an album's tracks, per side and overall, as ISO 8601 durations.
+type runningTime struct {
+	Sides map[string]string `json:"sides"`
+	Total string            `json:"total"`
+}
+
+// Durations are limited to hours, minutes and seconds, which is all a track needs, e.g. `PT7M43S` or `PT1H2M0.5S`.
+var isoDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?$`)
+
+// `parseDuration` parses an ISO 8601 duration such as `PT4M30S`.
+func parseDuration(s string) (time.Duration, error) {
+	m := isoDuration.FindStringSubmatch(s)
+	if m == nil || s == "PT" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n * float64(unit))
+	}
+	return d, nil
+}
+
+// `formatDuration` writes `d` as an ISO 8601 duration, rounded to whole seconds.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	b.WriteString("PT")
+	if h := d / time.Hour; h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+	}
+	if m := d % time.Hour / time.Minute; m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+	}
+	if s := d % time.Minute / time.Second; s > 0 {
+		fmt.Fprintf(&b, "%dS", s)
+	}
+	return b.String()
+}
+
+// `newRunningTime` adds up the tracks; it returns nil for an album without tracks.
+func newRunningTime(tracks []track) *runningTime {
+	if len(tracks) == 0 {
+		return nil
+	}
+	sides := map[string]time.Duration{}
+	var total time.Duration
+	for _, t := range tracks {
+		// The durations were validated when the tracks were stored.
+		d, _ := parseDuration(t.Duration)
+		sides[t.Side] += d
+		total += d
+	}
+	rt := &runningTime{Sides: map[string]string{}, Total: formatDuration(total)}
+	for side, d := range sides {
+		rt.Sides[side] = formatDuration(d)
+	}
+	return rt
+}
+
+var trackValidator = newTrackValidator()
+
+func newTrackValidator() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+		d, err := parseDuration(fl.Field().String())
+		return err == nil && d > 0
+	})
+	validate.RegisterStructValidation(validateTrackList, trackList{})
+	return validate
+}
+
+// `validateTrackList` checks the list as a whole: every position is used once per side,
+// and the sides used start at A without gaps, as a record has no side C without a side B.
+func validateTrackList(sl validator.StructLevel) {
+	list := sl.Current().Interface().(trackList)
+
+	seen := map[string]bool{}
+	sides := map[string]bool{}
+	for i, t := range list.Tracks {
+		key := t.Side + strconv.Itoa(t.Position)
+		if seen[key] {
+			sl.ReportError(t.Position, fmt.Sprintf("Tracks[%d].Position", i), "Position", "unique", key)
+		}
+		seen[key] = true
+		sides[t.Side] = true
+	}
+	for _, side := range []string{"B", "C", "D"} {
+		previous := string(side[0] - 1)
+		if sides[side] && !sides[previous] {
+			sl.ReportError(list.Tracks, "Tracks", "Tracks", "sides", side)
+		}
+	}
+}
+
+// `describeTrackValidation` turns validation errors into a message for the client,
+// e.g. "tracks[2].position: A3 is used twice".
+func describeTrackValidation(err error) string {
+	fields := validationProblems(err, describeTrackFieldError)
+	if fields == nil {
+		return err.Error()
+	}
+	return joinFieldProblems(fields)
+}
+
+func describeTrackFieldError(fe validator.FieldError) fieldProblem {
+	field := strings.ToLower(strings.TrimPrefix(fe.Namespace(), "trackList."))
+	var detail string
+	switch fe.Tag() {
+	case "unique":
+		detail = fmt.Sprintf("%s: %s is used twice", field, fe.Param())
+	case "sides":
+		detail = fmt.Sprintf("side %s has tracks but the side before it has none", fe.Param())
+	case "oneof":
+		detail = fmt.Sprintf("%s must be one of %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "duration":
+		detail = fmt.Sprintf("%s must be a positive ISO 8601 duration such as PT4M30S", field)
+	case "required":
+		detail = field + " is required"
+	default:
+		detail = fmt.Sprintf("%s must satisfy %s=%s", field, fe.Tag(), fe.Param())
+	}
+	return fieldProblem{Field: field, Detail: detail}
+}
+
+// `getAlbumTracks` responds with the track list of an album and its running times.
+func (s *server) getAlbumTracks(c *gin.Context) {
+	a, err := s.albums.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		s.albumLookupFailed(c, err)
+		return
+	}
+	s.setCacheControl(c)
+	c.IndentedJSON(http.StatusOK, gin.H{"tracks": orEmpty(a.Tracks), "runningTime": a.RunningTime})
+}
+
+// `putAlbumTracks` replaces the track list of an album.
+func (s *server) putAlbumTracks(c *gin.Context) {
+	ctx := c.Request.Context()
+	a, err := s.albums.Get(ctx, c.Param("id"))
+	if err != nil {
+		s.albumLookupFailed(c, err)
+		return
+	}
+
+	var list trackList
+	if err := tracedBind(ctx, c.ShouldBindJSON)(&list); err != nil {
+		bindFailed(c, err, s.cfg.MaxBodyBytes)
+		return
+	}
+	if err := trackValidator.Struct(list); err != nil {
+		validationFailed(c, err, describeTrackFieldError)
+		return
+	}
+
+	sortTracks(list.Tracks)
+	// The album is read again in the transaction, so writes made since the lookup above are kept.
+	err = s.albums.Transaction(ctx, func(tx albumStore) error {
+		if a, err = tx.Get(ctx, a.ID); err != nil {
+			return err
+		}
+		a.Tracks = list.Tracks
+		a.RunningTime = newRunningTime(list.Tracks)
+		_, err = tx.Update(ctx, a)
+		return err
+	})
+	if errors.Is(err, errAlbumNotFound) {
+		writeProblem(c, problemNotFound, "album not found")
+		return
+	}
+	if err != nil {
+		writeProblem(c, problemInternal, "could not update album")
+		logError(c.Request.Context(), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"tracks": orEmpty(a.Tracks), "runningTime": a.RunningTime})
+}
+
+// `sortTracks` puts tracks in playing order: by side, then by position.
+func sortTracks(tracks []track) {
+	slices.SortFunc(tracks, func(x, y track) int {
+		return cmp.Or(cmp.Compare(x.Side, y.Side), cmp.Compare(x.Position, y.Position))
+	})
+}
+
+// `orEmpty` makes a nil slice encode as `[]` rather than `null`.
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
@@ -0,0 +1,160 @@
+package records_api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"PT4M30S", 4*time.Minute + 30*time.Second},
+		{"PT1H2M", time.Hour + 2*time.Minute},
+		{"PT59.5S", 59*time.Second + 500*time.Millisecond},
+		{"PT90S", 90 * time.Second},
+	}
+	for _, tt := range tests {
+		if got, err := parseDuration(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseDuration(%q) = %s, %v; want %s", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "PT", "4:30", "P1D", "PT4S30M", "pt4m"} {
+		if _, err := parseDuration(bad); err == nil {
+			t.Errorf("parseDuration(%q) succeeded", bad)
+		}
+	}
+
+	if got := formatDuration(time.Hour + 90*time.Second); got != "PT1H1M30S" {
+		t.Errorf("formatDuration = %s; want PT1H1M30S", got)
+	}
+}
+
+const blueTrainTracks = `{"tracks": [
+	{"side": "B", "position": 1, "title": "Moment's Notice", "duration": "PT9M10S"},
+	{"side": "A", "position": 2, "title": "Lazy Bird", "duration": "PT7M0S"},
+	{"side": "A", "position": 1, "title": "Blue Train", "duration": "PT10M43S",
+	 "credits": [{"name": "Lee Morgan", "role": "trumpet"}]}
+]}`
+
+func TestAlbumTracks(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	w := serve(router, http.MethodPut, "/albums/1/tracks", blueTrainTracks)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT tracks = %d %s", w.Code, w.Body)
+	}
+
+	var a album
+	w = serve(router, http.MethodGet, "/albums/1", "")
+	json.Unmarshal(w.Body.Bytes(), &a)
+	if a.RunningTime == nil || a.RunningTime.Total != "PT26M53S" || a.RunningTime.Sides["A"] != "PT17M43S" || a.RunningTime.Sides["B"] != "PT9M10S" {
+		t.Errorf("running time = %+v", a.RunningTime)
+	}
+	// Tracks are kept in side and position order.
+	if len(a.Tracks) != 3 || a.Tracks[0].Title != "Blue Train" || a.Tracks[2].Side != "B" {
+		t.Errorf("tracks = %+v", a.Tracks)
+	}
+
+	// Replacing the album keeps its tracks.
+	serve(router, http.MethodPut, "/albums/1", `{"title":"Blue Train","artist":"Coltrane","price":50}`)
+	w = serve(router, http.MethodGet, "/v2/albums/1/tracks", "")
+	if !strings.Contains(w.Body.String(), "Lazy Bird") {
+		t.Errorf("tracks after replacing the album = %s", w.Body)
+	}
+
+	// An empty list removes the tracks and the running time.
+	serve(router, http.MethodPut, "/albums/1/tracks", `{"tracks": []}`)
+	w = serve(router, http.MethodGet, "/albums/1", "")
+	if strings.Contains(w.Body.String(), "runningTime") {
+		t.Errorf("album without tracks = %s", w.Body)
+	}
+}
+
+func TestTrackValidation(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"tracks": [{"side": "A", "position": 1, "title": "x", "duration": "PT1M"}, {"side": "A", "position": 1, "title": "y", "duration": "PT1M"}]}`,
+			"tracks[1].position: A1 is used twice"},
+		{`{"tracks": [{"side": "A", "position": 1, "title": "x", "duration": "PT1M"}, {"side": "C", "position": 1, "title": "y", "duration": "PT1M"}]}`,
+			"side C has tracks but the side before it has none"},
+		{`{"tracks": [{"side": "E", "position": 1, "title": "x", "duration": "PT1M"}]}`,
+			"tracks[0].side must be one of A, B, C, D"},
+		{`{"tracks": [{"side": "A", "position": 1, "title": "x", "duration": "4:30"}]}`,
+			"tracks[0].duration must be a positive ISO 8601 duration"},
+		{`{"tracks": [{"side": "A", "position": 1, "title": "x", "duration": "PT1M", "credits": [{"name": "Lee Morgan"}]}]}`,
+			"tracks[0].credits[0].role is required"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodPut, "/albums/1/tracks", tt.body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("PUT %s = %d %s; want 400 %q", tt.body, w.Code, w.Body, tt.want)
+		}
+	}
+
+	if w := serve(router, http.MethodPut, "/albums/nope/tracks", blueTrainTracks); w.Code != http.StatusNotFound {
+		t.Errorf("tracks of a missing album = %d; want 404", w.Code)
+	}
+}
+
+// `racingStore` applies `race` to album 1 right after the first time it is read outside a transaction,
+// or right before the first transaction starts, as a request running at the same time could.
+type racingStore struct {
+	*memoryStore
+	race func(a *album)
+	once sync.Once
+}
+
+func (s *racingStore) Get(ctx context.Context, id string) (album, error) {
+	a, err := s.memoryStore.Get(ctx, id)
+	s.raceOnce(ctx)
+	return a, err
+}
+
+func (s *racingStore) Transaction(ctx context.Context, fn func(tx albumStore) error) error {
+	s.raceOnce(ctx)
+	return s.memoryStore.Transaction(ctx, fn)
+}
+
+func (s *racingStore) raceOnce(ctx context.Context) {
+	s.once.Do(func() {
+		raced, _ := s.memoryStore.Get(ctx, "1")
+		s.race(&raced)
+		s.memoryStore.Update(ctx, raced)
+	})
+}
+
+func TestAlbumWritesKeepConcurrentChanges(t *testing.T) {
+	for name, write := range map[string]func(router http.Handler) *httptest.ResponseRecorder{
+		"tracks": func(router http.Handler) *httptest.ResponseRecorder {
+			return serve(router, http.MethodPut, "/albums/1/tracks", `{"tracks": [{"side": "A", "position": 1, "title": "Blue Train", "duration": "PT10M43S"}]}`)
+		},
+		"cover": func(router http.Handler) *httptest.ResponseRecorder {
+			return putCover(router, "image/png", testPNG(t, 32, 32))
+		},
+	} {
+		cfg := defaultConfig()
+		cfg.CoverDir = t.TempDir()
+		cfg.CacheSize = 0
+		router := newRouter(newServer(cfg, &racingStore{memoryStore: newMemoryStore(albums), race: func(a *album) { a.Price = 40 }}))
+		if w := write(router); w.Code != http.StatusOK {
+			t.Fatalf("%s: PUT = %d %s", name, w.Code, w.Body)
+		}
+		var a album
+		json.Unmarshal(serve(router, http.MethodGet, "/albums/1", "").Body.Bytes(), &a)
+		if a.Price != 40 || a.Cover == nil && len(a.Tracks) == 0 {
+			t.Errorf("%s: album = %+v; want the new price and the %s", name, a, name)
+		}
+	}
+}
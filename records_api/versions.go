@@ -0,0 +1,144 @@
+package records_api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `apiVersion` is one representation of the API. All versions share the internal `album` model;
+// a version only decides how albums look on the wire, through its request and response adapters.
+type apiVersion struct {
+	name string
+	// When set, responses carry `Deprecation` and `Sunset` headers pointing clients to `successor`.
+	// The dates are configured; see `scheduled`.
+	deprecated time.Time
+	sunset     time.Time
+	successor  string
+
+	// `decodeAlbum` binds and validates a request body into the internal model. `bind` is
+	// `c.ShouldBindJSON` for a whole request body, or a binding of one part of it, as in batches.
+	decodeAlbum func(bind func(obj any) error) (album, error)
+	// `encodeAlbum` turns the internal model into this version's response body.
+	encodeAlbum func(a album) any
+}
+
+const apiVersionKey = "apiVersion"
+
+var apiVersions = map[string]*apiVersion{
+	"v1": {
+		name:        "v1",
+		successor:   "v2",
+		decodeAlbum: decodeAlbumV1,
+		encodeAlbum: func(a album) any { return a },
+	},
+	"v2": {
+		name:        "v2",
+		decodeAlbum: decodeAlbumV2,
+		encodeAlbum: encodeAlbumV2,
+	},
+}
+
+// `scheduled` returns a copy of `v` with the deprecation and sunset dates configured for it.
+func (cfg config) scheduled(v *apiVersion) *apiVersion {
+	scheduled := *v
+	if v.name == "v1" {
+		scheduled.deprecated, scheduled.sunset = time.Time(cfg.V1Deprecation), time.Time(cfg.V1Sunset)
+	}
+	return &scheduled
+}
+
+// `withAPIVersion` makes `v` the version of every request through this route group.
+func withAPIVersion(v *apiVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionKey, v)
+		c.Header("API-Version", v.name)
+		if !v.deprecated.IsZero() {
+			// RFC 9745 and RFC 8594.
+			c.Header("Deprecation", fmt.Sprintf("@%d", v.deprecated.Unix()))
+			if !v.sunset.IsZero() {
+				c.Header("Sunset", v.sunset.Format(http.TimeFormat))
+			}
+			c.Header("Link", fmt.Sprintf(`</%s>; rel="successor-version"`, v.successor))
+		}
+		c.Next()
+	}
+}
+
+// `versionOf` returns the API version of the request.
+func versionOf(c *gin.Context) *apiVersion {
+	return c.MustGet(apiVersionKey).(*apiVersion)
+}
+
+// `encodeAlbums` applies the response adapter of the request's version to every album.
+func encodeAlbums(c *gin.Context, albums []album) []any {
+	v := versionOf(c)
+	encoded := make([]any, len(albums))
+	for i, a := range albums {
+		encoded[i] = v.encodeAlbum(a)
+	}
+	return encoded
+}
+
+// Version 1 sends the internal model as it is.
+func decodeAlbumV1(bind func(obj any) error) (album, error) {
+	var a album
+	err := bind(&a)
+	return a, err
+}
+
+// `albumV2` is an album in version 2: the artist is an object and the price carries its currency.
+type albumV2 struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title" binding:"required,max=200"`
+	Artist      artistV2      `json:"artist" binding:"required"`
+	Price       priceV2       `json:"price" binding:"required"`
+	Genres      []string      `json:"genres,omitempty" binding:"max=10,dive,required,max=30"`
+	Year        int           `json:"year,omitempty" binding:"omitempty,min=1900,max=2100"`
+	Cover       *albumCover   `json:"cover,omitempty"`
+	Tracks      []track       `json:"tracks,omitempty"`
+	RunningTime *runningTime  `json:"runningTime,omitempty"`
+	Reviews     reviewSummary `json:"reviews"`
+	Pricing     *albumPricing `json:"pricing,omitempty"`
+}
+
+type artistV2 struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// Amounts are decimal strings, so no precision is lost to floating point on the way.
+type priceV2 struct {
+	Amount   string `json:"amount" binding:"required,numeric"`
+	Currency string `json:"currency" binding:"required,eq=USD"`
+}
+
+func decodeAlbumV2(bind func(obj any) error) (album, error) {
+	var in albumV2
+	if err := bind(&in); err != nil {
+		return album{}, err
+	}
+	amount, err := strconv.ParseFloat(in.Price.Amount, 64)
+	if err != nil || amount <= 0 {
+		return album{}, fieldProblem{Field: "price.amount", Detail: fmt.Sprintf("price amount must be a positive decimal, got %q", in.Price.Amount)}
+	}
+	return album{ID: in.ID, Title: in.Title, Artist: in.Artist.Name, Price: amount, Genres: in.Genres, Year: in.Year}, nil
+}
+
+func encodeAlbumV2(a album) any {
+	return albumV2{
+		ID:          a.ID,
+		Title:       a.Title,
+		Artist:      artistV2{Name: a.Artist},
+		Price:       priceV2{Amount: strconv.FormatFloat(a.Price, 'f', 2, 64), Currency: "USD"},
+		Genres:      a.Genres,
+		Year:        a.Year,
+		Cover:       a.Cover,
+		Tracks:      a.Tracks,
+		RunningTime: a.RunningTime,
+		Reviews:     a.Reviews,
+		Pricing:     a.Pricing,
+	}
+}
@@ -0,0 +1,136 @@
+package records_api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newVersionTestRouter(t *testing.T, defaultVersion string) http.Handler {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.DefaultAPIVersion = defaultVersion
+	return newRouter(newServer(cfg, newMemoryStore(albums)))
+}
+
+func serve(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestVersionRepresentations(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	var v1 album
+	w := serve(router, http.MethodGet, "/v1/albums/1", "")
+	json.Unmarshal(w.Body.Bytes(), &v1)
+	if v1.Artist != "John Coltrane" || v1.Price != 56.99 {
+		t.Errorf("v1 album = %s", w.Body)
+	}
+
+	var v2 albumV2
+	w = serve(router, http.MethodGet, "/v2/albums/1", "")
+	json.Unmarshal(w.Body.Bytes(), &v2)
+	if v2.Artist.Name != "John Coltrane" || v2.Price != (priceV2{Amount: "56.99", Currency: "USD"}) {
+		t.Errorf("v2 album = %s", w.Body)
+	}
+}
+
+func TestVersionRequestAdapters(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+
+	w := serve(router, http.MethodPost, "/v2/albums", `{"title":"Giant","artist":{"name":"Coltrane"},"price":{"amount":"19.99","currency":"USD"}}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("v2 create = %d %s", w.Code, w.Body)
+	}
+	var created albumV2
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	// The same album is visible through v1, since both versions share one model.
+	var v1 album
+	w = serve(router, http.MethodGet, "/v1/albums/"+created.ID, "")
+	json.Unmarshal(w.Body.Bytes(), &v1)
+	if v1.Artist != "Coltrane" || v1.Price != 19.99 {
+		t.Errorf("v1 view of a v2 album = %s", w.Body)
+	}
+
+	// A v1 body is not a valid v2 body.
+	if w := serve(router, http.MethodPost, "/v2/albums", `{"title":"Giant","artist":"Coltrane","price":19.99}`); w.Code != http.StatusBadRequest {
+		t.Errorf("v1 body on v2 = %d; want 400", w.Code)
+	}
+	if w := serve(router, http.MethodPost, "/v2/albums", `{"title":"Giant","artist":{"name":"Coltrane"},"price":{"amount":"19.99","currency":"EUR"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported currency = %d; want 400", w.Code)
+	}
+}
+
+func TestVersionHeadersAndDefault(t *testing.T) {
+	router := newVersionTestRouter(t, "v2")
+
+	w := serve(router, http.MethodGet, "/v1/albums", "")
+	if w.Header().Get("Deprecation") == "" || w.Header().Get("Sunset") == "" || !strings.Contains(w.Header().Get("Link"), "successor-version") {
+		t.Errorf("v1 headers = %v; want Deprecation, Sunset and a successor Link", w.Header())
+	}
+
+	w = serve(router, http.MethodGet, "/albums", "")
+	if w.Header().Get("API-Version") != "v2" || w.Header().Get("Deprecation") != "" {
+		t.Errorf("unversioned headers = %v; want the default version v2", w.Header())
+	}
+	if !strings.Contains(w.Body.String(), `"currency": "USD"`) {
+		t.Errorf("unversioned body is not v2:\n%s", w.Body)
+	}
+}
+
+func TestAlbumFieldLimits(t *testing.T) {
+	router := newVersionTestRouter(t, "v1")
+	tests := []struct {
+		path, body string
+		want       int
+	}{
+		{"/v1/albums", `{"title":"` + strings.Repeat("a", 200) + `","artist":"` + strings.Repeat("b", 100) + `","price":9.99}`, http.StatusCreated},
+		{"/v1/albums", `{"title":"` + strings.Repeat("a", 201) + `","artist":"Coltrane","price":9.99}`, http.StatusBadRequest},
+		{"/v1/albums", `{"title":"Giant","artist":"` + strings.Repeat("b", 101) + `","price":9.99}`, http.StatusBadRequest},
+		{"/v2/albums", `{"title":"` + strings.Repeat("c", 200) + `","artist":{"name":"` + strings.Repeat("d", 100) + `"},"price":{"amount":"9.99","currency":"USD"}}`, http.StatusCreated},
+		{"/v2/albums", `{"title":"` + strings.Repeat("c", 201) + `","artist":{"name":"Coltrane"},"price":{"amount":"9.99","currency":"USD"}}`, http.StatusBadRequest},
+		{"/v2/albums", `{"title":"Giant","artist":{"name":"` + strings.Repeat("d", 101) + `"},"price":{"amount":"9.99","currency":"USD"}}`, http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		if w := serve(router, http.MethodPost, tt.path, tt.body); w.Code != tt.want {
+			t.Errorf("case %d: POST %s = %d; want %d", i, tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestV1ScheduleIsConfigured(t *testing.T) {
+	cfg, err := loadConfig([]string{"-v1-deprecation", "2027-01-01", "-v1-sunset", "2027-07-01", "-cover-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serve(newRouter(newServer(cfg, newMemoryStore(albums))), http.MethodGet, "/v1/albums", "")
+	if w.Header().Get("Deprecation") != "@1798761600" || w.Header().Get("Sunset") != "Thu, 01 Jul 2027 00:00:00 GMT" {
+		t.Errorf("v1 headers = %v; want the configured dates", w.Header())
+	}
+
+	cfg, err = loadConfig([]string{"-v1-deprecation", "", "-v1-sunset", "", "-cover-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = serve(newRouter(newServer(cfg, newMemoryStore(albums))), http.MethodGet, "/v1/albums", "")
+	if w.Header().Get("Deprecation") != "" || w.Header().Get("Sunset") != "" {
+		t.Errorf("v1 headers without a schedule = %v", w.Header())
+	}
+
+	for _, args := range [][]string{
+		{"-v1-deprecation", "2027-07-01", "-v1-sunset", "2027-01-01"},
+		{"-v1-deprecation", "", "-v1-sunset", "2027-01-01"},
+		{"-v1-sunset", "next year"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("loadConfig(%q) succeeded; want an error", args)
+		}
+	}
+}
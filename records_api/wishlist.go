@@ -0,0 +1,438 @@
+package records_api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// `maxWishlistItems` is how many albums one customer can save.
+const maxWishlistItems = 200
+
+// Customer IDs are chosen by the backend that calls the wishlist endpoints, e.g. an account number.
+var customerIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// `wishlistItem` is an album a customer saved, with what it cost them at the time.
+type wishlistItem struct {
+	AlbumID    string    `json:"albumId"`
+	SavedPrice float64   `json:"savedPrice"`
+	SavedAt    time.Time `json:"savedAt"`
+	// The price the customer was last told about, if any; only a price below it is a new drop.
+	// It can be 0 when a promotion gives the album away.
+	NotifiedPrice *float64 `json:"notifiedPrice,omitempty"`
+}
+
+// `wishlistStore` keeps the wishlists of all customers in memory.
+type wishlistStore struct {
+	mu    sync.Mutex
+	lists map[string]map[string]wishlistItem
+}
+
+func newWishlistStore() *wishlistStore {
+	return &wishlistStore{lists: map[string]map[string]wishlistItem{}}
+}
+
+var errWishlistFull = fmt.Errorf("a wishlist can hold at most %d albums", maxWishlistItems)
+
+// `Save` adds the album to the customer's wishlist at `price`. An album that is already on it keeps
+// the price it was saved at, and `created` is false.
+func (s *wishlistStore) Save(customer, albumID string, price float64, now time.Time) (item wishlistItem, created bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.lists[customer]
+	if item, ok := list[albumID]; ok {
+		return item, false, nil
+	}
+	if len(list) >= maxWishlistItems {
+		return wishlistItem{}, false, errWishlistFull
+	}
+	if list == nil {
+		list = map[string]wishlistItem{}
+		s.lists[customer] = list
+	}
+	item = wishlistItem{AlbumID: albumID, SavedPrice: price, SavedAt: now.UTC()}
+	list[albumID] = item
+	return item, true, nil
+}
+
+// `List` returns the customer's wishlist, oldest first.
+func (s *wishlistStore) List(customer string) []wishlistItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortedWishlist(s.lists[customer])
+}
+
+// `sortedWishlist` returns the items of `list` oldest first.
+func sortedWishlist(list map[string]wishlistItem) []wishlistItem {
+	items := make([]wishlistItem, 0, len(list))
+	for _, item := range list {
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b wishlistItem) int {
+		return cmp.Or(a.SavedAt.Compare(b.SavedAt), cmp.Compare(a.AlbumID, b.AlbumID))
+	})
+	return items
+}
+
+// `Remove` takes the album off the customer's wishlist and reports whether it was on it.
+func (s *wishlistStore) Remove(customer, albumID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lists[customer][albumID]; !ok {
+		return false
+	}
+	delete(s.lists[customer], albumID)
+	if len(s.lists[customer]) == 0 {
+		delete(s.lists, customer)
+	}
+	return true
+}
+
+// `savedAlbum` is a wishlist item with the customer it belongs to.
+type savedAlbum struct {
+	customer string
+	wishlistItem
+}
+
+// `All` returns a copy of every wishlist item.
+func (s *wishlistStore) All() []savedAlbum {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []savedAlbum
+	for customer, list := range s.lists {
+		for _, item := range list {
+			all = append(all, savedAlbum{customer, item})
+		}
+	}
+	return all
+}
+
+// `Lists` returns a copy of every wishlist by customer, oldest item first.
+func (s *wishlistStore) Lists() map[string][]wishlistItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lists := make(map[string][]wishlistItem, len(s.lists))
+	for customer, list := range s.lists {
+		lists[customer] = sortedWishlist(list)
+	}
+	return lists
+}
+
+// `ReplaceAll` swaps all wishlists for `lists`, as `Lists` returns them.
+func (s *wishlistStore) ReplaceAll(lists map[string][]wishlistItem) {
+	byCustomer := make(map[string]map[string]wishlistItem, len(lists))
+	for customer, items := range lists {
+		if len(items) == 0 {
+			continue
+		}
+		byCustomer[customer] = make(map[string]wishlistItem, len(items))
+		for _, item := range items {
+			byCustomer[customer][item.AlbumID] = item
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists = byCustomer
+}
+
+// `MarkNotified` records that the customer was told the album saved at `savedAt` dropped to `price`.
+// It does nothing if the album was taken off the wishlist, or saved again, in the meantime.
+func (s *wishlistStore) MarkNotified(customer, albumID string, savedAt time.Time, price float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, ok := s.lists[customer][albumID]; ok && item.SavedAt.Equal(savedAt) {
+		item.NotifiedPrice = &price
+		s.lists[customer][albumID] = item
+	}
+}
+
+// `priceDropNotification` tells a customer that an album on their wishlist got cheaper.
+type priceDropNotification struct {
+	// The same for the same drop, so consumers of the outbox can spot repeats of their own.
+	ID string `json:"id"`
+	// The shop with `-multi-tenant`; customer IDs are only unique within a shop.
+	Tenant     string    `json:"tenant,omitempty"`
+	Customer   string    `json:"customer"`
+	AlbumID    string    `json:"albumId"`
+	Title      string    `json:"title"`
+	Artist     string    `json:"artist"`
+	SavedPrice float64   `json:"savedPrice"`
+	Price      float64   `json:"price"`
+	QueuedAt   time.Time `json:"queuedAt"`
+}
+
+// A `notifier` delivers price-drop notifications, e.g. by writing them to an outbox that a mailer works through.
+type notifier interface {
+	Notify(ctx context.Context, n priceDropNotification) error
+}
+
+// `outboxNotifier` appends every notification to a file as a line of JSON.
+type outboxNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *outboxNotifier) Notify(_ context.Context, n priceDropNotification) error {
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err = o.w.Write(append(line, '\n'))
+	return err
+}
+
+// `logNotifier` logs notifications when there is nowhere else to send them.
+type logNotifier struct{}
+
+func (logNotifier) Notify(_ context.Context, n priceDropNotification) error {
+	logger.Printf("wishlist: %s: %q by %s dropped from %.2f to %.2f", n.Customer, n.Title, n.Artist, n.SavedPrice, n.Price)
+	return nil
+}
+
+// `openNotifier` opens the file at `path` for appending notifications, or returns a `logNotifier`
+// if `path` is empty. The returned function closes the file.
+func openNotifier(path string) (notifier, func() error, error) {
+	if path == "" {
+		return logNotifier{}, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open notify outbox: %w", err)
+	}
+	return &outboxNotifier{w: f}, f.Close, nil
+}
+
+// `wishlistWatcher` compares the prices of saved albums with what they cost when they were saved,
+// and notifies customers of drops.
+type wishlistWatcher struct {
+	server   *server
+	interval time.Duration
+	notifier notifier
+	start    sync.Once
+	// Held for a whole check, so a drop can't be noticed by two checks at once.
+	checking sync.Mutex
+}
+
+func newWishlistWatcher(s *server, interval time.Duration, n notifier) *wishlistWatcher {
+	if n == nil {
+		n = logNotifier{}
+	}
+	return &wishlistWatcher{server: s, interval: interval, notifier: n}
+}
+
+// `Start` starts checking in the background, unless checks are turned off or already running. The
+// checks run for the life of the process; they start with the first saved album, so servers that
+// never see one don't run them at all.
+func (w *wishlistWatcher) Start() {
+	if w.interval <= 0 {
+		return
+	}
+	w.start.Do(func() {
+		go func() {
+			t := time.NewTicker(w.interval)
+			defer t.Stop()
+			for range t.C {
+				if err := w.Check(context.Background()); err != nil {
+					logger.Printf("wishlist: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// `Check` notifies customers of every album on their wishlist that costs less than it did when they
+// saved it, and less than they were last told about. The price includes running promotions.
+// A notification that can't be delivered is tried again on the next check; one that was delivered
+// is never sent again.
+func (w *wishlistWatcher) Check(ctx context.Context) error {
+	w.checking.Lock()
+	defer w.checking.Unlock()
+
+	drops, err := w.findDrops(ctx)
+	if err != nil {
+		return err
+	}
+	// The catalogue is released by now, so a slow notifier holds up neither a restore nor the requests behind it.
+	var errs []error
+	for _, drop := range drops {
+		if err := w.notifier.Notify(ctx, drop.notification); err != nil {
+			errs = append(errs, fmt.Errorf("notify %s of album %s: %w", drop.customer, drop.albumID, err))
+			continue
+		}
+		w.server.wishlists.MarkNotified(drop.customer, drop.albumID, drop.savedAt, drop.notification.Price)
+	}
+	return errors.Join(errs...)
+}
+
+// `priceDrop` is a notification `Check` is about to send, with the wishlist item it is about.
+type priceDrop struct {
+	notification      priceDropNotification
+	customer, albumID string
+	savedAt           time.Time
+}
+
+// `findDrops` returns the price drops customers haven't been told about yet.
+func (w *wishlistWatcher) findDrops(ctx context.Context) ([]priceDrop, error) {
+	// A restore swaps the albums and the wishlists together; see `server.restore`.
+	w.server.catalogue.RLock()
+	defer w.server.catalogue.RUnlock()
+
+	saved := w.server.wishlists.All()
+	if len(saved) == 0 {
+		return nil, nil
+	}
+	catalogue, err := w.server.albums.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]album, len(catalogue))
+	for _, a := range catalogue {
+		byID[a.ID] = a
+	}
+
+	now := time.Now()
+	running := w.server.promotions.Running(now)
+	var drops []priceDrop
+	for _, item := range saved {
+		a, ok := byID[item.AlbumID]
+		if !ok {
+			continue
+		}
+		price := currentPrice(a, running)
+		lastKnown := item.SavedPrice
+		if item.NotifiedPrice != nil {
+			lastKnown = min(lastKnown, *item.NotifiedPrice)
+		}
+		if price >= lastKnown {
+			continue
+		}
+		n := priceDropNotification{
+			ID:         fmt.Sprintf("%s/%s/%s", item.customer, item.AlbumID, formatCents(price)),
+			Tenant:     w.server.tenant,
+			Customer:   item.customer,
+			AlbumID:    a.ID,
+			Title:      a.Title,
+			Artist:     a.Artist,
+			SavedPrice: item.SavedPrice,
+			Price:      price,
+			QueuedAt:   now.UTC(),
+		}
+		if n.Tenant != "" {
+			n.ID = n.Tenant + "/" + n.ID
+		}
+		drops = append(drops, priceDrop{notification: n, customer: item.customer, albumID: item.AlbumID, savedAt: item.SavedAt})
+	}
+	return drops, nil
+}
+
+// `currentPrice` is what one copy of `a` costs with the `running` promotions; see `promotionStore.Running`.
+func currentPrice(a album, running []promotion) float64 {
+	if pricing := priceAlbum(a, running); pricing != nil {
+		return pricing.EffectivePrice
+	}
+	return a.Price
+}
+
+// `wishlistEntry` is a wishlist item as `GET /customers/:customer/wishlist` shows it, with the album
+// as it is now. Albums deleted since they were saved have no title, artist or current price.
+type wishlistEntry struct {
+	wishlistItem
+	Title        string   `json:"title,omitempty"`
+	Artist       string   `json:"artist,omitempty"`
+	CurrentPrice *float64 `json:"currentPrice,omitempty"`
+}
+
+// `customerParam` returns the `customer` parameter, or responds with 400 if it isn't a valid customer ID.
+func customerParam(c *gin.Context) (string, bool) {
+	customer := c.Param("customer")
+	if !customerIDPattern.MatchString(customer) {
+		writeProblem(c, problemBadRequest, "customer IDs are 1 to 64 letters, digits and . _ @ -")
+		return "", false
+	}
+	return customer, true
+}
+
+func (s *server) getWishlist(c *gin.Context) {
+	customer, ok := customerParam(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	running := s.promotions.Running(time.Now())
+	entries := []wishlistEntry{}
+	for _, item := range s.wishlists.List(customer) {
+		entry := wishlistEntry{wishlistItem: item}
+		a, err := s.albums.Get(ctx, item.AlbumID)
+		switch {
+		case err == nil:
+			price := currentPrice(a, running)
+			entry.Title, entry.Artist, entry.CurrentPrice = a.Title, a.Artist, &price
+		case !errors.Is(err, errAlbumNotFound):
+			writeProblem(c, problemInternal, "could not get album")
+			logError(ctx, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.IndentedJSON(http.StatusOK, entries)
+}
+
+// `putWishlistItem` saves the album with the `albumID` parameter to the customer's wishlist at its
+// current price. Saving an album again changes nothing.
+func (s *server) putWishlistItem(c *gin.Context) {
+	customer, ok := customerParam(c)
+	if !ok {
+		return
+	}
+	a, err := s.albums.Get(c.Request.Context(), c.Param("albumID"))
+	if err != nil {
+		s.albumLookupFailed(c, err)
+		return
+	}
+	now := time.Now()
+	item, created, err := s.wishlists.Save(customer, a.ID, currentPrice(a, s.promotions.Running(now)), now)
+	if err != nil {
+		writeProblem(c, problemUnprocessable, err.Error())
+		return
+	}
+	s.wishlistWatcher.Start()
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.IndentedJSON(status, item)
+}
+
+func (s *server) deleteWishlistItem(c *gin.Context) {
+	customer, ok := customerParam(c)
+	if !ok {
+		return
+	}
+	if !s.wishlists.Remove(customer, c.Param("albumID")) {
+		writeProblem(c, problemNotFound, "album is not on the wishlist")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
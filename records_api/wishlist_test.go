@@ -0,0 +1,221 @@
+package records_api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// `recordingNotifier` keeps notifications for tests to inspect, and fails while `err` is set.
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []priceDropNotification
+	err  error
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, n priceDropNotification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func newWishlistTestServer(t *testing.T) (*server, *recordingNotifier) {
+	cfg := defaultConfig()
+	cfg.CoverDir = t.TempDir()
+	cfg.AdminToken = "test-token"
+	cfg.WishlistCheckInterval = 0
+	n := &recordingNotifier{}
+	cfg.notifier = n
+	return newServer(cfg, newMemoryStore(albums)), n
+}
+
+func TestWishlistEndpoints(t *testing.T) {
+	s, _ := newWishlistTestServer(t)
+	router := newRouter(s)
+
+	if w := adminRequest(router, http.MethodPut, "/customers/ann/wishlist/1", "", nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	serve(router, http.MethodPut, "/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 40}`)
+	// Saving it again keeps the price it was first saved at.
+	w := adminRequest(router, http.MethodPut, "/customers/ann/wishlist/1", "", nil)
+	var item wishlistItem
+	if json.Unmarshal(w.Body.Bytes(), &item); w.Code != http.StatusOK || item.SavedPrice != 56.99 {
+		t.Errorf("PUT again = %d %+v", w.Code, item)
+	}
+	adminRequest(router, http.MethodPut, "/customers/ann/wishlist/2", "", nil)
+
+	var entries []wishlistEntry
+	json.Unmarshal(adminRequest(router, http.MethodGet, "/customers/ann/wishlist", "", nil).Body.Bytes(), &entries)
+	if len(entries) != 2 || entries[0].AlbumID != "1" || entries[0].Title != "Blue Train" || *entries[0].CurrentPrice != 40 {
+		t.Fatalf("wishlist = %+v", entries)
+	}
+	serve(router, http.MethodDelete, "/albums/2", "")
+	var afterDelete []wishlistEntry
+	json.Unmarshal(adminRequest(router, http.MethodGet, "/customers/ann/wishlist", "", nil).Body.Bytes(), &afterDelete)
+	if len(afterDelete) != 2 || afterDelete[1].CurrentPrice != nil || afterDelete[1].Title != "" {
+		t.Errorf("deleted album = %+v; want no title or current price", afterDelete)
+	}
+	if body := adminRequest(router, http.MethodGet, "/customers/bob/wishlist", "", nil).Body.String(); strings.TrimSpace(body) != "[]" {
+		t.Errorf("empty wishlist = %s", body)
+	}
+
+	if w := adminRequest(router, http.MethodDelete, "/customers/ann/wishlist/1", "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", w.Code)
+	}
+	decodeProblem(t, adminRequest(router, http.MethodDelete, "/customers/ann/wishlist/1", "", nil), problemNotFound)
+	decodeProblem(t, adminRequest(router, http.MethodPut, "/customers/ann/wishlist/999", "", nil), problemNotFound)
+	decodeProblem(t, adminRequest(router, http.MethodGet, "/customers/"+strings.Repeat("x", 65)+"/wishlist", "", nil), problemBadRequest)
+
+	// Customers aren't authenticated, so only a trusted backend can get at their wishlists.
+	if w := serve(router, http.MethodGet, "/customers/ann/wishlist", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials = %d; want 401", w.Code)
+	}
+}
+
+func TestWishlistWatcherNotifiesOncePerDrop(t *testing.T) {
+	s, n := newWishlistTestServer(t)
+	router := newRouter(s)
+	ctx := context.Background()
+	adminRequest(router, http.MethodPut, "/customers/ann/wishlist/1", "", nil)
+	adminRequest(router, http.MethodPut, "/customers/bob/wishlist/2", "", nil)
+
+	if err := s.wishlistWatcher.Check(ctx); err != nil || len(n.sent) != 0 {
+		t.Fatalf("before any drop: sent %+v (%v)", n.sent, err)
+	}
+
+	serve(router, http.MethodPut, "/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 50}`)
+	s.wishlistWatcher.Check(ctx)
+	s.wishlistWatcher.Check(ctx)
+	if len(n.sent) != 1 || n.sent[0].Customer != "ann" || n.sent[0].SavedPrice != 56.99 || n.sent[0].Price != 50 || n.sent[0].ID != "ann/1/50.00" {
+		t.Fatalf("sent = %+v; want one notification to ann", n.sent)
+	}
+
+	// Going back up and down to the same price is not a new drop.
+	serve(router, http.MethodPut, "/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 55}`)
+	s.wishlistWatcher.Check(ctx)
+	serve(router, http.MethodPut, "/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 50}`)
+	s.wishlistWatcher.Check(ctx)
+	if len(n.sent) != 1 {
+		t.Fatalf("sent = %+v; want no repeat of the same drop", n.sent)
+	}
+
+	// Promotions count too. Undelivered notifications are tried again.
+	now := time.Now()
+	body, _ := json.Marshal(promotion{
+		Name: "weekend", Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour),
+		Discount: promotionDiscount{Type: discountPercent, Percent: 10},
+	})
+	adminRequest(router, http.MethodPost, "/admin/promotions", "application/json", body)
+	n.err = errors.New("mailer down")
+	if err := s.wishlistWatcher.Check(ctx); err == nil {
+		t.Error("Check succeeded with the notifier failing")
+	}
+	n.err = nil
+	s.wishlistWatcher.Check(ctx)
+	s.wishlistWatcher.Check(ctx)
+	prices := map[string]float64{}
+	for _, sent := range n.sent[1:] {
+		prices[sent.Customer] = sent.Price
+	}
+	if len(n.sent) != 3 || prices["ann"] != 45 || prices["bob"] != 16.19 {
+		t.Errorf("sent = %+v; want one more for each customer", n.sent)
+	}
+}
+
+func TestWishlistWatcherNotifiesOnceOfFreeAlbums(t *testing.T) {
+	s, n := newWishlistTestServer(t)
+	router := newRouter(s)
+	adminRequest(router, http.MethodPut, "/customers/ann/wishlist/1", "", nil)
+
+	now := time.Now()
+	body, _ := json.Marshal(promotion{
+		Name: "giveaway", Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour),
+		Discount: promotionDiscount{Type: discountPercent, Percent: 100},
+	})
+	adminRequest(router, http.MethodPost, "/admin/promotions", "application/json", body)
+	for i := 0; i < 3; i++ {
+		s.wishlistWatcher.Check(context.Background())
+	}
+	if len(n.sent) != 1 || n.sent[0].Price != 0 || n.sent[0].ID != "ann/1/0.00" {
+		t.Errorf("sent = %+v; want one notification of the album going free", n.sent)
+	}
+}
+
+func TestWishlistNotificationsNameTheTenant(t *testing.T) {
+	s, n := newWishlistTestServer(t)
+	s.tenant = "shop-a"
+	router := newRouter(s)
+	adminRequest(router, http.MethodPut, "/customers/ann/wishlist/1", "", nil)
+	serve(router, http.MethodPut, "/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 50}`)
+	s.wishlistWatcher.Check(context.Background())
+	if len(n.sent) != 1 || n.sent[0].Tenant != "shop-a" || n.sent[0].ID != "shop-a/ann/1/50.00" {
+		t.Errorf("sent = %+v; want a notification for shop-a", n.sent)
+	}
+}
+
+func TestOutboxNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	o := &outboxNotifier{w: &buf}
+	o.Notify(context.Background(), priceDropNotification{ID: "ann/1/50.00", Customer: "ann", AlbumID: "1", Price: 50})
+	o.Notify(context.Background(), priceDropNotification{ID: "bob/2/9.99", Customer: "bob", AlbumID: "2", Price: 9.99})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	var second priceDropNotification
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &second) != nil || second.Customer != "bob" || second.Price != 9.99 {
+		t.Errorf("outbox = %q", buf.String())
+	}
+}
+
+// `stalledNotifier` blocks in `Notify` until `resume` is closed, like an outbox on a stuck disk.
+type stalledNotifier struct {
+	stalled, resume chan struct{}
+}
+
+func (n stalledNotifier) Notify(context.Context, priceDropNotification) error {
+	close(n.stalled)
+	<-n.resume
+	return nil
+}
+
+func TestWishlistWatcherNotifiesOutsideTheCatalogue(t *testing.T) {
+	s, _ := newWishlistTestServer(t)
+	router := newRouter(s)
+	adminRequest(router, http.MethodPut, "/customers/ann/wishlist/1", "", nil)
+	serve(router, http.MethodPut, "/albums/1", `{"title": "Blue Train", "artist": "John Coltrane", "price": 50}`)
+
+	n := stalledNotifier{stalled: make(chan struct{}), resume: make(chan struct{})}
+	s.wishlistWatcher.notifier = n
+	done := make(chan struct{})
+	go func() {
+		s.wishlistWatcher.Check(context.Background())
+		close(done)
+	}()
+	<-n.stalled
+
+	// A restore takes the catalogue for itself; it must not wait for the notifier.
+	locked := make(chan struct{})
+	go func() {
+		s.catalogue.Lock()
+		s.catalogue.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Error("a restore waited for a stalled notifier")
+	}
+	close(n.resume)
+	<-done
+	<-locked
+}
@@ -0,0 +1,58 @@
+package recordsclient
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// `Album` is a record album as served by the records API.
+type Album struct {
+	ID     string  `json:"id,omitempty"`
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Price  float64 `json:"price"`
+	Cover  *Cover  `json:"cover,omitempty"`
+}
+
+// `Cover` describes the cover image of an album.
+type Cover struct {
+	SHA256      string    `json:"sha256"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Thumbnails  []int     `json:"thumbnails"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+}
+
+// `albumV2` is how version 2 of the API, which the client speaks, sends an album.
+type albumV2 struct {
+	ID     string `json:"id,omitempty"`
+	Title  string `json:"title"`
+	Artist struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Price struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	} `json:"price"`
+	Cover *Cover `json:"cover,omitempty"`
+}
+
+func toV2(album Album) albumV2 {
+	var v albumV2
+	v.ID, v.Title, v.Cover = album.ID, album.Title, album.Cover
+	v.Artist.Name = album.Artist
+	v.Price.Amount = strconv.FormatFloat(album.Price, 'f', -1, 64)
+	v.Price.Currency = "USD"
+	return v
+}
+
+func fromV2(v albumV2) (Album, error) {
+	price, err := strconv.ParseFloat(v.Price.Amount, 64)
+	if err != nil {
+		return Album{}, fmt.Errorf("recordsclient: album %s has an invalid price %q", v.ID, v.Price.Amount)
+	}
+	return Album{ID: v.ID, Title: v.Title, Artist: v.Artist.Name, Price: price, Cover: v.Cover}, nil
+}
@@ -0,0 +1,308 @@
+// Package recordsclient is a typed Go client for the records API in `records_api`.
+//
+//	client, err := recordsclient.New("http://localhost:8080", recordsclient.WithBearerToken(token))
+//	albums, err := client.ListAlbums(ctx)
+//
+// Every call takes a `context.Context`, transient failures are retried, and error responses
+// are returned as `*APIError` carrying the problem details sent by the server. The client speaks
+// version 2 of the API, so it is unaffected by the deprecation of `/v1`.
+package recordsclient
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// `Client` talks to one records API server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	headers    http.Header
+	auth       func(*http.Request)
+
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// `Option` configures a `Client`.
+type Option func(*Client)
+
+// `WithHTTPClient` replaces the `http.Client` used for requests, e.g. to configure TLS or proxies.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// `WithTimeout` limits how long a single attempt may take. Use the context to limit a call including its retries.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Timeout = timeout
+		c.httpClient = &httpClient
+	}
+}
+
+// `WithTLSConfig` sets the TLS configuration for HTTPS, e.g. to trust a private CA or to present a
+// client certificate.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		httpClient := *c.httpClient
+		httpClient.Transport = transport
+		c.httpClient = &httpClient
+	}
+}
+
+// `WithRetries` sets how often a failed request is retried and the initial delay between attempts,
+// which doubles on every retry. Zero retries disables retrying.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) { c.maxRetries, c.retryBackoff = maxRetries, backoff }
+}
+
+// `WithBearerToken` sends `Authorization: Bearer <token>` with every request.
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+}
+
+// `WithBasicAuth` sends HTTP basic authentication with every request.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.auth = func(req *http.Request) { req.SetBasicAuth(username, password) }
+	}
+}
+
+// `WithHeader` sends an extra header with every request.
+func WithHeader(name, value string) Option {
+	return func(c *Client) { c.headers.Set(name, value) }
+}
+
+// `New` returns a client for the records API at `baseURL`, e.g. `http://localhost:8080`.
+// By default a request attempt times out after 30 seconds and is retried twice.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("recordsclient: invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("recordsclient: base URL must be http or https, got %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:      u,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		headers:      http.Header{"User-Agent": {"recordsclient"}},
+		maxRetries:   2,
+		retryBackoff: 200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// `ListAlbums` returns all albums.
+func (c *Client) ListAlbums(ctx context.Context) ([]Album, error) {
+	var listed []albumV2
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v2/albums"}, &listed); err != nil {
+		return nil, err
+	}
+	albums := make([]Album, len(listed))
+	for i, v := range listed {
+		var err error
+		if albums[i], err = fromV2(v); err != nil {
+			return nil, err
+		}
+	}
+	return albums, nil
+}
+
+// `GetAlbum` returns the album with the given ID. A missing album is an error matching `ErrNotFound`.
+func (c *Client) GetAlbum(ctx context.Context, id string) (Album, error) {
+	var album albumV2
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/v2/albums/" + url.PathEscape(id)}, &album); err != nil {
+		return Album{}, err
+	}
+	return fromV2(album)
+}
+
+// `CreateAlbum` adds an album and returns it as stored, with its ID filled in if it was empty.
+// The request carries a fresh `Idempotency-Key`, so retrying it never creates the album twice.
+func (c *Client) CreateAlbum(ctx context.Context, album Album) (Album, error) {
+	body, err := json.Marshal(toV2(album))
+	if err != nil {
+		return Album{}, err
+	}
+	var created albumV2
+	err = c.do(ctx, request{
+		method:         http.MethodPost,
+		path:           "/v2/albums",
+		body:           body,
+		contentType:    "application/json",
+		idempotencyKey: newIdempotencyKey(),
+	}, &created)
+	if err != nil {
+		return Album{}, err
+	}
+	return fromV2(created)
+}
+
+// `UpdateAlbum` replaces the title, artist and price of the album with `album.ID`.
+func (c *Client) UpdateAlbum(ctx context.Context, album Album) (Album, error) {
+	body, err := json.Marshal(toV2(album))
+	if err != nil {
+		return Album{}, err
+	}
+	var updated albumV2
+	err = c.do(ctx, request{
+		method:      http.MethodPut,
+		path:        "/v2/albums/" + url.PathEscape(album.ID),
+		body:        body,
+		contentType: "application/json",
+	}, &updated)
+	if err != nil {
+		return Album{}, err
+	}
+	return fromV2(updated)
+}
+
+// `DeleteAlbum` deletes the album with the given ID.
+func (c *Client) DeleteAlbum(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/v2/albums/" + url.PathEscape(id)}, nil)
+}
+
+// `UploadCover` sets the cover image of an album. The server accepts JPEG, PNG and GIF images.
+func (c *Client) UploadCover(ctx context.Context, albumID string, image io.Reader) (Cover, error) {
+	// The body is buffered so that it can be sent again on a retry.
+	body, err := io.ReadAll(image)
+	if err != nil {
+		return Cover{}, err
+	}
+	var cover Cover
+	err = c.do(ctx, request{
+		method:      http.MethodPut,
+		path:        "/v2/albums/" + url.PathEscape(albumID) + "/cover",
+		body:        body,
+		contentType: "application/octet-stream",
+	}, &cover)
+	return cover, err
+}
+
+// `request` is one call to the API, possibly sent several times.
+type request struct {
+	method         string
+	path           string // escaped
+	query          url.Values
+	body           []byte
+	contentType    string
+	idempotencyKey string
+}
+
+// `retryable` reports whether sending the request twice has the same effect as sending it once.
+func (r request) retryable() bool {
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.idempotencyKey != ""
+}
+
+// `do` sends a request, retrying transient failures, and decodes a successful JSON response into `out`.
+func (c *Client) do(ctx context.Context, r request, out any) error {
+	retryable := r.retryable()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var resp *http.Response
+		resp, err = c.send(ctx, r)
+		if err == nil {
+			err = decodeResponse(resp, out)
+		}
+		if err == nil || !retryable || attempt >= c.maxRetries || !temporary(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := c.retryBackoff << attempt
+		delay += rand.N(delay/2 + 1) // jitter, so that many clients don't retry in lockstep
+		if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	// `path` is already escaped, so join it as such rather than assigning it to `u.Path`.
+	u := c.baseURL.JoinPath(r.path)
+	u.RawQuery = r.query.Encode()
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if r.idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", r.idempotencyKey)
+	}
+	if c.auth != nil {
+		c.auth(req)
+	}
+	return c.httpClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("recordsclient: decoding response: %w", err)
+	}
+	return nil
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	cryptorand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// `parseRetryAfter` understands the delay-seconds form of the `Retry-After` header.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
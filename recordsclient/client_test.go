@@ -0,0 +1,204 @@
+package recordsclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	recordsAPI "learn_go/records_api"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// `newTestClient` runs the real records API router behind an `httptest.Server`.
+func newTestClient(t *testing.T, opts ...Option) *Client {
+	handler, err := recordsAPI.NewHandler([]string{"-cover-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := New(server.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestListAndGetAlbums(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	albums, err := client.ListAlbums(ctx)
+	if err != nil || len(albums) == 0 {
+		t.Fatalf("ListAlbums = %d albums, %v", len(albums), err)
+	}
+
+	album, err := client.GetAlbum(ctx, albums[0].ID)
+	if err != nil || album != albums[0] {
+		t.Errorf("GetAlbum(%q) = %+v, %v; want %+v", albums[0].ID, album, err, albums[0])
+	}
+}
+
+func TestGetAlbumNotFound(t *testing.T) {
+	_, err := newTestClient(t).GetAlbum(context.Background(), "no such album")
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v; want an *APIError matching ErrNotFound", err)
+	}
+	if apiErr.Message != "album not found" {
+		t.Errorf("message = %q; want the server's message", apiErr.Message)
+	}
+}
+
+func TestCreateAlbum(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	created, err := client.CreateAlbum(ctx, Album{Title: "Giant", Artist: "Coltrane", Price: 19.99})
+	if err != nil || created.ID == "" {
+		t.Fatalf("CreateAlbum = %+v, %v", created, err)
+	}
+	if got, err := client.GetAlbum(ctx, created.ID); err != nil || got != created {
+		t.Errorf("GetAlbum after create = %+v, %v", got, err)
+	}
+
+	_, err = client.CreateAlbum(ctx, Album{Title: "Giant", Price: -1})
+	var apiErr *APIError
+	if !errors.Is(err, ErrInvalid) || !errors.As(err, &apiErr) || len(apiErr.FieldErrors) == 0 {
+		t.Errorf("invalid album: err = %v; want ErrInvalid with the invalid fields", err)
+	}
+	_, err = client.CreateAlbum(ctx, created)
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("duplicate ID: err = %v; want ErrConflict", err)
+	}
+}
+
+func TestUpdateAndDeleteAlbum(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	updated, err := client.UpdateAlbum(ctx, Album{ID: "2", Title: "Jeru", Artist: "Mulligan", Price: 9.99})
+	if err != nil || updated.Price != 9.99 {
+		t.Fatalf("UpdateAlbum = %+v, %v", updated, err)
+	}
+	if err := client.DeleteAlbum(ctx, "2"); err != nil {
+		t.Fatalf("DeleteAlbum = %v", err)
+	}
+	if _, err := client.GetAlbum(ctx, "2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAlbum after delete: err = %v; want ErrNotFound", err)
+	}
+	if err := client.DeleteAlbum(ctx, "2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeleteAlbum: err = %v; want ErrNotFound", err)
+	}
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, WithRetries(2, time.Millisecond))
+	if _, err := client.ListAlbums(context.Background()); err != nil {
+		t.Fatalf("ListAlbums = %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("server saw %d attempts; want 3", calls.Load())
+	}
+
+	calls.Store(0)
+	client, _ = New(server.URL, WithRetries(0, time.Millisecond))
+	if _, err := client.ListAlbums(context.Background()); err == nil || calls.Load() != 1 {
+		t.Errorf("without retries: err = %v after %d attempts; want an error after 1", err, calls.Load())
+	}
+}
+
+func TestCreateAlbumRetryIsIdempotent(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"7","artist":{"name":"Coltrane"},"price":{"amount":"9.99","currency":"USD"}}`))
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, WithRetries(1, time.Millisecond))
+	if _, err := client.CreateAlbum(context.Background(), Album{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Idempotency-Key per attempt = %q; want the same non-empty key twice", keys)
+	}
+}
+
+func TestAuthAndTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("X-Team") != "ops" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, WithBearerToken("s3cret"), WithHeader("X-Team", "ops"))
+	if _, err := client.ListAlbums(context.Background()); err != nil {
+		t.Errorf("with credentials: %v", err)
+	}
+	client, _ = New(server.URL)
+	if _, err := client.ListAlbums(context.Background()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("without credentials: err = %v; want ErrUnauthorized", err)
+	}
+
+	client, _ = New(server.URL, WithBearerToken("s3cret"), WithHeader("X-Team", "ops"),
+		WithTimeout(20*time.Millisecond), WithRetries(0, 0))
+	err := client.do(context.Background(), request{method: http.MethodGet, path: "/albums", query: map[string][]string{"slow": {"1"}}}, nil)
+	if err == nil {
+		t.Error("expected a timeout")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	handler, err := recordsAPI.NewHandler([]string{"-cover-dir", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	insecure, _ := New(server.URL, WithRetries(0, 0))
+	if _, err := insecure.ListAlbums(context.Background()); err == nil {
+		t.Error("untrusted server certificate was accepted")
+	}
+
+	client, err := New(server.URL, WithTLSConfig(server.Client().Transport.(*http.Transport).TLSClientConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListAlbums(context.Background()); err != nil {
+		t.Errorf("ListAlbums over HTTPS: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package recordsclient
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors to test an `*APIError` against with `errors.Is`.
+var (
+	ErrNotFound     = errors.New("recordsclient: not found")
+	ErrConflict     = errors.New("recordsclient: conflict")
+	ErrInvalid      = errors.New("recordsclient: invalid request")
+	ErrUnauthorized = errors.New("recordsclient: unauthorized")
+)
+
+// `APIError` is an error response of the records API.
+type APIError struct {
+	StatusCode int
+	// The `detail` of the problem sent by the server, or its `title`, or the status text if the body had neither.
+	Message string
+	// The problem type URI, e.g. `/problems/not-found`, if the server sent one.
+	Type string
+	// What was wrong with each field, for validation problems.
+	FieldErrors []FieldError
+	// The raw response body.
+	Body []byte
+	// Set from the `Retry-After` header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("recordsclient: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// `Is` lets `errors.Is(err, ErrNotFound)` and friends match on the status code.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// `FieldError` is a reason a field of a request failed validation.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// The error body of the records API, an RFC 7807 problem.
+type errorBody struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Detail string       `json:"detail"`
+	Errors []FieldError `json:"errors"`
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var decoded errorBody
+	if json.Unmarshal(body, &decoded) == nil {
+		apiErr.Type, apiErr.FieldErrors = decoded.Type, decoded.Errors
+		apiErr.Message = cmp.Or(decoded.Detail, decoded.Title, apiErr.Message)
+	}
+	return apiErr
+}
+
+// `temporary` reports whether a request that failed with `err` may succeed when tried again.
+func temporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}